@@ -3,6 +3,7 @@ package wire
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/jeroenrinzema/psql-wire/codes"
 	pgerror "github.com/jeroenrinzema/psql-wire/errors"
@@ -20,6 +21,17 @@ const (
 	// authClearTextPassword is a authentication type used to tell the client to identify
 	// itself by sending the password in clear text to the Postgres server.
 	authClearTextPassword authType = 3
+	// authMD5Password is a authentication type used to tell the client to identify
+	// itself by sending a salted MD5 hash of its password.
+	authMD5Password authType = 5
+	// authSASL announces to the client that it should authenticate using one
+	// of the listed SASL mechanisms.
+	authSASL authType = 10
+	// authSASLContinue carries a SASL challenge which has to be answered by the
+	// client.
+	authSASLContinue authType = 11
+	// authSASLFinal carries the additional data of a completed SASL exchange.
+	authSASLFinal authType = 12
 )
 
 // AuthStrategy represents an authentication strategy used to authenticate a user.
@@ -27,7 +39,9 @@ type AuthStrategy func(ctx context.Context, writer *buffer.Writer, reader *buffe
 
 // BackendKeyDataFunc represents a function that generates backend key data for query cancellation.
 // It should return a process ID and secret key that can be used by clients to cancel queries.
-type BackendKeyDataFunc func(ctx context.Context) (processID int32, secretKey int32)
+// The secret key has to be exactly 4 bytes long for connections using protocol version 3.0 and
+// could be up to 256 bytes long for connections using protocol version 3.2, see [ProtocolVersion].
+type BackendKeyDataFunc func(ctx context.Context) (processID int32, secretKey []byte)
 
 // handleAuth handles the client authentication for the given connection.
 // This methods validates the incoming credentials and writes to the client whether
@@ -36,13 +50,22 @@ type BackendKeyDataFunc func(ctx context.Context) (processID int32, secretKey in
 func (srv *Server) handleAuth(ctx context.Context, reader *buffer.Reader, writer *buffer.Writer) (context.Context, error) {
 	srv.logger.Debug("authenticating client connection")
 
-	if srv.Auth == nil {
+	auth := srv.Auth
+	if len(srv.HostBasedAuth) > 0 {
+		var err error
+		auth, err = srv.hostBasedAuth(ctx)
+		if err != nil {
+			return ctx, writeAuthFailure(writer, err)
+		}
+	}
+
+	if auth == nil {
 		// No authentication strategy configured.
 		// Announcing to the client that the connection is authenticated
 		return ctx, writeAuthType(writer, authOK)
 	}
 
-	return srv.Auth(ctx, writer, reader)
+	return auth(ctx, writer, reader)
 }
 
 // ClearTextPassword announces to the client to authenticate by sending a
@@ -77,18 +100,46 @@ func ClearTextPassword(validate func(ctx context.Context, database, username, pa
 		}
 
 		if !valid {
-			authErr := pgerror.WithSeverity(pgerror.WithCode(errors.New("invalid username/password"), codes.InvalidPassword), pgerror.LevelFatal)
-			err = WriteUnterminatedError(writer, authErr)
-			if err != nil {
-				return ctx, err
-			}
-			return ctx, authErr
+			return ctx, writeAuthFailure(writer, newErrInvalidPassword())
 		}
 
 		return ctx, writeAuthType(writer, authOK)
 	}
 }
 
+// newErrInvalidPassword is returned whenever the credentials presented by the
+// client could not be validated.
+func newErrInvalidPassword() error {
+	err := errors.New("invalid username/password")
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidPassword), pgerror.LevelFatal)
+}
+
+// newErrInvalidAuthorization is returned whenever the client is not authorized
+// to connect as the requested user.
+func newErrInvalidAuthorization(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal)
+}
+
+// newErrAuthProtocolViolation is returned whenever the client sends an
+// unexpected or malformed message during authentication.
+func newErrAuthProtocolViolation(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.ProtocolViolation), pgerror.LevelFatal)
+}
+
+// writeAuthFailure writes the given authentication error to the client and
+// returns it. The connection is expected to be closed afterwards. An error is
+// returned when the error could not be written to the client.
+func writeAuthFailure(writer *buffer.Writer, authErr error) error {
+	err := WriteUnterminatedError(writer, authErr)
+	if err != nil {
+		return err
+	}
+
+	return authErr
+}
+
 // writeAuthType writes the auth type to the client informing the client about the
 // authentication status and the expected data to be received.
 func writeAuthType(writer *buffer.Writer, status authType) error {
@@ -97,13 +148,35 @@ func writeAuthType(writer *buffer.Writer, status authType) error {
 	return writer.End()
 }
 
+// writeAuthSASL announces to the client that it should authenticate using one of
+// the given SASL mechanisms. The mechanisms are listed in order of preference.
+func writeAuthSASL(writer *buffer.Writer, mechanisms []string) error {
+	writer.Start(types.ServerAuth)
+	writer.AddInt32(int32(authSASL))
+	for _, mechanism := range mechanisms {
+		writer.AddString(mechanism)
+		writer.AddNullTerminate()
+	}
+	writer.AddNullTerminate()
+	return writer.End()
+}
+
+// writeAuthSASLData writes a SASL challenge (authSASLContinue) or the outcome
+// of a completed exchange (authSASLFinal) containing the given data.
+func writeAuthSASLData(writer *buffer.Writer, status authType, data []byte) error {
+	writer.Start(types.ServerAuth)
+	writer.AddInt32(int32(status))
+	writer.AddBytes(data)
+	return writer.End()
+}
+
 // writeBackendKeyData writes the backend key data to the client. This message contains
 // cancellation key data that the frontend must save if it wishes to be able to issue
 // CancelRequest messages later.
-func writeBackendKeyData(writer *buffer.Writer, processID int32, secretKey int32) error {
+func writeBackendKeyData(writer *buffer.Writer, processID int32, secretKey []byte) error {
 	writer.Start(types.ServerBackendKeyData)
 	writer.AddInt32(processID)
-	writer.AddInt32(secretKey)
+	writer.AddBytes(secretKey)
 	return writer.End()
 }
 
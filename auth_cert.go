@@ -0,0 +1,69 @@
+package wire
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// ClientCertificateMapFn maps the identity presented inside the verified
+// client certificate to the requested database user, similar to the
+// PostgreSQL pg_ident.conf user name maps. True should be returned when the
+// identity is allowed to connect as the given user.
+// https://www.postgresql.org/docs/current/auth-username-maps.html
+type ClientCertificateMapFn func(ctx context.Context, identity, username string) (context.Context, bool, error)
+
+// ClientCertificate authenticates the client using the client certificate
+// presented during the TLS handshake. The client certificate has to be
+// verified by the server, see [ClientAuth]. The common name (CN) and subject
+// alternative names (SAN) of the certificate are passed, in that order, to
+// the given mapping function until an identity is allowed to connect as the
+// requested user (received inside the client parameters). The connection is
+// rejected when no valid client certificate is presented or none of the
+// identities is mapped to the requested user. The certificate chain could be
+// retrieved using [ClientCertificates].
+// https://www.postgresql.org/docs/current/auth-cert.html
+func ClientCertificate(mapping ClientCertificateMapFn) AuthStrategy {
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		params := ClientParameters(ctx)
+		username := params[ParamUsername]
+
+		state := TLSConnectionState(ctx)
+		if state == nil || len(state.VerifiedChains) == 0 {
+			return ctx, writeAuthFailure(writer, newErrInvalidAuthorization("connection requires a valid client certificate"))
+		}
+
+		for _, identity := range certificateIdentities(state.VerifiedChains[0][0]) {
+			var valid bool
+			ctx, valid, err = mapping(ctx, identity, username)
+			if err != nil {
+				return ctx, err
+			}
+
+			if valid {
+				return ctx, writeAuthType(writer, authOK)
+			}
+		}
+
+		return ctx, writeAuthFailure(writer, newErrInvalidAuthorization("certificate authentication failed for user %q", username))
+	}
+}
+
+// certificateIdentities returns the identities presented inside the given
+// certificate. The common name is returned first followed by the DNS names,
+// email addresses and URIs of the subject alternative names.
+func certificateIdentities(certificate *x509.Certificate) []string {
+	identities := make([]string, 0, 1+len(certificate.DNSNames)+len(certificate.EmailAddresses)+len(certificate.URIs))
+	if certificate.Subject.CommonName != "" {
+		identities = append(identities, certificate.Subject.CommonName)
+	}
+
+	identities = append(identities, certificate.DNSNames...)
+	identities = append(identities, certificate.EmailAddresses...)
+	for _, uri := range certificate.URIs {
+		identities = append(identities, uri.String())
+	}
+
+	return identities
+}
@@ -0,0 +1,128 @@
+package wire
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+// generateTestClientCert generates a client certificate for the given common
+// name and email address signed by a newly generated certificate authority.
+func generateTestClientCert(t *testing.T, commonName, email string) (*x509.CertPool, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: commonName},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return pool, tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+}
+
+func TestClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	certificate, err := generateTestCert()
+	require.NoError(t, err)
+
+	pool, client := generateTestClientCert(t, "John Doe", "john@example.com")
+
+	// NOTE: the identity map mirrors a pg_ident.conf entry mapping the email
+	// address of the certificate to the john database user.
+	mapping := func(ctx context.Context, identity, username string) (context.Context, bool, error) {
+		return ctx, identity == "john@example.com" && username == "john", nil
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		chain := ClientCertificates(ctx)
+		if len(chain) != 2 || chain[0].Subject.CommonName != "John Doe" {
+			t.Errorf("unexpected client certificate chain: %v", chain)
+		}
+
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler,
+		Logger(slogt.New(t)),
+		TLSConfig(&tls.Config{Certificates: []tls.Certificate{certificate}, ClientCAs: pool}),
+		ClientAuth(tls.VerifyClientCertIfGiven),
+		SessionAuthStrategy(ClientCertificate(mapping)),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T, username string, certificates ...tls.Certificate) (*pgx.Conn, error) {
+		ctx := context.Background()
+		config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s@%s:%d?sslmode=require", username, address.IP, address.Port))
+		require.NoError(t, err)
+
+		config.TLSConfig.Certificates = certificates
+		return pgx.ConnectConfig(ctx, config)
+	}
+
+	t.Run("mapped user", func(t *testing.T) {
+		ctx := context.Background()
+		conn, err := connect(t, "john", client)
+		require.NoError(t, err)
+
+		_, err = conn.Exec(ctx, "SELECT 1")
+		require.NoError(t, err)
+		require.NoError(t, conn.Close(ctx))
+	})
+
+	t.Run("unmapped user", func(t *testing.T) {
+		_, err := connect(t, "admin", client)
+		require.ErrorContains(t, err, "certificate authentication failed")
+	})
+
+	t.Run("missing certificate", func(t *testing.T) {
+		_, err := connect(t, "john")
+		require.ErrorContains(t, err, "client certificate")
+	})
+}
@@ -0,0 +1,98 @@
+package wire
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// md5Prefix is the prefix of MD5 hashed passwords and client responses.
+const md5Prefix = "md5"
+
+// MD5PasswordValidateFn validates the MD5 response of the client. The
+// response is constructed by the client as "md5" followed by
+// md5(md5(password + username) + salt) encoded as hexadecimal string. Use
+// [VerifyMD5Password] to verify the response against a stored password hash.
+type MD5PasswordValidateFn func(ctx context.Context, database, username string, salt [4]byte, response string) (context.Context, bool, error)
+
+// MD5Password announces to the client to authenticate by sending a salted MD5
+// hash of its password. A random salt is generated for each connection. The
+// given function is called to validate the response of the user (received
+// inside the client parameters). If the provided credentials are invalid or any
+// unexpected error occurs, an error is returned and the connection should be
+// closed.
+//
+// MD5 password authentication is deprecated by PostgreSQL and should only be
+// used to support legacy clients. Use [ScramSHA256] whenever possible.
+// https://www.postgresql.org/docs/current/auth-password.html
+func MD5Password(validate MD5PasswordValidateFn) AuthStrategy {
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		var salt [4]byte
+		_, err = rand.Read(salt[:])
+		if err != nil {
+			return ctx, err
+		}
+
+		writer.Start(types.ServerAuth)
+		writer.AddInt32(int32(authMD5Password))
+		writer.AddBytes(salt[:])
+		err = writer.End()
+		if err != nil {
+			return ctx, err
+		}
+
+		params := ClientParameters(ctx)
+		t, _, err := reader.ReadTypedMsg()
+		if err != nil {
+			return ctx, err
+		}
+
+		if t != types.ClientPassword {
+			return ctx, newErrAuthProtocolViolation("expected password response, got message type %q", t)
+		}
+
+		response, err := reader.GetString()
+		if err != nil {
+			return ctx, err
+		}
+
+		ctx, valid, err := validate(ctx, params[ParamDatabase], params[ParamUsername], salt, response)
+		if err != nil {
+			return ctx, err
+		}
+
+		if !valid {
+			return ctx, writeAuthFailure(writer, newErrInvalidPassword())
+		}
+
+		return ctx, writeAuthType(writer, authOK)
+	}
+}
+
+// MD5PasswordHash returns the MD5 hash of the given password as stored by
+// PostgreSQL: "md5" followed by md5(password + username) encoded as
+// hexadecimal string.
+func MD5PasswordHash(username, password string) string {
+	return md5Prefix + md5Hex(password+username)
+}
+
+// VerifyMD5Password verifies the MD5 response of a client against the given
+// stored password hash (see [MD5PasswordHash]) and salt send to the client.
+func VerifyMD5Password(stored string, salt [4]byte, response string) bool {
+	if len(stored) != len(md5Prefix)+2*md5.Size || stored[:len(md5Prefix)] != md5Prefix {
+		return false
+	}
+
+	expected := md5Prefix + md5Hex(stored[len(md5Prefix):]+string(salt[:]))
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(response)) == 1
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value)) //nolint:gosec
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,83 @@
+package wire
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyMD5Password(t *testing.T) {
+	t.Parallel()
+
+	stored := MD5PasswordHash("john", "password")
+	require.Equal(t, "md5"+md5Hex("passwordjohn"), stored)
+
+	salt := [4]byte{0x01, 0x02, 0x03, 0x04}
+	response := "md5" + md5Hex(stored[3:]+string(salt[:]))
+
+	require.True(t, VerifyMD5Password(stored, salt, response))
+	require.False(t, VerifyMD5Password(stored, [4]byte{}, response))
+	require.False(t, VerifyMD5Password(MD5PasswordHash("john", "wrong"), salt, response))
+	require.False(t, VerifyMD5Password("password", salt, response))
+}
+
+func TestMD5Password(t *testing.T) {
+	t.Parallel()
+
+	stored := MD5PasswordHash("john", "password")
+	validate := func(ctx context.Context, database, username string, salt [4]byte, response string) (context.Context, bool, error) {
+		if username != "john" {
+			return ctx, false, nil
+		}
+
+		return ctx, VerifyMD5Password(stored, salt, response), nil
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(MD5Password(validate)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("jackc/pgx", func(t *testing.T) {
+		ctx := context.Background()
+
+		connstr := fmt.Sprintf("postgres://john:password@%s:%d?sslmode=disable", address.IP, address.Port)
+		conn, err := pgx.Connect(ctx, connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Ping(ctx))
+		require.NoError(t, conn.Close(ctx))
+
+		connstr = fmt.Sprintf("postgres://john:wrong@%s:%d?sslmode=disable", address.IP, address.Port)
+		_, err = pgx.Connect(ctx, connstr)
+		require.Error(t, err)
+
+		connstr = fmt.Sprintf("postgres://unknown:password@%s:%d?sslmode=disable", address.IP, address.Port)
+		_, err = pgx.Connect(ctx, connstr)
+		require.Error(t, err)
+	})
+
+	t.Run("lib/pq", func(t *testing.T) {
+		connstr := fmt.Sprintf("host=%s port=%d user=john password=password sslmode=disable", address.IP, address.Port)
+		conn, err := sql.Open("postgres", connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Ping())
+		require.NoError(t, conn.Close())
+
+		connstr = fmt.Sprintf("host=%s port=%d user=john password=wrong sslmode=disable", address.IP, address.Port)
+		conn, err = sql.Open("postgres", connstr)
+		require.NoError(t, err)
+		require.Error(t, conn.Ping())
+		require.NoError(t, conn.Close())
+	})
+}
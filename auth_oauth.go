@@ -0,0 +1,216 @@
+package wire
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// oauthBearer is the name of the OAUTHBEARER SASL mechanism.
+// https://www.postgresql.org/docs/current/sasl-authentication.html#SASL-OAUTHBEARER
+const oauthBearer = "OAUTHBEARER"
+
+// oauthWellKnown is the path of the OpenID discovery document relative to the
+// issuer.
+const oauthWellKnown = "/.well-known/openid-configuration"
+
+// OAuthBearerValidateFn validates the bearer token presented by the client for
+// the given database and username. The returned context is used for the
+// remainder of the connection, allowing the token claims to be stored inside
+// the connection context.
+type OAuthBearerValidateFn func(ctx context.Context, database, username, token string) (context.Context, bool, error)
+
+// OAuthBearer announces to the client to authenticate using the OAUTHBEARER
+// SASL mechanism introduced in PostgreSQL 18. The bearer token send by the
+// client is passed to the given function to be validated. Clients which do
+// not have a token yet receive the discovery document of the given issuer and
+// scope, allowing the client to request a token from the issuer before
+// reconnecting. If the token is invalid or any unexpected error occurs, an
+// error is returned and the connection should be closed.
+// https://www.postgresql.org/docs/current/auth-oauth.html
+func OAuthBearer(issuer, scope string, validate OAuthBearerValidateFn) AuthStrategy {
+	return SASL(OAuthBearerMechanism(issuer, scope, validate))
+}
+
+// OAuthBearerMechanism returns the OAUTHBEARER SASL mechanism which could be
+// combined with other mechanisms using the [SASL] authentication strategy.
+func OAuthBearerMechanism(issuer, scope string, validate OAuthBearerValidateFn) SASLMechanism {
+	return &oauthMechanism{
+		issuer:   issuer,
+		scope:    scope,
+		validate: validate,
+	}
+}
+
+// oauthMechanism implements the OAUTHBEARER SASL mechanism.
+// https://datatracker.ietf.org/doc/html/rfc7628
+type oauthMechanism struct {
+	issuer   string
+	scope    string
+	validate OAuthBearerValidateFn
+}
+
+func (mechanism *oauthMechanism) Name() string {
+	return oauthBearer
+}
+
+func (mechanism *oauthMechanism) Supported(ctx context.Context) bool {
+	return true
+}
+
+func (mechanism *oauthMechanism) Start(ctx context.Context) (SASLExchange, error) {
+	params := ClientParameters(ctx)
+	return &oauthExchange{
+		mechanism: mechanism,
+		database:  params[ParamDatabase],
+		username:  params[ParamUsername],
+	}, nil
+}
+
+// oauthExchange represents the server side of a single OAUTHBEARER exchange.
+type oauthExchange struct {
+	mechanism *oauthMechanism
+	database  string
+	username  string
+	// failed is set once the error status has been send to the client. The
+	// client is expected to acknowledge the failure before the exchange is
+	// aborted.
+	failed bool
+}
+
+// Next implements the [SASLExchange] interface.
+func (exchange *oauthExchange) Next(ctx context.Context, data []byte) (_ context.Context, challenge []byte, done bool, err error) {
+	if exchange.failed {
+		// NOTE: the client acknowledges the error status by sending a single
+		// kvsep as dummy response.
+		if string(data) != "\x01" {
+			return ctx, nil, false, newErrAuthProtocolViolation("malformed OAUTHBEARER message: expected dummy client response")
+		}
+
+		return ctx, nil, false, newErrInvalidAuthorization("OAuth bearer authentication failed")
+	}
+
+	if data == nil {
+		return ctx, nil, false, newErrAuthProtocolViolation("malformed OAUTHBEARER message: initial client response is required")
+	}
+
+	token, err := parseOAuthBearerResponse(string(data))
+	if err != nil {
+		return ctx, nil, false, err
+	}
+
+	if token != "" {
+		var valid bool
+		ctx, valid, err = exchange.mechanism.validate(ctx, exchange.database, exchange.username, token)
+		if err != nil {
+			if pgerror.GetCode(err) == codes.Uncategorized {
+				err = pgerror.WithCode(err, codes.InvalidAuthorizationSpecification)
+			}
+
+			return ctx, nil, false, err
+		}
+
+		if valid {
+			return ctx, nil, true, nil
+		}
+	}
+
+	// NOTE: clients without (valid) token receive the error status
+	// containing the discovery document of the issuer.
+	challenge, err = exchange.mechanism.errorStatus()
+	if err != nil {
+		return ctx, nil, false, err
+	}
+
+	exchange.failed = true
+	return ctx, challenge, false, nil
+}
+
+// oauthErrorStatus represents the JSON error status send to the client when
+// the presented token is missing or invalid.
+// https://datatracker.ietf.org/doc/html/rfc7628#section-3.2.2
+type oauthErrorStatus struct {
+	Status        string `json:"status"`
+	Scope         string `json:"scope,omitempty"`
+	Configuration string `json:"openid-configuration"`
+}
+
+func (mechanism *oauthMechanism) errorStatus() ([]byte, error) {
+	configuration := mechanism.issuer
+	if !strings.Contains(configuration, "/.well-known/") {
+		configuration = strings.TrimSuffix(configuration, "/") + oauthWellKnown
+	}
+
+	return json.Marshal(oauthErrorStatus{
+		Status:        "invalid_token",
+		Scope:         mechanism.scope,
+		Configuration: configuration,
+	})
+}
+
+// parseOAuthBearerResponse parses the initial client response and returns the
+// bearer token. An empty token is returned when the client requests the
+// discovery document.
+//
+//	client-resp = (gs2-header kvsep *kvpair kvsep) / kvsep
+//	kvpair = key "=" value kvsep
+//	kvsep = %x01
+func parseOAuthBearerResponse(message string) (string, error) {
+	header, rest, ok := strings.Cut(message, "\x01")
+	if !ok {
+		return "", newErrAuthProtocolViolation("malformed OAUTHBEARER message: missing kvsep")
+	}
+
+	flag, authzid, ok := strings.Cut(header, ",")
+	if !ok || !strings.HasSuffix(authzid, ",") {
+		return "", newErrAuthProtocolViolation("malformed OAUTHBEARER message: invalid gs2 header")
+	}
+
+	switch {
+	case flag == "n", flag == "y":
+	case strings.HasPrefix(flag, "p="):
+		return "", newErrAuthProtocolViolation("OAUTHBEARER does not support channel binding")
+	default:
+		return "", newErrAuthProtocolViolation("malformed OAUTHBEARER message: unexpected channel binding flag %q", flag)
+	}
+
+	if authzid != "," {
+		return "", newErrAuthProtocolViolation("client uses authorization identity, but it is not supported")
+	}
+
+	// NOTE: the key/value pairs are terminated by a kvsep, the end of all
+	// pairs is identified by an additional kvsep.
+	if rest == "\x01" {
+		return "", nil
+	}
+
+	if !strings.HasSuffix(rest, "\x01\x01") {
+		return "", newErrAuthProtocolViolation("malformed OAUTHBEARER message: missing final kvsep")
+	}
+
+	var auth string
+	for _, pair := range strings.Split(strings.TrimSuffix(rest, "\x01\x01"), "\x01") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return "", newErrAuthProtocolViolation("malformed OAUTHBEARER message: invalid key/value pair")
+		}
+
+		if key == "auth" {
+			auth = value
+		}
+	}
+
+	if auth == "" {
+		return "", nil
+	}
+
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", newErrAuthProtocolViolation("malformed OAUTHBEARER message: expected bearer token")
+	}
+
+	return strings.TrimLeft(token, " "), nil
+}
@@ -0,0 +1,194 @@
+package wire
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+// signTestToken constructs a HS256 signed JWT containing the given claims.
+func signTestToken(t *testing.T, key []byte, claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyTestToken verifies the signature of the given HS256 signed JWT and
+// returns its claims.
+func verifyTestToken(key []byte, token string) (map[string]any, bool) {
+	index := strings.LastIndex(token, ".")
+	if index < 0 {
+		return nil, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(token[index+1:])
+	if err != nil {
+		return nil, false
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token[:index]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, false
+	}
+
+	_, payload, _ := strings.Cut(token[:index], ".")
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+
+	claims := map[string]any{}
+	if json.Unmarshal(decoded, &claims) != nil {
+		return nil, false
+	}
+
+	return claims, true
+}
+
+func TestParseOAuthBearerResponse(t *testing.T) {
+	t.Parallel()
+
+	valid := map[string]string{
+		"n,,\x01auth=Bearer abc.def.ghi\x01\x01":              "abc.def.ghi",
+		"y,,\x01host=localhost\x01auth=bearer  token\x01\x01": "token",
+		"n,,\x01\x01":          "",
+		"n,,\x01auth=\x01\x01": "",
+	}
+
+	for message, expected := range valid {
+		token, err := parseOAuthBearerResponse(message)
+		require.NoError(t, err, message)
+		require.Equal(t, expected, token)
+	}
+
+	invalid := []string{
+		"",
+		"n,,",
+		"n,,\x01auth=Bearer token\x01",
+		"p=tls-server-end-point,,\x01auth=Bearer token\x01\x01",
+		"n,a=admin,\x01auth=Bearer token\x01\x01",
+		"n,,\x01auth=Basic token\x01\x01",
+		"n,,\x01invalid\x01\x01",
+	}
+
+	for _, message := range invalid {
+		_, err := parseOAuthBearerResponse(message)
+		require.Error(t, err, message)
+	}
+}
+
+func TestOAuthBearer(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("secret")
+	type claimsKey struct{}
+
+	validate := func(ctx context.Context, database, username, token string) (context.Context, bool, error) {
+		claims, ok := verifyTestToken(key, token)
+		if !ok || claims["aud"] != "postgres" {
+			return ctx, false, nil
+		}
+
+		return context.WithValue(ctx, claimsKey{}, claims), true, nil
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			if ctx.Value(claimsKey{}) == nil {
+				t.Error("expected the token claims to be set inside the connection context")
+			}
+
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	strategy := OAuthBearer("https://issuer.example.com", "openid postgres", validate)
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(strategy))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T, token string) *mock.Client {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+
+		client.ExpectMsg(t, types.ServerAuth)
+		status, err := client.GetUint32()
+		require.NoError(t, err)
+		require.Equal(t, authSASL, authType(status))
+
+		mechanism, err := client.GetString()
+		require.NoError(t, err)
+		require.Equal(t, oauthBearer, mechanism)
+
+		message := "n,,\x01\x01"
+		if token != "" {
+			message = "n,,\x01auth=Bearer " + token + "\x01\x01"
+		}
+
+		client.Start(types.ClientPassword)
+		client.AddString(oauthBearer)
+		client.AddNullTerminate()
+		client.AddInt32(int32(len(message)))
+		client.AddBytes([]byte(message))
+		require.NoError(t, client.End())
+		return client
+	}
+
+	expectDiscovery := func(t *testing.T, client *mock.Client) {
+		client.ExpectMsg(t, types.ServerAuth)
+		status, err := client.GetUint32()
+		require.NoError(t, err)
+		require.Equal(t, authSASLContinue, authType(status))
+
+		discovery := map[string]string{}
+		require.NoError(t, json.Unmarshal(client.Msg, &discovery))
+		require.Equal(t, map[string]string{
+			"status":               "invalid_token",
+			"scope":                "openid postgres",
+			"openid-configuration": "https://issuer.example.com/.well-known/openid-configuration",
+		}, discovery)
+
+		client.Start(types.ClientPassword)
+		client.AddBytes([]byte("\x01"))
+		require.NoError(t, client.End())
+		client.Error(t)
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		client := connect(t, signTestToken(t, key, map[string]any{"sub": "john", "aud": "postgres"}))
+		client.Authenticate(t)
+		client.ReadyForQuery(t)
+		client.Close(t)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		client := connect(t, signTestToken(t, []byte("other"), map[string]any{"sub": "john", "aud": "postgres"}))
+		expectDiscovery(t, client)
+	})
+
+	t.Run("discovery", func(t *testing.T) {
+		client := connect(t, "")
+		expectDiscovery(t, client)
+	})
+}
@@ -0,0 +1,95 @@
+package wire
+
+import (
+	"context"
+	"net"
+	"os/user"
+	"strconv"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// UnixCredentials represents the credentials of the process connected to the
+// other end of a Unix domain socket.
+type UnixCredentials struct {
+	UID uint32
+	GID uint32
+	// PID is the process ID of the peer process, zero is set when the process
+	// ID is not available on the current platform.
+	PID int32
+	// Username is the operating system user name of the peer process. An empty
+	// string is set when the user could not be resolved.
+	Username string
+}
+
+// PeerMapFn maps the operating system user of the peer process to the
+// requested database user, similar to the PostgreSQL pg_ident.conf user name
+// maps. True should be returned when the peer is allowed to connect as the
+// given user.
+type PeerMapFn func(ctx context.Context, credentials UnixCredentials, username string) (context.Context, bool, error)
+
+// PeerAuth authenticates clients connected over a Unix domain socket using the
+// credentials of the peer process as reported by the operating system. The
+// given function is called to map the peer credentials to the requested user
+// (received inside the client parameters). When no mapping function is given,
+// the operating system user name has to match the requested user. Peer
+// authentication is only available on local connections on supported
+// platforms, other connections are rejected.
+// https://www.postgresql.org/docs/current/auth-peer.html
+func PeerAuth(mapping PeerMapFn) AuthStrategy {
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		params := ClientParameters(ctx)
+		username := params[ParamUsername]
+
+		credentials := PeerCredentials(ctx)
+		if credentials == nil {
+			return ctx, writeAuthFailure(writer, newErrInvalidAuthorization("could not get peer credentials"))
+		}
+
+		valid := credentials.Username != "" && credentials.Username == username
+		if mapping != nil {
+			ctx, valid, err = mapping(ctx, *credentials, username)
+			if err != nil {
+				return ctx, err
+			}
+		}
+
+		if !valid {
+			return ctx, writeAuthFailure(writer, newErrInvalidAuthorization("peer authentication failed for user %q", username))
+		}
+
+		return ctx, writeAuthType(writer, authOK)
+	}
+}
+
+// PeerCredentials returns the credentials of the peer process if the client is
+// connected over a Unix domain socket. Nil is returned for other connections
+// or when the credentials are not available on the current platform.
+func PeerCredentials(ctx context.Context) *UnixCredentials {
+	val := ctx.Value(ctxPeerCredentials)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*UnixCredentials)
+}
+
+func setPeerCredentials(ctx context.Context, credentials *UnixCredentials) context.Context {
+	return context.WithValue(ctx, ctxPeerCredentials, credentials)
+}
+
+// peerCredentials reads the credentials of the peer process connected to the
+// given Unix domain socket and resolves the operating system user name.
+func peerCredentials(conn *net.UnixConn) (*UnixCredentials, error) {
+	credentials, err := readPeerCredentials(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := user.LookupId(strconv.FormatUint(uint64(credentials.UID), 10))
+	if err == nil {
+		credentials.Username = account.Username
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package wire
+
+import (
+	"net"
+	"syscall"
+)
+
+// readPeerCredentials reads the credentials of the peer process using the
+// SO_PEERCRED socket option.
+func readPeerCredentials(conn *net.UnixConn) (*UnixCredentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, sockErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if sockErr != nil {
+		return nil, sockErr
+	}
+
+	return &UnixCredentials{
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+		PID: ucred.Pid,
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package wire
+
+import (
+	"errors"
+	"net"
+)
+
+// readPeerCredentials is not supported on the current platform.
+func readPeerCredentials(conn *net.UnixConn) (*UnixCredentials, error) {
+	return nil, errors.New("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,149 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+// TListenAndServeUnix starts serving the given server on a Unix domain socket
+// inside a temporary directory and returns the socket directory.
+func TListenAndServeUnix(t *testing.T, server *Server, port int) string {
+	dir, err := os.MkdirTemp("", "psql-wire")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+		server.Wait()
+		os.RemoveAll(dir) //nolint:errcheck
+	})
+
+	go server.ListenAndServeUnix(dir, port) //nolint:errcheck
+
+	path := filepath.Join(dir, fmt.Sprintf(".s.PGSQL.%d", port))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	return dir
+}
+
+func TestListenAndServeUnix(t *testing.T) {
+	t.Parallel()
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler,
+		Logger(slogt.New(t)),
+		UnixSocketPermissions(0o700),
+		HostBasedAuthentication(HBARule{Type: HBALocal, Trust: true}),
+	)
+	require.NoError(t, err)
+
+	dir := TListenAndServeUnix(t, server, 5432)
+
+	info, err := os.Stat(filepath.Join(dir, ".s.PGSQL.5432"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=5432 user=john sslmode=disable", dir))
+	require.NoError(t, err)
+
+	_, err = conn.Exec(ctx, "SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close(ctx))
+
+	// NOTE: a socket which is still in use should not be replaced.
+	require.Error(t, server.ListenAndServeUnix(dir, 5432))
+}
+
+func TestPeerAuth(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are not supported on this platform")
+	}
+
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			credentials := PeerCredentials(ctx)
+			if credentials == nil || credentials.PID != int32(os.Getpid()) {
+				t.Errorf("unexpected peer credentials: %+v", credentials)
+			}
+
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	t.Run("system user", func(t *testing.T) {
+		t.Parallel()
+
+		server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(PeerAuth(nil)))
+		require.NoError(t, err)
+
+		dir := TListenAndServeUnix(t, server, 5432)
+		ctx := context.Background()
+
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=5432 user=%s sslmode=disable", dir, current.Username))
+		require.NoError(t, err)
+
+		_, err = conn.Exec(ctx, "SELECT 1")
+		require.NoError(t, err)
+		require.NoError(t, conn.Close(ctx))
+
+		_, err = pgx.Connect(ctx, fmt.Sprintf("host=%s port=5432 user=unknown sslmode=disable", dir))
+		require.ErrorContains(t, err, "peer authentication failed")
+	})
+
+	t.Run("mapping", func(t *testing.T) {
+		t.Parallel()
+
+		mapping := func(ctx context.Context, credentials UnixCredentials, username string) (context.Context, bool, error) {
+			return ctx, credentials.Username == current.Username && username == "app", nil
+		}
+
+		server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(PeerAuth(mapping)))
+		require.NoError(t, err)
+
+		dir := TListenAndServeUnix(t, server, 5433)
+		ctx := context.Background()
+
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=5433 user=app sslmode=disable", dir))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close(ctx))
+
+		_, err = pgx.Connect(ctx, fmt.Sprintf("host=%s port=5433 user=%s sslmode=disable", dir, current.Username))
+		require.Error(t, err)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		t.Parallel()
+
+		server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(PeerAuth(nil)))
+		require.NoError(t, err)
+
+		address := TListenAndServe(t, server)
+		ctx := context.Background()
+
+		_, err = pgx.Connect(ctx, fmt.Sprintf("postgres://%s@%s:%d?sslmode=disable", current.Username, address.IP, address.Port))
+		require.ErrorContains(t, err, "could not get peer credentials")
+	})
+}
@@ -0,0 +1,214 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// SASLMechanism represents a single SASL authentication mechanism which could
+// be offered to the client using the [SASL] authentication strategy.
+// https://www.postgresql.org/docs/current/sasl-authentication.html
+type SASLMechanism interface {
+	// Name returns the registered name of the mechanism as announced to the
+	// client. For example: SCRAM-SHA-256.
+	Name() string
+	// Supported reports whether the mechanism could be offered on the given
+	// connection. Mechanisms requiring channel binding could for example only
+	// be offered on TLS connections.
+	Supported(ctx context.Context) bool
+	// Start is called once the client has selected the mechanism and returns
+	// a new exchange used to authenticate the client.
+	Start(ctx context.Context) (SASLExchange, error)
+}
+
+// SASLExchange represents the server side of a single SASL exchange.
+type SASLExchange interface {
+	// Next processes the given client response and returns the challenge
+	// which should be send to the client. The initial client response is nil
+	// when the client did not include one. Done should be returned once the
+	// client has been authenticated, the challenge is then send to the client
+	// as additional data of the completed exchange. Returning an error fails
+	// the exchange. Errors without a Postgres error code are reported to the
+	// client as a generic password authentication failure.
+	Next(ctx context.Context, data []byte) (_ context.Context, challenge []byte, done bool, err error)
+}
+
+// SASL announces to the client to authenticate using one of the given SASL
+// mechanisms. Mechanisms are offered in the given order of preference, only
+// mechanisms supported on the given connection are offered. The SASL messages
+// send by the client are routed to the selected mechanism until the exchange
+// is completed. If the exchange fails, a FATAL error is written to the client
+// and the connection should be closed.
+// https://www.postgresql.org/docs/current/sasl-authentication.html
+func SASL(mechanisms ...SASLMechanism) AuthStrategy {
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		supported := make([]SASLMechanism, 0, len(mechanisms))
+		names := make([]string, 0, len(mechanisms))
+		for _, mechanism := range mechanisms {
+			if !mechanism.Supported(ctx) {
+				continue
+			}
+
+			supported = append(supported, mechanism)
+			names = append(names, mechanism.Name())
+		}
+
+		if len(supported) == 0 {
+			return ctx, writeAuthFailure(writer, newErrInvalidPassword())
+		}
+
+		ctx = setSASLMechanisms(ctx, names)
+		err = writeAuthSASL(writer, names)
+		if err != nil {
+			return ctx, err
+		}
+
+		name, data, err := readSASLInitialResponse(reader)
+		if err != nil {
+			return ctx, err
+		}
+
+		index := slices.Index(names, name)
+		if index < 0 {
+			return ctx, writeAuthFailure(writer, newErrAuthProtocolViolation("client selected an invalid SASL authentication mechanism: %q", name))
+		}
+
+		exchange, err := supported[index].Start(ctx)
+		if err != nil {
+			return ctx, err
+		}
+
+		for {
+			var challenge []byte
+			var done bool
+
+			ctx, challenge, done, err = exchange.Next(ctx, data)
+			if err != nil {
+				return ctx, saslFailure(ctx, writer, err)
+			}
+
+			if done {
+				// NOTE: the additional data of a completed exchange is
+				// optional, AuthenticationSASLFinal is omitted when empty.
+				if challenge != nil {
+					err = writeAuthSASLData(writer, authSASLFinal, challenge)
+					if err != nil {
+						return ctx, err
+					}
+				}
+
+				return ctx, writeAuthType(writer, authOK)
+			}
+
+			err = writeAuthSASLData(writer, authSASLContinue, challenge)
+			if err != nil {
+				return ctx, err
+			}
+
+			data, err = readSASLResponse(reader)
+			if err != nil {
+				return ctx, err
+			}
+		}
+	}
+}
+
+// readSASLInitialResponse reads the SASLInitialResponse message send by the
+// client containing the selected mechanism and the initial client response.
+func readSASLInitialResponse(reader *buffer.Reader) (mechanism string, data []byte, err error) {
+	t, _, err := reader.ReadTypedMsg()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if t != types.ClientPassword {
+		return "", nil, newErrAuthProtocolViolation("expected SASL response, got message type %q", t)
+	}
+
+	mechanism, err = reader.GetString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// NOTE: the length of the initial client response, or -1 if no initial
+	// response is present.
+	length, err := reader.GetInt32()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if length < 0 {
+		return mechanism, nil, nil
+	}
+
+	data, err = reader.GetBytes(int(length))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mechanism, data, nil
+}
+
+// readSASLResponse reads a SASLResponse message send by the client containing
+// the answer to the last written SASL challenge.
+func readSASLResponse(reader *buffer.Reader) ([]byte, error) {
+	t, _, err := reader.ReadTypedMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	if t != types.ClientPassword {
+		return nil, newErrAuthProtocolViolation("expected SASL response, got message type %q", t)
+	}
+
+	return reader.Msg, nil
+}
+
+// SASLMechanisms returns the names of the SASL mechanisms which have been
+// offered to the client during the ongoing SASL exchange.
+func SASLMechanisms(ctx context.Context) []string {
+	val := ctx.Value(ctxSASLMechanisms)
+	if val == nil {
+		return nil
+	}
+
+	return val.([]string)
+}
+
+func setSASLMechanisms(ctx context.Context, mechanisms []string) context.Context {
+	return context.WithValue(ctx, ctxSASLMechanisms, mechanisms)
+}
+
+// newErrPasswordAuthFailed is returned to the client whenever a SASL exchange
+// failed without a Postgres error code.
+func newErrPasswordAuthFailed(username string) error {
+	err := fmt.Errorf("password authentication failed for user \"%s\"", username)
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidPassword), pgerror.LevelFatal)
+}
+
+// saslFailure writes the given exchange error as a FATAL error to the client.
+// Errors without a Postgres error code are reported to the client as a generic
+// password authentication failure, preventing the details of the failed
+// exchange step from being disclosed to an unauthenticated client. The
+// returned error contains the original exchange error to be logged by the
+// server.
+func saslFailure(ctx context.Context, writer *buffer.Writer, err error) error {
+	if pgerror.GetCode(err) != codes.Uncategorized {
+		return writeAuthFailure(writer, pgerror.WithSeverity(err, pgerror.LevelFatal))
+	}
+
+	username := ClientParameters(ctx)[ParamUsername]
+	writeErr := WriteUnterminatedError(writer, newErrPasswordAuthFailed(username))
+	if writeErr != nil {
+		return writeErr
+	}
+
+	err = fmt.Errorf("SASL authentication failed for user %q: %w", username, err)
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidPassword), pgerror.LevelFatal)
+}
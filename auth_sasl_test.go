@@ -0,0 +1,151 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+// echoMechanism is a SASL mechanism used for testing purposes. The client is
+// challenged to echo the expected secret twice.
+type echoMechanism struct {
+	name      string
+	secret    string
+	supported bool
+}
+
+func (mechanism *echoMechanism) Name() string                       { return mechanism.name }
+func (mechanism *echoMechanism) Supported(ctx context.Context) bool { return mechanism.supported }
+
+func (mechanism *echoMechanism) Start(ctx context.Context) (SASLExchange, error) {
+	return &echoExchange{secret: mechanism.secret}, nil
+}
+
+type echoExchange struct {
+	secret string
+	rounds int
+}
+
+func (exchange *echoExchange) Next(ctx context.Context, data []byte) (context.Context, []byte, bool, error) {
+	if string(data) != exchange.secret {
+		return ctx, nil, false, errors.New("unexpected secret")
+	}
+
+	exchange.rounds++
+	if exchange.rounds == 2 {
+		return ctx, nil, true, nil
+	}
+
+	return ctx, []byte("again"), false, nil
+}
+
+func TestSASL(t *testing.T) {
+	t.Parallel()
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	strategy := SASL(
+		&echoMechanism{name: "UNSUPPORTED", supported: false},
+		&echoMechanism{name: "ECHO", secret: "secret", supported: true},
+	)
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(strategy))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T) *mock.Client {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+
+		client.ExpectMsg(t, types.ServerAuth)
+		status, err := client.GetUint32()
+		require.NoError(t, err)
+		require.Equal(t, authSASL, authType(status))
+
+		mechanism, err := client.GetString()
+		require.NoError(t, err)
+		require.Equal(t, "ECHO", mechanism)
+
+		terminator, err := client.GetString()
+		require.NoError(t, err)
+		require.Empty(t, terminator)
+		return client
+	}
+
+	initial := func(t *testing.T, client *mock.Client, mechanism, message string) {
+		client.Start(types.ClientPassword)
+		client.AddString(mechanism)
+		client.AddNullTerminate()
+		client.AddInt32(int32(len(message)))
+		client.AddBytes([]byte(message))
+		require.NoError(t, client.End())
+	}
+
+	expectError := func(t *testing.T, client *mock.Client, expected codes.Code) map[byte]string {
+		client.ExpectMsg(t, types.ServerErrorResponse)
+		fields := make(map[byte]string)
+		for {
+			field, err := client.GetBytes(1)
+			require.NoError(t, err)
+			if field[0] == 0 {
+				break
+			}
+
+			fields[field[0]], err = client.GetString()
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, string(expected), fields['C'])
+		return fields
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		client := connect(t)
+		initial(t, client, "ECHO", "secret")
+
+		client.ExpectMsg(t, types.ServerAuth)
+		status, err := client.GetUint32()
+		require.NoError(t, err)
+		require.Equal(t, authSASLContinue, authType(status))
+		require.Equal(t, "again", string(client.Msg))
+
+		client.Start(types.ClientPassword)
+		client.AddBytes([]byte("secret"))
+		require.NoError(t, client.End())
+
+		client.Authenticate(t)
+		client.ReadyForQuery(t)
+		client.Close(t)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		client := connect(t)
+		initial(t, client, "ECHO", "wrong")
+
+		// NOTE: the details of the failed exchange are not disclosed.
+		fields := expectError(t, client, codes.InvalidPassword)
+		require.Equal(t, `password authentication failed for user ""`, fields['M'])
+	})
+
+	t.Run("unsupported mechanism", func(t *testing.T) {
+		client := connect(t)
+		initial(t, client, "UNSUPPORTED", "secret")
+		expectError(t, client, codes.ProtocolViolation)
+	})
+}
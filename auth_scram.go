@@ -0,0 +1,525 @@
+package wire
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SCRAM SASL mechanism names. The PLUS variant makes use of channel binding
+// and is only offered on TLS connections.
+// https://www.postgresql.org/docs/current/sasl-authentication.html#SASL-SCRAM-SHA-256
+const (
+	scramSHA256     = "SCRAM-SHA-256"
+	scramSHA256Plus = "SCRAM-SHA-256-PLUS"
+)
+
+// scramTLSServerEndPoint is the only channel binding type supported by the
+// SCRAM-SHA-256-PLUS mechanism.
+const scramTLSServerEndPoint = "tls-server-end-point"
+
+// DefaultScramIterations is the number of PBKDF2 iterations used when
+// constructing new SCRAM credentials. The value matches the PostgreSQL default
+// of the scram_iterations setting.
+const DefaultScramIterations = 4096
+
+// scramNonceSize is the amount of random bytes used to construct the server
+// part of the SCRAM nonce.
+const scramNonceSize = 18
+
+// ScramCredentials represents the salted verifier of a user as stored by the
+// server. The verifier does not contain the plain text password and could be
+// safely persisted. The format is compatible with the rolpassword column of
+// the PostgreSQL pg_authid catalog.
+type ScramCredentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewScramCredentials constructs new SCRAM-SHA-256 credentials for the given
+// password using a random salt and the default amount of iterations.
+func NewScramCredentials(password string) (ScramCredentials, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return ScramCredentials{}, err
+	}
+
+	return DeriveScramCredentials(password, salt, DefaultScramIterations)
+}
+
+// DeriveScramCredentials derives the SCRAM-SHA-256 credentials for the given
+// password, salt and iteration count.
+func DeriveScramCredentials(password string, salt []byte, iterations int) (ScramCredentials, error) {
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return ScramCredentials{}, err
+	}
+
+	clientKey := scramHMAC(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+
+	return ScramCredentials{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey[:],
+		ServerKey:  scramHMAC(salted, "Server Key"),
+	}, nil
+}
+
+// ParseScramCredentials parses the given verifier stored using the PostgreSQL
+// format: SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func ParseScramCredentials(verifier string) (ScramCredentials, error) {
+	mechanism, rest, _ := strings.Cut(verifier, "$")
+	if mechanism != scramSHA256 {
+		return ScramCredentials{}, fmt.Errorf("unsupported scram verifier mechanism: %q", mechanism)
+	}
+
+	params, keys, ok := strings.Cut(rest, "$")
+	if !ok {
+		return ScramCredentials{}, fmt.Errorf("malformed scram verifier")
+	}
+
+	iterations, salt, ok := strings.Cut(params, ":")
+	if !ok {
+		return ScramCredentials{}, fmt.Errorf("malformed scram verifier parameters")
+	}
+
+	storedKey, serverKey, ok := strings.Cut(keys, ":")
+	if !ok {
+		return ScramCredentials{}, fmt.Errorf("malformed scram verifier keys")
+	}
+
+	count, err := strconv.Atoi(iterations)
+	if err != nil || count <= 0 {
+		return ScramCredentials{}, fmt.Errorf("invalid scram verifier iterations: %q", iterations)
+	}
+
+	credentials := ScramCredentials{
+		Iterations: count,
+	}
+
+	fields := []struct {
+		value string
+		dest  *[]byte
+	}{
+		{salt, &credentials.Salt},
+		{storedKey, &credentials.StoredKey},
+		{serverKey, &credentials.ServerKey},
+	}
+
+	for _, field := range fields {
+		*field.dest, err = base64.StdEncoding.DecodeString(field.value)
+		if err != nil {
+			return ScramCredentials{}, fmt.Errorf("malformed scram verifier: %w", err)
+		}
+	}
+
+	return credentials, nil
+}
+
+// String returns the credentials encoded using the PostgreSQL verifier format.
+func (credentials ScramCredentials) String() string {
+	return fmt.Sprintf("%s$%d:%s$%s:%s",
+		scramSHA256,
+		credentials.Iterations,
+		base64.StdEncoding.EncodeToString(credentials.Salt),
+		base64.StdEncoding.EncodeToString(credentials.StoredKey),
+		base64.StdEncoding.EncodeToString(credentials.ServerKey),
+	)
+}
+
+// ScramCredentialsFn returns the stored SCRAM credentials of the given user.
+// Nil credentials should be returned when the user is unknown. The exchange
+// will continue using mock credentials and fail once the client proof is
+// received, preventing the client from probing for existing users.
+type ScramCredentialsFn func(ctx context.Context, database, username string) (context.Context, *ScramCredentials, error)
+
+// ScramSHA256 announces to the client to authenticate using the SCRAM-SHA-256
+// SASL mechanism. The given function is called to lookup the salted verifier
+// of the user (received inside the client parameters). The plain text password
+// is never sent to, or known by, the server. If the client proof is invalid or
+// any unexpected error occurs, an error is returned and the connection should
+// be closed.
+//
+// On TLS connections the SCRAM-SHA-256-PLUS mechanism is offered as well,
+// binding the exchange to the server certificate using the
+// tls-server-end-point channel binding type. This allows clients to connect
+// using channel_binding=require.
+// https://www.postgresql.org/docs/current/sasl-authentication.html
+func ScramSHA256(credentials ScramCredentialsFn) AuthStrategy {
+	return SASL(ScramSHA256PlusMechanism(credentials), ScramSHA256Mechanism(credentials))
+}
+
+// ScramSHA256Mechanism returns the SCRAM-SHA-256 SASL mechanism which could be
+// combined with other mechanisms using the [SASL] authentication strategy. The
+// given function is called to lookup the salted verifier of the user.
+func ScramSHA256Mechanism(credentials ScramCredentialsFn) SASLMechanism {
+	return &scramMechanism{credentials: credentials}
+}
+
+// ScramSHA256PlusMechanism returns the SCRAM-SHA-256-PLUS SASL mechanism using
+// the tls-server-end-point channel binding type. The mechanism is only offered
+// on TLS connections.
+func ScramSHA256PlusMechanism(credentials ScramCredentialsFn) SASLMechanism {
+	return &scramMechanism{credentials: credentials, plus: true}
+}
+
+// scramMechanism implements the SCRAM-SHA-256 and SCRAM-SHA-256-PLUS SASL
+// mechanisms.
+type scramMechanism struct {
+	credentials ScramCredentialsFn
+	plus        bool
+}
+
+func (mechanism *scramMechanism) Name() string {
+	if mechanism.plus {
+		return scramSHA256Plus
+	}
+
+	return scramSHA256
+}
+
+func (mechanism *scramMechanism) Supported(ctx context.Context) bool {
+	return !mechanism.plus || scramChannelBinding(ctx) != nil
+}
+
+func (mechanism *scramMechanism) Start(ctx context.Context) (SASLExchange, error) {
+	params := ClientParameters(ctx)
+	exchange := &scramExchange{
+		database: params[ParamDatabase],
+		username: params[ParamUsername],
+		lookup:   mechanism.credentials,
+		plus:     mechanism.plus,
+	}
+
+	// NOTE: the channel binding data is only set when the PLUS variant has
+	// been offered to the client. This allows the exchange to detect
+	// downgrade attacks.
+	if slices.Contains(SASLMechanisms(ctx), scramSHA256Plus) {
+		exchange.channelBinding = scramChannelBinding(ctx)
+	}
+
+	return exchange, nil
+}
+
+// scramChannelBinding returns the tls-server-end-point channel binding data
+// of the given connection. Nil is returned for insecure connections or when
+// the server certificate does not support channel binding.
+func scramChannelBinding(ctx context.Context) []byte {
+	if TLSConnectionState(ctx) == nil {
+		return nil
+	}
+
+	certificate := tlsServerCertificate(ctx)
+	if certificate == nil {
+		return nil
+	}
+
+	binding, err := tlsServerEndPoint(certificate)
+	if err != nil {
+		return nil
+	}
+
+	return binding
+}
+
+// tlsServerEndPoint returns the hash of the given certificate as defined by
+// the tls-server-end-point channel binding type. The hash function used is
+// the hash function of the certificate signature algorithm, MD5 and SHA-1 are
+// upgraded to SHA-256.
+// https://datatracker.ietf.org/doc/html/rfc5929#section-4.1
+func tlsServerEndPoint(certificate *x509.Certificate) ([]byte, error) {
+	var digest hash.Hash
+	switch certificate.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.DSAWithSHA256, x509.ECDSAWithSHA256:
+		digest = sha256.New()
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		digest = sha512.New384()
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		digest = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported certificate signature algorithm for channel binding: %s", certificate.SignatureAlgorithm)
+	}
+
+	digest.Write(certificate.Raw)
+	return digest.Sum(nil), nil
+}
+
+// scramExchange represents the server side of a single SCRAM-SHA-256 exchange.
+// https://datatracker.ietf.org/doc/html/rfc5802
+type scramExchange struct {
+	database string
+	username string
+	// lookup is called to lookup the credentials of the user once the
+	// exchange starts.
+	lookup      ScramCredentialsFn
+	credentials *ScramCredentials
+	// channelBinding contains the channel binding data of the connection. Nil
+	// is set when channel binding is not offered to the client.
+	channelBinding []byte
+	// plus is set when the client selected the SCRAM-SHA-256-PLUS mechanism.
+	plus bool
+	// serverNonce is generated when empty. It is only predefined for testing
+	// purposes.
+	serverNonce string
+
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	completed       bool
+}
+
+// Next implements the [SASLExchange] interface.
+func (exchange *scramExchange) Next(ctx context.Context, data []byte) (_ context.Context, challenge []byte, done bool, err error) {
+	if exchange.lookup != nil {
+		lookup := exchange.lookup
+		exchange.lookup = nil
+
+		ctx, exchange.credentials, err = lookup(ctx, exchange.database, exchange.username)
+		if err != nil {
+			return ctx, nil, false, err
+		}
+	}
+
+	challenge, done, err = exchange.next(data)
+	return ctx, challenge, done, err
+}
+
+// next processes the given client message and returns the server response.
+// Done is returned once the client has been authenticated, in which case the
+// response contains the server signature.
+func (exchange *scramExchange) next(data []byte) (response []byte, done bool, err error) {
+	if exchange.serverFirst == "" {
+		response, err := exchange.handleClientFirst(string(data))
+		return response, false, err
+	}
+
+	if exchange.completed {
+		return nil, false, newErrAuthProtocolViolation("unexpected SCRAM message after completed exchange")
+	}
+
+	response, err = exchange.handleClientFinal(string(data))
+	if err != nil {
+		return nil, false, err
+	}
+
+	exchange.completed = true
+	return response, true, nil
+}
+
+// handleClientFirst parses the client-first-message and constructs the
+// server-first-message.
+//
+//	client-first-message = gs2-header client-first-message-bare
+//	gs2-header = gs2-cbind-flag "," [ authzid ] ","
+//	client-first-message-bare = [reserved-mext ","] username "," nonce ["," extensions]
+func (exchange *scramExchange) handleClientFirst(message string) ([]byte, error) {
+	flag, rest, ok := strings.Cut(message, ",")
+	if !ok {
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message")
+	}
+
+	switch {
+	case flag == "n":
+		// NOTE: the client does not support channel binding.
+		if exchange.plus {
+			return nil, newErrAuthProtocolViolation("channel binding is required by the %s mechanism, but the client does not support it", scramSHA256Plus)
+		}
+	case flag == "y":
+		// NOTE: the client supports channel binding but assumes that the
+		// server does not. A server offering channel binding has to reject
+		// the exchange since this indicates a downgrade attack.
+		if exchange.plus || exchange.channelBinding != nil {
+			return nil, newErrAuthProtocolViolation("SCRAM channel binding negotiation error")
+		}
+	case strings.HasPrefix(flag, "p="):
+		if !exchange.plus {
+			return nil, newErrAuthProtocolViolation("client requested channel binding, but the %s mechanism does not support it", scramSHA256)
+		}
+
+		if flag[len("p="):] != scramTLSServerEndPoint {
+			return nil, newErrAuthProtocolViolation("unsupported SCRAM channel binding type %q", flag[len("p="):])
+		}
+	default:
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message: unexpected channel binding flag %q", flag)
+	}
+
+	authzid, bare, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message")
+	}
+
+	if authzid != "" {
+		return nil, newErrAuthProtocolViolation("client uses authorization identity, but it is not supported")
+	}
+
+	attributes, err := scramAttributes(bare)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attributes) < 2 || attributes[0].key != 'n' || attributes[1].key != 'r' {
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message: expected username and nonce")
+	}
+
+	// NOTE: the username send within the SCRAM message is ignored, the
+	// username defined inside the startup parameters is used instead.
+	clientNonce := attributes[1].value
+	if clientNonce == "" {
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message: empty nonce")
+	}
+
+	serverNonce := exchange.serverNonce
+	if serverNonce == "" {
+		nonce := make([]byte, scramNonceSize)
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+
+		serverNonce = base64.StdEncoding.EncodeToString(nonce)
+	}
+
+	if exchange.credentials == nil {
+		exchange.credentials = scramMockCredentials(exchange.username)
+	}
+
+	exchange.gs2Header = flag + "," + authzid + ","
+	exchange.clientFirstBare = bare
+	exchange.nonce = clientNonce + serverNonce
+	exchange.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d",
+		exchange.nonce,
+		base64.StdEncoding.EncodeToString(exchange.credentials.Salt),
+		exchange.credentials.Iterations,
+	)
+
+	return []byte(exchange.serverFirst), nil
+}
+
+// handleClientFinal validates the client-final-message and constructs the
+// server-final-message containing the server signature.
+//
+//	client-final-message-without-proof = channel-binding "," nonce ["," extensions]
+//	client-final-message = client-final-message-without-proof "," proof
+func (exchange *scramExchange) handleClientFinal(message string) ([]byte, error) {
+	index := strings.LastIndex(message, ",p=")
+	if index < 0 {
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message: missing client proof")
+	}
+
+	withoutProof := message[:index]
+	proof, err := base64.StdEncoding.DecodeString(message[index+len(",p="):])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message: invalid client proof")
+	}
+
+	attributes, err := scramAttributes(withoutProof)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attributes) < 2 || attributes[0].key != 'c' || attributes[1].key != 'r' {
+		return nil, newErrAuthProtocolViolation("malformed SCRAM message: expected channel binding and nonce")
+	}
+
+	// NOTE: the channel binding attribute contains the gs2 header followed
+	// by the channel binding data when channel binding is used.
+	binding := []byte(exchange.gs2Header)
+	if exchange.plus {
+		binding = append(binding, exchange.channelBinding...)
+	}
+
+	if attributes[0].value != base64.StdEncoding.EncodeToString(binding) {
+		return nil, errors.New("SCRAM channel binding check failed")
+	}
+
+	if attributes[1].value != exchange.nonce {
+		return nil, errors.New("SCRAM nonce mismatch")
+	}
+
+	authMessage := exchange.clientFirstBare + "," + exchange.serverFirst + "," + withoutProof
+
+	// NOTE: the client key is recovered from the proof using the stored key.
+	// The client is authenticated when the hash of the recovered client key
+	// matches the stored key.
+	signature := scramHMAC(exchange.credentials.StoredKey, authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ signature[i]
+	}
+
+	storedKey := sha256.Sum256(clientKey)
+	if !hmac.Equal(storedKey[:], exchange.credentials.StoredKey) {
+		return nil, newErrInvalidPassword()
+	}
+
+	verifier := scramHMAC(exchange.credentials.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(verifier)), nil
+}
+
+// scramAttribute represents a single key/value attribute inside a SCRAM message.
+type scramAttribute struct {
+	key   byte
+	value string
+}
+
+// scramAttributes parses the comma separated attributes inside the given SCRAM
+// message.
+func scramAttributes(message string) ([]scramAttribute, error) {
+	parts := strings.Split(message, ",")
+	attributes := make([]scramAttribute, 0, len(parts))
+	for _, part := range parts {
+		if len(part) < 2 || part[1] != '=' {
+			return nil, newErrAuthProtocolViolation("malformed SCRAM message: invalid attribute %q", part)
+		}
+
+		attributes = append(attributes, scramAttribute{key: part[0], value: part[2:]})
+	}
+
+	return attributes, nil
+}
+
+// scramMockSecret is a random secret generated once per process and used to
+// derive the mock salt of unknown users.
+var scramMockSecret = sync.OnceValue(func() []byte {
+	secret := make([]byte, sha256.Size)
+	rand.Read(secret) //nolint:errcheck
+	return secret
+})
+
+// scramMockCredentials constructs the mock credentials which are used to
+// continue the exchange for unknown users. The salt is derived from the given
+// username so that repeated attempts for the same user receive the same salt.
+// No client proof will ever match the mock credentials.
+func scramMockCredentials(username string) *ScramCredentials {
+	salt := scramHMAC(scramMockSecret(), username)
+	return &ScramCredentials{
+		Salt:       salt[:16],
+		Iterations: DefaultScramIterations,
+		StoredKey:  scramHMAC(scramMockSecret(), "stored key"),
+		ServerKey:  scramHMAC(scramMockSecret(), "server key"),
+	}
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
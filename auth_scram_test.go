@@ -0,0 +1,382 @@
+package wire
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScramExchange(t *testing.T) {
+	// NOTE: test vectors are taken from RFC 7677 section 3
+	// https://datatracker.ietf.org/doc/html/rfc7677#section-3
+	salt, err := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	require.NoError(t, err)
+
+	credentials, err := DeriveScramCredentials("pencil", salt, 4096)
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		exchange := &scramExchange{
+			credentials: &credentials,
+			serverNonce: "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0",
+		}
+
+		response, done, err := exchange.next([]byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+		require.NoError(t, err)
+		require.False(t, done)
+		require.Equal(t, "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", string(response))
+
+		response, done, err = exchange.next([]byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))
+		require.NoError(t, err)
+		require.True(t, done)
+		require.Equal(t, "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", string(response))
+	})
+
+	t.Run("invalid proof", func(t *testing.T) {
+		exchange := &scramExchange{
+			credentials: &credentials,
+			serverNonce: "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0",
+		}
+
+		_, _, err := exchange.next([]byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+		require.NoError(t, err)
+
+		_, _, err = exchange.next([]byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid username/password")
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		exchange := &scramExchange{
+			credentials: &credentials,
+			serverNonce: "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0",
+		}
+
+		_, _, err := exchange.next([]byte("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+		require.NoError(t, err)
+
+		_, _, err = exchange.next([]byte("c=biws,r=rOprNGfwEbeRWgbNEkqO,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))
+		require.Error(t, err)
+	})
+
+	t.Run("channel binding", func(t *testing.T) {
+		exchange := &scramExchange{
+			credentials: &credentials,
+		}
+
+		_, _, err := exchange.next([]byte("p=tls-server-end-point,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+		require.Error(t, err)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		first := &scramExchange{username: "unknown"}
+		a, _, err := first.next([]byte("n,,n=,r=rOprNGfwEbeRWgbNEkqO"))
+		require.NoError(t, err)
+
+		second := &scramExchange{username: "unknown"}
+		b, _, err := second.next([]byte("n,,n=,r=rOprNGfwEbeRWgbNEkqO"))
+		require.NoError(t, err)
+
+		// NOTE: the mock salt should be stable for the same unknown user.
+		attrsA, err := scramAttributes(string(a))
+		require.NoError(t, err)
+		attrsB, err := scramAttributes(string(b))
+		require.NoError(t, err)
+		require.Equal(t, attrsA[1], attrsB[1])
+	})
+}
+
+func TestParseScramCredentials(t *testing.T) {
+	credentials, err := NewScramCredentials("password")
+	require.NoError(t, err)
+
+	parsed, err := ParseScramCredentials(credentials.String())
+	require.NoError(t, err)
+	require.Equal(t, credentials, parsed)
+
+	invalid := []string{
+		"",
+		"md5d41d8cd98f00b204e9800998ecf8427e",
+		"SCRAM-SHA-256$4096",
+		"SCRAM-SHA-256$abc:c2FsdA==$c3RvcmVk:c2VydmVy",
+		"SCRAM-SHA-256$4096:c2FsdA==$c3RvcmVk",
+		"SCRAM-SHA-256$4096:!!!$c3RvcmVk:c2VydmVy",
+	}
+
+	for _, verifier := range invalid {
+		_, err := ParseScramCredentials(verifier)
+		require.Error(t, err, verifier)
+	}
+}
+
+func TestScramSHA256(t *testing.T) {
+	t.Parallel()
+
+	credentials, err := NewScramCredentials("password")
+	require.NoError(t, err)
+
+	lookup := func(ctx context.Context, database, username string) (context.Context, *ScramCredentials, error) {
+		if username != "john" {
+			return ctx, nil, nil
+		}
+
+		return ctx, &credentials, nil
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(ScramSHA256(lookup)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("jackc/pgx", func(t *testing.T) {
+		ctx := context.Background()
+
+		connstr := fmt.Sprintf("postgres://john:password@%s:%d?sslmode=disable", address.IP, address.Port)
+		conn, err := pgx.Connect(ctx, connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Ping(ctx))
+		require.NoError(t, conn.Close(ctx))
+
+		connstr = fmt.Sprintf("postgres://john:wrong@%s:%d?sslmode=disable", address.IP, address.Port)
+		_, err = pgx.Connect(ctx, connstr)
+		require.Error(t, err)
+
+		connstr = fmt.Sprintf("postgres://unknown:password@%s:%d?sslmode=disable", address.IP, address.Port)
+		_, err = pgx.Connect(ctx, connstr)
+		require.Error(t, err)
+	})
+
+	t.Run("lib/pq", func(t *testing.T) {
+		connstr := fmt.Sprintf("host=%s port=%d user=john password=password sslmode=disable", address.IP, address.Port)
+		conn, err := sql.Open("postgres", connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Ping())
+		require.NoError(t, conn.Close())
+
+		connstr = fmt.Sprintf("host=%s port=%d user=john password=wrong sslmode=disable", address.IP, address.Port)
+		conn, err = sql.Open("postgres", connstr)
+		require.NoError(t, err)
+		require.Error(t, conn.Ping())
+		require.NoError(t, conn.Close())
+	})
+}
+
+// scramClientFinal constructs the SCRAM client-final-message for the given
+// password, exchanged messages and channel binding attribute.
+func scramClientFinal(t *testing.T, password, clientFirstBare, serverFirst, binding string) (message string, serverSignature string) {
+	attributes, err := scramAttributes(serverFirst)
+	require.NoError(t, err)
+	require.Len(t, attributes, 3)
+
+	salt, err := base64.StdEncoding.DecodeString(attributes[1].value)
+	require.NoError(t, err)
+
+	iterations, err := strconv.Atoi(attributes[2].value)
+	require.NoError(t, err)
+
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	require.NoError(t, err)
+
+	withoutProof := "c=" + binding + ",r=" + attributes[0].value
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	clientKey := scramHMAC(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	signature := scramHMAC(storedKey[:], authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+
+	serverKey := scramHMAC(salted, "Server Key")
+	serverSignature = "v=" + base64.StdEncoding.EncodeToString(scramHMAC(serverKey, authMessage))
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), serverSignature
+}
+
+func TestScramSHA256Plus(t *testing.T) {
+	t.Parallel()
+
+	certificate, err := generateTestCert()
+	require.NoError(t, err)
+
+	credentials, err := NewScramCredentials("password")
+	require.NoError(t, err)
+
+	lookup := func(ctx context.Context, database, username string) (context.Context, *ScramCredentials, error) {
+		return ctx, &credentials, nil
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	// NOTE: the session ticket keys are shared between connections, allowing
+	// TLS sessions to be resumed.
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	config.SetSessionTicketKeys([][32]byte{{1}})
+
+	server, err := NewServer(handler,
+		Logger(slogt.New(t)),
+		TLSConfig(config),
+		SessionAuthStrategy(ScramSHA256(lookup)),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	sessions := tls.NewLRUClientSessionCache(1)
+
+	connect := func(t *testing.T) (*mock.Client, *tls.Conn) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+		request := make([]byte, 8)
+		binary.BigEndian.PutUint32(request[0:4], 8)
+		binary.BigEndian.PutUint32(request[4:8], uint32(types.VersionSSLRequest))
+		_, err = conn.Write(request)
+		require.NoError(t, err)
+
+		response := make([]byte, 1)
+		_, err = io.ReadFull(conn, response)
+		require.NoError(t, err)
+		require.Equal(t, sslSupported, sslIdentifier(response))
+
+		secure := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ClientSessionCache: sessions}) //nolint:gosec
+		client := mock.NewClient(t, secure)
+		client.Handshake(t)
+
+		client.ExpectMsg(t, types.ServerAuth)
+		status, err := client.GetUint32()
+		require.NoError(t, err)
+		require.Equal(t, authSASL, authType(status))
+
+		mechanisms := []string{}
+		for {
+			mechanism, err := client.GetString()
+			require.NoError(t, err)
+			if mechanism == "" {
+				break
+			}
+			mechanisms = append(mechanisms, mechanism)
+		}
+
+		require.Equal(t, []string{scramSHA256Plus, scramSHA256}, mechanisms)
+		return client, secure
+	}
+
+	initial := func(t *testing.T, client *mock.Client, mechanism, message string) {
+		client.Start(types.ClientPassword)
+		client.AddString(mechanism)
+		client.AddNullTerminate()
+		client.AddInt32(int32(len(message)))
+		client.AddBytes([]byte(message))
+		require.NoError(t, client.End())
+	}
+
+	bind := func(t *testing.T, client *mock.Client, secure *tls.Conn) {
+		gs2Header := "p=tls-server-end-point,,"
+		clientFirstBare := "n=,r=fyko+d2lbbFgONRv9qkxdawL"
+		initial(t, client, scramSHA256Plus, gs2Header+clientFirstBare)
+
+		client.ExpectMsg(t, types.ServerAuth)
+		status, err := client.GetUint32()
+		require.NoError(t, err)
+		require.Equal(t, authSASLContinue, authType(status))
+		serverFirst := string(client.Msg)
+
+		// NOTE: the test certificate is signed using SHA-256
+		endpoint := sha256.Sum256(secure.ConnectionState().PeerCertificates[0].Raw)
+		binding := base64.StdEncoding.EncodeToString(append([]byte(gs2Header), endpoint[:]...))
+		final, signature := scramClientFinal(t, "password", clientFirstBare, serverFirst, binding)
+
+		client.Start(types.ClientPassword)
+		client.AddBytes([]byte(final))
+		require.NoError(t, client.End())
+
+		client.ExpectMsg(t, types.ServerAuth)
+		status, err = client.GetUint32()
+		require.NoError(t, err)
+		require.Equal(t, authSASLFinal, authType(status))
+		require.Equal(t, signature, string(client.Msg))
+
+		client.Authenticate(t)
+		client.ReadyForQuery(t)
+		client.Close(t)
+	}
+
+	t.Run("channel binding", func(t *testing.T) {
+		client, secure := connect(t)
+		bind(t, client, secure)
+	})
+
+	t.Run("resumed session", func(t *testing.T) {
+		client, secure := connect(t)
+		bind(t, client, secure)
+
+		// NOTE: channel binding remains offered on resumed sessions.
+		client, secure = connect(t)
+		require.True(t, secure.ConnectionState().DidResume)
+		bind(t, client, secure)
+	})
+
+	t.Run("invalid channel binding", func(t *testing.T) {
+		client, _ := connect(t)
+
+		gs2Header := "p=tls-server-end-point,,"
+		clientFirstBare := "n=,r=fyko+d2lbbFgONRv9qkxdawL"
+		initial(t, client, scramSHA256Plus, gs2Header+clientFirstBare)
+
+		client.ExpectMsg(t, types.ServerAuth)
+		_, err := client.GetUint32()
+		require.NoError(t, err)
+		serverFirst := string(client.Msg)
+
+		binding := base64.StdEncoding.EncodeToString(append([]byte(gs2Header), make([]byte, sha256.Size)...))
+		final, _ := scramClientFinal(t, "password", clientFirstBare, serverFirst, binding)
+
+		client.Start(types.ClientPassword)
+		client.AddBytes([]byte(final))
+		require.NoError(t, client.End())
+
+		client.Error(t)
+	})
+
+	t.Run("downgrade", func(t *testing.T) {
+		client, _ := connect(t)
+		initial(t, client, scramSHA256, "y,,n=,r=fyko+d2lbbFgONRv9qkxdawL")
+		client.Error(t)
+	})
+
+	t.Run("jackc/pgx", func(t *testing.T) {
+		ctx := context.Background()
+		connstr := fmt.Sprintf("postgres://john:password@%s:%d?sslmode=require", address.IP, address.Port)
+		conn, err := pgx.Connect(ctx, connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Ping(ctx))
+		require.NoError(t, conn.Close(ctx))
+	})
+}
@@ -0,0 +1,282 @@
+package wire
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// DefaultThrottleWindow is the default duration after which failed
+// authentication attempts are forgotten.
+const DefaultThrottleWindow = 15 * time.Minute
+
+// ThrottleEventType represents the type of a throttle event.
+type ThrottleEventType string
+
+// Throttle event types emitted by the [Throttle] authentication strategy.
+const (
+	// ThrottleEventFailure is emitted whenever a failed authentication attempt
+	// has been recorded.
+	ThrottleEventFailure ThrottleEventType = "failure"
+	// ThrottleEventDelay is emitted whenever an authentication attempt is
+	// delayed due to previous failures.
+	ThrottleEventDelay ThrottleEventType = "delay"
+	// ThrottleEventLockout is emitted whenever the maximum amount of failures
+	// has been reached and the user or address is locked.
+	ThrottleEventLockout ThrottleEventType = "lockout"
+	// ThrottleEventRejected is emitted whenever an authentication attempt is
+	// rejected since the user or address is locked.
+	ThrottleEventRejected ThrottleEventType = "rejected"
+)
+
+// ThrottleEvent represents a structured event emitted by the [Throttle]
+// authentication strategy.
+type ThrottleEvent struct {
+	Type     ThrottleEventType
+	Username string
+	Address  string
+	// Failures is the highest amount of recorded failures of the user and
+	// address.
+	Failures int
+	// Delay is the duration the authentication attempt is delayed.
+	Delay time.Duration
+	// LockedUntil is set when the user or address is locked.
+	LockedUntil time.Time
+	// Err is the authentication error of a failed attempt.
+	Err error
+}
+
+// ThrottleRecord represents the failed authentication attempts recorded for a
+// single key.
+type ThrottleRecord struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// ThrottleStore stores the failed authentication attempts tracked by the
+// [Throttle] authentication strategy. Keys identify either a user or a remote
+// address. Stores could be shared across servers to throttle attempts across
+// multiple instances.
+type ThrottleStore interface {
+	// Load returns the record of the given key. An empty record is returned
+	// when no failures have been recorded.
+	Load(ctx context.Context, key string) (ThrottleRecord, error)
+	// RecordFailure records a failed attempt at the given time and returns
+	// the updated record. Failures recorded before the given window should
+	// be forgotten.
+	RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (ThrottleRecord, error)
+	// Reset forgets all failures recorded for the given key.
+	Reset(ctx context.Context, key string) error
+}
+
+// ThrottleConfig represents the configuration of the [Throttle]
+// authentication strategy.
+type ThrottleConfig struct {
+	// Store is used to track failed attempts. A in-memory store is used when
+	// no store is configured.
+	Store ThrottleStore
+	// BaseDelay is the delay applied after the first failure. The delay is
+	// doubled for each consecutive failure. No delay is applied when zero.
+	BaseDelay time.Duration
+	// MaxDelay caps the applied delay. The delay is not capped when zero.
+	MaxDelay time.Duration
+	// MaxFailures is the amount of failures after which the user or address
+	// is locked until the window has passed. Lockout is disabled when zero.
+	MaxFailures int
+	// Window is the duration after which failures are forgotten, the
+	// [DefaultThrottleWindow] is used when zero.
+	Window time.Duration
+	// Events is called for each emitted throttle event.
+	Events func(ctx context.Context, event ThrottleEvent)
+}
+
+// Throttle wraps the given authentication strategy and throttles failed
+// authentication attempts. Failures are tracked per user and per remote
+// address. Attempts following a failure are delayed exponentially and the user
+// or address is locked temporarily once the maximum amount of failures has
+// been reached. Only authentication failures (invalid password or invalid
+// authorization) are counted, a successful attempt resets the failures of the
+// user.
+func Throttle(strategy AuthStrategy, config ThrottleConfig) AuthStrategy {
+	if config.Store == nil {
+		config.Store = NewMemoryThrottleStore()
+	}
+
+	if config.Window <= 0 {
+		config.Window = DefaultThrottleWindow
+	}
+
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		params := ClientParameters(ctx)
+		event := ThrottleEvent{
+			Username: params[ParamUsername],
+			Address:  remoteHost(ctx),
+		}
+
+		keys := []string{"user:" + event.Username, "addr:" + event.Address}
+
+		now := time.Now()
+		for _, key := range keys {
+			record, err := config.Store.Load(ctx, key)
+			if err != nil {
+				return ctx, err
+			}
+
+			if record.Failures == 0 || now.Sub(record.LastFailure) > config.Window {
+				continue
+			}
+
+			event.Failures = max(event.Failures, record.Failures)
+			if config.MaxFailures > 0 && record.Failures >= config.MaxFailures {
+				until := record.LastFailure.Add(config.Window)
+				if until.After(event.LockedUntil) {
+					event.LockedUntil = until
+				}
+			}
+		}
+
+		if !event.LockedUntil.IsZero() {
+			config.emit(ctx, ThrottleEventRejected, event)
+			return ctx, writeAuthFailure(writer, newErrInvalidAuthorization("too many failed authentication attempts"))
+		}
+
+		event.Delay = config.delay(event.Failures)
+		if event.Delay > 0 {
+			config.emit(ctx, ThrottleEventDelay, event)
+
+			timer := time.NewTimer(event.Delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		ctx, err = strategy(ctx, writer, reader)
+		if err == nil {
+			return ctx, config.Store.Reset(ctx, keys[0])
+		}
+
+		code := pgerror.GetCode(err)
+		if code != codes.InvalidPassword && code != codes.InvalidAuthorizationSpecification {
+			return ctx, err
+		}
+
+		event.Err = err
+		event.Delay = 0
+		now = time.Now()
+		for _, key := range keys {
+			record, recordErr := config.Store.RecordFailure(ctx, key, now, config.Window)
+			if recordErr != nil {
+				return ctx, recordErr
+			}
+
+			event.Failures = max(event.Failures, record.Failures)
+		}
+
+		config.emit(ctx, ThrottleEventFailure, event)
+		if config.MaxFailures > 0 && event.Failures >= config.MaxFailures {
+			event.LockedUntil = now.Add(config.Window)
+			config.emit(ctx, ThrottleEventLockout, event)
+		}
+
+		return ctx, err
+	}
+}
+
+// delay returns the delay applied to an attempt following the given amount of
+// failures.
+func (config ThrottleConfig) delay(failures int) time.Duration {
+	if failures == 0 || config.BaseDelay <= 0 {
+		return 0
+	}
+
+	// NOTE: the delay is saturated to prevent overflows.
+	delay := time.Duration(math.MaxInt64)
+	shift := min(failures-1, 62)
+	if config.BaseDelay <= delay>>shift {
+		delay = config.BaseDelay << shift
+	}
+
+	if config.MaxDelay > 0 && delay > config.MaxDelay {
+		return config.MaxDelay
+	}
+
+	return delay
+}
+
+func (config ThrottleConfig) emit(ctx context.Context, t ThrottleEventType, event ThrottleEvent) {
+	if config.Events == nil {
+		return
+	}
+
+	event.Type = t
+	config.Events(ctx, event)
+}
+
+// MemoryThrottleStore is a [ThrottleStore] keeping track of failed attempts
+// in memory. Failures are not shared across servers.
+type MemoryThrottleStore struct {
+	mu      sync.Mutex
+	records map[string]ThrottleRecord
+	// sweep is the amount of records after which expired records are removed.
+	sweep int
+}
+
+// NewMemoryThrottleStore constructs a new in-memory throttle store.
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{
+		records: make(map[string]ThrottleRecord),
+		sweep:   1024,
+	}
+}
+
+// Load implements the [ThrottleStore] interface.
+func (store *MemoryThrottleStore) Load(ctx context.Context, key string) (ThrottleRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.records[key], nil
+}
+
+// RecordFailure implements the [ThrottleStore] interface.
+func (store *MemoryThrottleStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (ThrottleRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// NOTE: expired records are removed once the amount of records grows in
+	// order to prevent unbounded growth when attempts are made using many
+	// different users or addresses.
+	if len(store.records) >= store.sweep {
+		for k, record := range store.records {
+			if at.Sub(record.LastFailure) > window {
+				delete(store.records, k)
+			}
+		}
+
+		store.sweep = max(1024, 2*len(store.records))
+	}
+
+	record := store.records[key]
+	if at.Sub(record.LastFailure) > window {
+		record.Failures = 0
+	}
+
+	record.Failures++
+	record.LastFailure = at
+	store.records[key] = record
+	return record, nil
+}
+
+// Reset implements the [ThrottleStore] interface.
+func (store *MemoryThrottleStore) Reset(ctx context.Context, key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.records, key)
+	return nil
+}
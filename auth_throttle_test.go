@@ -0,0 +1,146 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottleDelay(t *testing.T) {
+	t.Parallel()
+
+	config := ThrottleConfig{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	require.Equal(t, time.Duration(0), config.delay(0))
+	require.Equal(t, 100*time.Millisecond, config.delay(1))
+	require.Equal(t, 200*time.Millisecond, config.delay(2))
+	require.Equal(t, 800*time.Millisecond, config.delay(4))
+	require.Equal(t, time.Second, config.delay(5))
+	require.Equal(t, time.Second, config.delay(1000))
+
+	unbounded := ThrottleConfig{BaseDelay: time.Hour}
+	require.Equal(t, time.Duration(math.MaxInt64), unbounded.delay(1000))
+}
+
+func TestMemoryThrottleStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryThrottleStore()
+	now := time.Now()
+
+	record, err := store.RecordFailure(ctx, "user:john", now, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 1, record.Failures)
+
+	record, err = store.RecordFailure(ctx, "user:john", now.Add(time.Second), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 2, record.Failures)
+
+	// NOTE: failures outside of the window should be forgotten.
+	record, err = store.RecordFailure(ctx, "user:john", now.Add(2*time.Minute), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 1, record.Failures)
+
+	require.NoError(t, store.Reset(ctx, "user:john"))
+	record, err = store.Load(ctx, "user:john")
+	require.NoError(t, err)
+	require.Zero(t, record.Failures)
+}
+
+func TestThrottle(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, password == "secret", nil
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	var mu sync.Mutex
+	var events []ThrottleEvent
+
+	strategy := Throttle(ClearTextPassword(validate), ThrottleConfig{
+		BaseDelay:   10 * time.Millisecond,
+		MaxFailures: 3,
+		Window:      time.Minute,
+		Events: func(ctx context.Context, event ThrottleEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		},
+	})
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(strategy))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(user, password string) error {
+		ctx := context.Background()
+		connstr := fmt.Sprintf("postgres://%s:%s@%s:%d?sslmode=disable", user, password, address.IP, address.Port)
+		conn, err := pgx.Connect(ctx, connstr)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close(ctx)
+	}
+
+	types := func() []ThrottleEventType {
+		mu.Lock()
+		defer mu.Unlock()
+
+		result := make([]ThrottleEventType, len(events))
+		for index, event := range events {
+			result[index] = event.Type
+		}
+
+		return result
+	}
+
+	require.NoError(t, connect("john", "secret"))
+	require.Error(t, connect("john", "wrong"))
+	require.Equal(t, []ThrottleEventType{ThrottleEventFailure}, types())
+
+	// NOTE: a successful attempt resets the failures of the user but the
+	// attempt is still delayed due to the failures of the address.
+	require.NoError(t, connect("john", "secret"))
+	require.Equal(t, []ThrottleEventType{ThrottleEventFailure, ThrottleEventDelay}, types())
+
+	require.Error(t, connect("john", "wrong"))
+	require.Error(t, connect("john", "wrong"))
+	require.Equal(t, []ThrottleEventType{
+		ThrottleEventFailure,
+		ThrottleEventDelay,
+		ThrottleEventDelay,
+		ThrottleEventFailure,
+		ThrottleEventDelay,
+		ThrottleEventFailure,
+		ThrottleEventLockout,
+	}, types())
+
+	// NOTE: the address is locked, even valid credentials are rejected.
+	err = connect("jane", "secret")
+	require.ErrorContains(t, err, "too many failed authentication attempts")
+
+	mu.Lock()
+	last := events[len(events)-1]
+	mu.Unlock()
+
+	require.Equal(t, ThrottleEventRejected, last.Type)
+	require.Equal(t, "jane", last.Username)
+	require.Equal(t, "127.0.0.1", last.Address)
+	require.Equal(t, 3, last.Failures)
+	require.False(t, last.LockedUntil.IsZero())
+}
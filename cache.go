@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"iter"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
@@ -21,6 +22,7 @@ type Statement struct {
 	fn         PreparedStatementFn
 	parameters []uint32
 	columns    Columns
+	query      string
 }
 
 func DefaultStatementCacheFn() StatementCache {
@@ -46,6 +48,7 @@ func (cache *DefaultStatementCache) Set(ctx context.Context, name string, stmt *
 		fn:         stmt.fn,
 		parameters: stmt.parameters,
 		columns:    stmt.columns,
+		query:      stmt.query,
 	}
 
 	return nil
@@ -96,6 +99,12 @@ type Portal struct {
 	err error
 	// Set to true when execution of the portal has finished.
 	done bool
+	// cancel cancels the context passed to the statement. The context is
+	// canceled with errQueryCanceled when the client cancels the query.
+	cancel context.CancelCauseFunc
+	// ctx resolves the values of the context passed to the statement using
+	// the context of the most recent execute call.
+	ctx *portalContext
 
 	// pending is closed when the most recently launched async goroutine
 	// finishes. A new goroutine for the same portal waits on this channel
@@ -127,6 +136,30 @@ func (p *Portal) close() {
 		p.next = nil
 		p.stop = nil
 	}
+
+	if p.cancel != nil {
+		p.cancel(context.Canceled)
+	}
+}
+
+// portalContext is the parent of the context passed to the statement of a
+// portal. The statement is started by the first execute call but could
+// continue inside later execute calls (e.g. FETCH on a cursor). Values are
+// resolved using the context of the most recent execute call, while
+// cancellation is bound to the context of the first execute call.
+type portalContext struct {
+	context.Context
+	current atomic.Pointer[context.Context]
+}
+
+func newPortalContext(ctx context.Context) *portalContext {
+	portal := &portalContext{Context: ctx}
+	portal.current.Store(&ctx)
+	return portal
+}
+
+func (ctx *portalContext) Value(key any) any {
+	return (*ctx.current.Load()).Value(key)
 }
 
 func portalSuspended(writer *buffer.Writer) error {
@@ -144,10 +177,12 @@ func (p *Portal) execute(ctx context.Context, limit Limit, reader *buffer.Reader
 		return commandComplete(writer, p.tag)
 	}
 
+	session, _ := GetSession(ctx)
 	if p.next == nil {
 		// This is the first execute call on this portal. So let's start the
 		// execution. Otherwise we continue from where we left off.
-		session, _ := GetSession(ctx)
+		p.ctx = newPortalContext(ctx)
+		ctx, p.cancel = context.WithCancelCause(p.ctx)
 		// Create a simple push-style iterator (iter.Seq) around the
 		// statement.fn.
 		seq := func(yield func(struct{}) bool) {
@@ -165,11 +200,23 @@ func (p *Portal) execute(ctx context.Context, limit Limit, reader *buffer.Reader
 			if err != nil && !errors.Is(err, ErrSuspendedHandlerClosed) {
 				p.err = err
 			}
+
+			// NOTE: the error returned by a canceled statement is reported
+			// to the client as query canceled.
+			if p.err != nil && errors.Is(context.Cause(ctx), errQueryCanceled) {
+				p.err = errQueryCanceled
+			}
 		}
 
 		// Then we convert that push-style iterator into a pull-style iterator,
 		// so we can suspend the iterator when we reach the row limit.
 		p.next, p.stop = iter.Pull(seq)
+	} else {
+		p.ctx.current.Store(&ctx)
+	}
+
+	if session != nil {
+		defer session.trackExecution(p)()
 	}
 
 	var count Limit
@@ -64,3 +64,37 @@ func TestDeleteByStatementFromHandler(t *testing.T) {
 	require.NoError(t, err)
 	assert.Nil(t, portal)
 }
+
+// TestPortalContextValues verifies that a suspended statement resolves context
+// values using the context of the most recent execute call.
+func TestPortalContextValues(t *testing.T) {
+	t.Parallel()
+
+	type key struct{}
+	cache := &DefaultPortalCache{}
+
+	var values []any
+	stmt := &Statement{
+		fn: func(ctx context.Context, writer DataWriter, _ []Parameter) error {
+			for range 2 {
+				values = append(values, ctx.Value(key{}))
+				err := writer.Row(nil)
+				if err != nil {
+					return err
+				}
+			}
+			return writer.Complete("OK")
+		},
+	}
+
+	ctx := context.Background()
+	require.NoError(t, cache.Bind(ctx, "portal", stmt, nil, nil))
+
+	err := cache.Execute(context.WithValue(ctx, key{}, "first"), "portal", 1, nil, newDiscardWriter())
+	require.NoError(t, err)
+
+	err = cache.Execute(context.WithValue(ctx, key{}, "second"), "portal", 1, nil, newDiscardWriter())
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{"first", "second"}, values)
+}
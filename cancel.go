@@ -0,0 +1,110 @@
+package wire
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// errQueryCanceled is set as cancellation cause of the context passed to
+// executing statements when the query is canceled by the client.
+var errQueryCanceled = psqlerr.WithSeverity(psqlerr.WithCode(errors.New("canceling statement due to user request"), codes.QueryCanceled), psqlerr.LevelError)
+
+// registrySecretKeyLength is the length of the secret keys issued by the
+// cancellation registry to connections using protocol version 3.2.
+const registrySecretKeyLength = 32
+
+// cancelRegistry keeps track of all live sessions and their backend key data.
+// Incoming cancel requests are matched against the registered sessions in
+// order to cancel the statements executed by the matching session.
+type cancelRegistry struct {
+	mu       sync.Mutex
+	sessions map[int32]*cancelEntry
+}
+
+type cancelEntry struct {
+	secretKey []byte
+	session   *Session
+}
+
+func newCancelRegistry() *cancelRegistry {
+	return &cancelRegistry{
+		sessions: make(map[int32]*cancelEntry),
+	}
+}
+
+// cancelRegistry returns the query cancellation registry of the server. The
+// registry is allocated on first use, allowing servers to be constructed
+// without [NewServer].
+func (srv *Server) cancelRegistry() *cancelRegistry {
+	srv.cancellationOnce.Do(func() {
+		srv.cancellation = newCancelRegistry()
+	})
+
+	return srv.cancellation
+}
+
+// register registers the given session and returns a unique random process ID
+// and a random secret key of the given length identifying the session.
+func (registry *cancelRegistry) register(session *Session, length int) (processID int32, secretKey []byte, err error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	key := make([]byte, 4)
+	for {
+		_, err = rand.Read(key)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		processID = int32(binary.BigEndian.Uint32(key) & 0x7fffffff)
+		if _, exists := registry.sessions[processID]; processID != 0 && !exists {
+			break
+		}
+	}
+
+	secretKey = make([]byte, length)
+	_, err = rand.Read(secretKey)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	registry.sessions[processID] = &cancelEntry{
+		secretKey: secretKey,
+		session:   session,
+	}
+
+	return processID, secretKey, nil
+}
+
+// unregister removes the session of the given process ID from the registry.
+func (registry *cancelRegistry) unregister(processID int32) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.sessions, processID)
+}
+
+// cancel cancels the statements currently executed by the session matching
+// the given process ID and secret key. Requests not matching any session are
+// ignored. True is returned when a matching session has been found.
+func (registry *cancelRegistry) cancel(processID int32, secretKey []byte) bool {
+	registry.mu.Lock()
+	entry, has := registry.sessions[processID]
+	registry.mu.Unlock()
+
+	if !has {
+		return false
+	}
+
+	if subtle.ConstantTimeCompare(entry.secretKey, secretKey) != 1 {
+		return false
+	}
+
+	entry.session.cancelExecution()
+	return true
+}
@@ -1,6 +1,7 @@
 package wire
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
@@ -8,6 +9,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"database/sql"
+	"encoding/binary"
 	"encoding/pem"
 	"fmt"
 	"math/big"
@@ -19,7 +21,12 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
-	_ "github.com/lib/pq"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/lib/pq"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
 )
 
 type testServer struct {
@@ -31,7 +38,7 @@ type testServer struct {
 
 type testSession struct {
 	ProcessID int32
-	SecretKey int32
+	SecretKey []byte
 	Cancel    context.CancelFunc
 	Addr      net.Addr
 }
@@ -51,9 +58,11 @@ func newTestServer(tlsConfig *tls.Config) (*testServer, error) {
 	return ts, nil
 }
 
-func (ts *testServer) backendKeyData(ctx context.Context) (int32, int32) {
+func (ts *testServer) backendKeyData(ctx context.Context) (int32, []byte) {
 	rng := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
-	processID, secretKey := rng.Int31(), rng.Int31()
+	processID := rng.Int31()
+	secretKey := make([]byte, 4)
+	rng.Read(secretKey) //nolint:errcheck
 
 	ts.mutex.Lock()
 	ts.sessions[processID] = &testSession{
@@ -66,12 +75,12 @@ func (ts *testServer) backendKeyData(ctx context.Context) (int32, int32) {
 	return processID, secretKey
 }
 
-func (ts *testServer) cancelRequest(ctx context.Context, processID, secretKey int32) error {
+func (ts *testServer) cancelRequest(ctx context.Context, processID int32, secretKey []byte) error {
 	ts.mutex.RLock()
 	session, exists := ts.sessions[processID]
 	ts.mutex.RUnlock()
 
-	if !exists || session.SecretKey != secretKey {
+	if !exists || !bytes.Equal(session.SecretKey, secretKey) {
 		return nil
 	}
 
@@ -247,3 +256,157 @@ func TestCancellationWithTLS(t *testing.T) {
 	port, server := startTestServer(t, true)
 	testCancellation(t, port, server, "require")
 }
+
+func TestQueryCancellation(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		return Prepared(NewStatement(handle)), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), QueryCancellation())
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connStr := fmt.Sprintf("host=%s port=%d dbname=test user=test sslmode=disable", address.IP, address.Port)
+	db, err := sql.Open("postgres", connStr)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err = db.ExecContext(ctx, "SELECT pg_sleep(60)")
+	require.Error(t, err)
+
+	var pqErr *pq.Error
+	require.ErrorAs(t, err, &pqErr)
+	require.Equal(t, pq.ErrorCode(codes.QueryCanceled), pqErr.Code)
+	require.Equal(t, "canceling statement due to user request", pqErr.Message)
+}
+
+func TestCancelRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := newCancelRegistry()
+	session := &Session{}
+
+	processID, secretKey, err := registry.register(session, registrySecretKeyLength)
+	require.NoError(t, err)
+	require.Positive(t, processID)
+	require.Len(t, secretKey, registrySecretKeyLength)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	portal := &Portal{cancel: cancel}
+	release := session.trackExecution(portal)
+
+	require.False(t, registry.cancel(processID, secretKey[:minSecretKeyLength]))
+	require.NoError(t, ctx.Err())
+
+	require.False(t, registry.cancel(processID+1, secretKey))
+	require.NoError(t, ctx.Err())
+
+	require.True(t, registry.cancel(processID, secretKey))
+	require.ErrorIs(t, context.Cause(ctx), errQueryCanceled)
+	release()
+
+	registry.unregister(processID)
+	require.False(t, registry.cancel(processID, secretKey))
+}
+
+func TestCancelRegistryWithoutNewServer(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{QueryCancellation: true}
+	registry := srv.cancelRegistry()
+	require.NotNil(t, registry)
+	require.Same(t, registry, srv.cancelRegistry())
+	require.False(t, registry.cancel(1, make([]byte, minSecretKeyLength)))
+}
+
+func TestProtocolVersion32Cancellation(t *testing.T) {
+	t.Parallel()
+
+	versions := make(chan types.Version, 1)
+	started := make(chan struct{}, 1)
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		versions <- ProtocolVersion(ctx)
+
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		return Prepared(NewStatement(handle)), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), QueryCancellation())
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	conn, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+
+	client := mock.NewClient(t, conn)
+	client.HandshakeVersion(t, types.Version32)
+	client.Authenticate(t)
+
+	var typed types.ServerMessage
+	for {
+		typed, _, err = client.ReadTypedMsg()
+		require.NoError(t, err)
+
+		if typed != types.ServerParameterStatus {
+			break
+		}
+	}
+
+	require.Equal(t, types.ServerBackendKeyData, typed)
+
+	processID, err := client.GetUint32()
+	require.NoError(t, err)
+
+	secretKey := bytes.Clone(client.Msg)
+	require.Len(t, secretKey, registrySecretKeyLength)
+
+	client.ReadyForQuery(t)
+
+	client.Start(types.ClientSimpleQuery)
+	client.AddString("SELECT pg_sleep(60)")
+	client.AddNullTerminate()
+	require.NoError(t, client.End())
+
+	require.Equal(t, types.Version32, <-versions)
+	<-started
+
+	cancel, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+	defer cancel.Close() //nolint:errcheck
+
+	request := binary.BigEndian.AppendUint32(nil, uint32(12+len(secretKey)))
+	request = binary.BigEndian.AppendUint32(request, uint32(types.VersionCancel))
+	request = binary.BigEndian.AppendUint32(request, processID)
+	request = append(request, secretKey...)
+
+	_, err = cancel.Write(request)
+	require.NoError(t, err)
+
+	client.Error(t)
+	client.ReadyForQuery(t)
+	client.Close(t)
+}
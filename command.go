@@ -9,6 +9,8 @@ import (
 	"log/slog"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/jeroenrinzema/psql-wire/codes"
 	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
@@ -58,6 +60,10 @@ type Session struct {
 	Portals    PortalCache
 	Attributes map[string]interface{}
 	reader     *buffer.Reader
+	writer     *buffer.Writer
+
+	// ProtocolVersion is the protocol version negotiated with the client.
+	ProtocolVersion types.Version
 
 	// pipelining
 	ParallelPipeline ParallelPipelineConfig
@@ -74,6 +80,78 @@ type Session struct {
 	// discard messages until it receives a Sync, then respond with
 	// ReadyForQuery.
 	discardUntilSync bool
+
+	// executing contains the portals which are currently being executed.
+	// Executing portals are canceled when a matching cancel request is
+	// received.
+	executing   map[*Portal]struct{}
+	executingMu sync.Mutex
+
+	// txStatus is the transaction status reported to the client inside
+	// ReadyForQuery messages. txOpen is set when a transaction has been
+	// started using the configured transaction handler. savepoints contains
+	// the savepoints established inside the current transaction block.
+	txStatus   types.ServerStatus
+	txOpen     bool
+	savepoints []string
+	txMu       sync.Mutex
+
+	// declaredCursors contains the cursors declared using the simple query
+	// protocol.
+	declaredCursors map[string]*cursor
+	cursorsMu       sync.Mutex
+
+	// replication is set when the client requested a logical replication
+	// connection. Replication commands are only accepted on these sessions.
+	replication bool
+
+	// processID is the process ID send to the client inside the
+	// BackendKeyData message, zero when no backend key data has been send.
+	// The process ID is included inside notifications published by the
+	// session.
+	processID int32
+
+	// commandMu is held while a command is being handled. idle is set once a
+	// command cycle has been completed with a ReadyForQuery message.
+	// Notifications are only delivered while the session is idle. command
+	// contains the sequence number of the command being handled, zero while
+	// no command is being handled.
+	commandMu     sync.Mutex
+	idle          bool
+	commands      uint64
+	command       atomic.Uint64
+	notifications notificationQueue
+
+	// settings contains the configuration parameters of the session.
+	settings settingStore
+}
+
+// trackExecution marks the given portal as executing until the returned
+// function is called.
+func (srv *Session) trackExecution(portal *Portal) func() {
+	srv.executingMu.Lock()
+	defer srv.executingMu.Unlock()
+
+	if srv.executing == nil {
+		srv.executing = make(map[*Portal]struct{})
+	}
+
+	srv.executing[portal] = struct{}{}
+	return func() {
+		srv.executingMu.Lock()
+		defer srv.executingMu.Unlock()
+		delete(srv.executing, portal)
+	}
+}
+
+// cancelExecution cancels the context of all currently executing portals.
+func (srv *Session) cancelExecution() {
+	srv.executingMu.Lock()
+	defer srv.executingMu.Unlock()
+
+	for portal := range srv.executing {
+		portal.cancel(errQueryCanceled)
+	}
 }
 
 // isExtendedQueryMessage returns true for message types that belong to the
@@ -96,14 +174,33 @@ func isExtendedQueryMessage(t types.ClientMessage) bool {
 // or the connection is terminated.
 func (srv *Session) consumeCommands(ctx context.Context, conn net.Conn, reader *buffer.Reader, writer *buffer.Writer) error {
 	srv.reader = reader
+	srv.writer = writer
 	srv.logger.Debug("ready for query... starting to consume commands")
 
-	err := readyForQuery(writer, types.ServerIdle)
+	err := srv.readyForQuery(writer)
 	if err != nil {
 		return err
 	}
 
 	defer srv.Close()
+	defer srv.closeTx(ctx)
+
+	srv.idle = true
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.consumeNotifications(done, writer)
+	}()
+
+	defer func() {
+		if srv.Notifications != nil {
+			srv.Notifications.remove(srv)
+		}
+
+		close(done)
+		<-stopped
+	}()
 
 	for {
 		if err = srv.consumeSingleCommand(ctx, reader, writer, conn); err != nil {
@@ -144,7 +241,20 @@ func (srv *Session) consumeSingleCommand(ctx context.Context, reader *buffer.Rea
 	srv.wg.Add(1)
 	srv.closingMu.RUnlock()
 	srv.logger.Debug("<- incoming command", slog.Int("length", length), slog.String("type", t.String()))
+	srv.commandMu.Lock()
+	srv.idle = false
+	srv.commands++
+	srv.command.Store(srv.commands)
 	err = srv.handleCommand(ctx, conn, t, reader, writer)
+	srv.command.Store(0)
+	if err == nil && (t == types.ClientSimpleQuery || t == types.ClientSync) {
+		srv.idle = true
+	}
+
+	if err == nil {
+		err = srv.deliverNotifications(writer)
+	}
+	srv.commandMu.Unlock()
 	srv.wg.Done()
 	if errors.Is(err, io.EOF) {
 		return nil
@@ -217,9 +327,6 @@ func (srv *Session) handleCommand(ctx context.Context, conn net.Conn, t types.Cl
 		// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-EXT-QUERY
 		return srv.handleDescribe(ctx, reader, writer)
 	case types.ClientSync:
-		// TODO: Include the ability to catch sync messages in order to
-		// close the current transaction.
-		//
 		// At completion of each series of extended-query messages, the frontend
 		// should issue a Sync message. This parameterless message causes the
 		// backend to close the current transaction if it's not inside a
@@ -312,25 +419,47 @@ func (srv *Session) handleSimpleQuery(ctx context.Context, reader *buffer.Reader
 			return err
 		}
 
-		return readyForQuery(writer, types.ServerIdle)
+		return srv.readyForQuery(writer)
 	}
 
-	statements, err := srv.parse(ctx, query)
+	err = srv.checkTxFailed(query)
 	if err != nil {
 		return srv.WriteError(writer, err)
 	}
 
+	handled, err := srv.handleReplicationQuery(ctx, query, reader, writer)
+	if handled {
+		return err
+	}
+
+	handled, err = srv.handleCursorQuery(ctx, query, reader, writer)
+	if handled {
+		return err
+	}
+
+	statements, err := srv.parseQuery(ctx, query)
+	if err != nil {
+		return srv.rollbackAndWriteError(ctx, writer, err)
+	}
+
 	if len(statements) == 0 {
-		return srv.WriteError(writer, NewErrUndefinedStatement())
+		return srv.rollbackAndWriteError(ctx, writer, NewErrUndefinedStatement())
 	}
 
 	// NOTE: it is possible to send multiple statements in one simple query.
+	// The statements are executed inside a single implicit transaction unless
+	// a transaction block is started.
 	for index := range statements {
-		err = statements[index].columns.Define(ctx, writer, nil)
+		err = srv.beginTx(ctx)
 		if err != nil {
 			return srv.WriteError(writer, err)
 		}
 
+		err = statements[index].columns.Define(ctx, writer, nil)
+		if err != nil {
+			return srv.rollbackAndWriteError(ctx, writer, err)
+		}
+
 		portal := &Portal{
 			statement: &Statement{
 				fn:      statements[index].fn,
@@ -339,11 +468,16 @@ func (srv *Session) handleSimpleQuery(ctx context.Context, reader *buffer.Reader
 		}
 		err = portal.execute(ctx, NoLimit, reader, writer)
 		if err != nil {
-			return srv.WriteError(writer, err)
+			return srv.rollbackAndWriteError(ctx, writer, err)
 		}
 	}
 
-	return readyForQuery(writer, types.ServerIdle)
+	err = srv.endImplicitTx(ctx, true)
+	if err != nil {
+		return srv.WriteError(writer, err)
+	}
+
+	return srv.readyForQuery(writer)
 }
 
 func (srv *Session) handleParse(ctx context.Context, reader *buffer.Reader, writer *buffer.Writer) error {
@@ -400,11 +534,18 @@ func (srv *Session) handleParse(ctx context.Context, reader *buffer.Reader, writ
 		return srv.parsePipelined(ctx, writer, name, query)
 	}
 
-	statement, err := singleStatement(srv.parse(ctx, query))
+	err = srv.checkTxFailed(query)
+	if err != nil {
+		return srv.WriteError(writer, err)
+	}
+
+	statement, err := singleStatement(srv.parseQuery(ctx, query))
 	if err != nil {
 		return srv.WriteError(writer, err)
 	}
 
+	statement = statement.withQuery(query)
+
 	srv.logger.Debug("incoming extended query", slog.String("query", query), slog.String("name", name), slog.Int("parameters", len(statement.parameters)))
 
 	err = srv.Statements.Set(ctx, name, statement)
@@ -418,11 +559,18 @@ func (srv *Session) handleParse(ctx context.Context, reader *buffer.Reader, writ
 
 // parsePipelined handles Parse in parallel pipeline mode
 func (srv *Session) parsePipelined(ctx context.Context, writer *buffer.Writer, name, query string) error {
-	statement, err := singleStatement(srv.parse(ctx, query))
+	err := srv.checkTxFailed(query)
 	if err != nil {
 		return srv.drainQueueAndWriteError(ctx, writer, err)
 	}
 
+	statement, err := singleStatement(srv.parseQuery(ctx, query))
+	if err != nil {
+		return srv.drainQueueAndWriteError(ctx, writer, err)
+	}
+
+	statement = statement.withQuery(query)
+
 	srv.logger.Debug("incoming extended query", slog.String("query", query), slog.String("name", name), slog.Int("parameters", len(statement.parameters)))
 
 	err = srv.Statements.Set(ctx, name, statement)
@@ -575,6 +723,11 @@ func (srv *Session) handleBind(ctx context.Context, reader *buffer.Reader, write
 		return NewErrUnkownStatement(statement)
 	}
 
+	err = srv.checkTxFailed(stmt.query)
+	if err != nil {
+		return srv.WriteError(writer, err)
+	}
+
 	err = srv.Portals.Bind(ctx, name, stmt, parameters, formats)
 	if err != nil {
 		return err
@@ -595,6 +748,11 @@ func (srv *Session) bindPipelined(ctx context.Context, writer *buffer.Writer, na
 		return srv.drainQueueAndWriteError(ctx, writer, NewErrUnkownStatement(statement))
 	}
 
+	err = srv.checkTxFailed(stmt.query)
+	if err != nil {
+		return srv.drainQueueAndWriteError(ctx, writer, err)
+	}
+
 	err = srv.Portals.Bind(ctx, name, stmt, parameters, formats)
 	if err != nil {
 		return srv.drainQueueAndWriteError(ctx, writer, err)
@@ -716,6 +874,14 @@ func (srv *Session) handleExecute(ctx context.Context, reader *buffer.Reader, wr
 
 	srv.logger.Debug("executing", slog.String("name", name), slog.Uint64("limit", uint64(limit)))
 
+	err = srv.beginTx(ctx)
+	if err != nil {
+		if srv.ParallelPipeline.Enabled {
+			return srv.drainQueueAndWriteError(ctx, writer, err)
+		}
+		return srv.WriteError(writer, err)
+	}
+
 	if srv.ParallelPipeline.Enabled {
 		return srv.executePipelined(ctx, writer, name, limit)
 	}
@@ -835,11 +1001,22 @@ func (srv *Session) handleSync(ctx context.Context, writer *buffer.Writer) error
 		}
 	}
 
-	// Sync always resets discardUntilSync, even if queue processing set it.
+	// Sync commits the implicit transaction, or rolls it back when an error
+	// occurred. Sync always resets discardUntilSync, even if queue processing
+	// set it.
+	commit := !srv.discardUntilSync
 	srv.discardUntilSync = false
 
-	// Original synchronous behavior - just return ReadyForQuery
-	return readyForQuery(writer, types.ServerIdle)
+	err := srv.endImplicitTx(ctx, commit)
+	if err != nil {
+		// NOTE: no messages are skipped when an error is detected while
+		// processing Sync, ReadyForQuery is always written.
+		if werr := WriteUnterminatedError(writer, err); werr != nil {
+			return werr
+		}
+	}
+
+	return srv.readyForQuery(writer)
 }
 
 // processResponseQueue drains the queue and writes all events to the writer
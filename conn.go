@@ -2,9 +2,12 @@ package wire
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
 
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
 )
 
 type ctxKey int
@@ -14,6 +17,12 @@ const (
 	ctxClientMetadata
 	ctxServerMetadata
 	ctxRemoteAddr
+	ctxTLSState
+	ctxTLSCertificate
+	ctxSASLMechanisms
+	ctxPeerCredentials
+	ctxProtocolVersion
+	ctxProtocolExtensions
 )
 
 // setTypeInfo constructs a new Postgres type connection info for the given value
@@ -47,6 +56,106 @@ func RemoteAddress(ctx context.Context) net.Addr {
 	return val.(net.Addr)
 }
 
+// remoteHost returns the host identifying the remote address of the given
+// connection. Connections over Unix domain sockets are identified as [local].
+func remoteHost(ctx context.Context) string {
+	switch addr := RemoteAddress(ctx).(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UnixAddr:
+		return "[local]"
+	case nil:
+		return ""
+	default:
+		return addr.String()
+	}
+}
+
+func setProtocolVersion(ctx context.Context, version types.Version) context.Context {
+	return context.WithValue(ctx, ctxProtocolVersion, version)
+}
+
+// ProtocolVersion returns the protocol version negotiated with the client if
+// it has been set inside the given context.
+func ProtocolVersion(ctx context.Context) types.Version {
+	val := ctx.Value(ctxProtocolVersion)
+	if val == nil {
+		return 0
+	}
+
+	return val.(types.Version)
+}
+
+func setProtocolExtensions(ctx context.Context, extensions map[string]string) context.Context {
+	return context.WithValue(ctx, ctxProtocolExtensions, extensions)
+}
+
+// ProtocolExtension returns the value of the given protocol extension
+// requested by the client. The name is given without the _pq_. prefix. False
+// is returned when the client did not request the extension or when the
+// extension has not been registered using [ProtocolExtensions].
+func ProtocolExtension(ctx context.Context, name string) (string, bool) {
+	val := ctx.Value(ctxProtocolExtensions)
+	if val == nil {
+		return "", false
+	}
+
+	value, ok := val.(map[string]string)[name]
+	return value, ok
+}
+
+// setTLSConnectionState constructs a new context containing the state of the
+// TLS connection and the certificate presented by the server.
+func setTLSConnectionState(ctx context.Context, state tls.ConnectionState, certificate *x509.Certificate) context.Context {
+	ctx = context.WithValue(ctx, ctxTLSState, &state)
+	if certificate != nil {
+		ctx = context.WithValue(ctx, ctxTLSCertificate, certificate)
+	}
+
+	return ctx
+}
+
+// TLSConnectionState returns the state of the TLS connection if the client
+// connection has been upgraded to a secure connection. Nil is returned for
+// insecure connections.
+func TLSConnectionState(ctx context.Context) *tls.ConnectionState {
+	val := ctx.Value(ctxTLSState)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*tls.ConnectionState)
+}
+
+// ClientCertificates returns the certificate chain presented by the client
+// during the TLS handshake. The verified chain, starting with the client
+// certificate, is returned when the certificate has been verified by the
+// server. Nil is returned for insecure connections or when no client
+// certificate has been presented.
+func ClientCertificates(ctx context.Context) []*x509.Certificate {
+	state := TLSConnectionState(ctx)
+	if state == nil {
+		return nil
+	}
+
+	if len(state.VerifiedChains) > 0 {
+		return state.VerifiedChains[0]
+	}
+
+	return state.PeerCertificates
+}
+
+// tlsServerCertificate returns the leaf certificate presented by the server
+// during the TLS handshake if it has been set inside the given context.
+func tlsServerCertificate(ctx context.Context) *x509.Certificate {
+	val := ctx.Value(ctxTLSCertificate)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*x509.Certificate)
+}
+
 // Parameters represents a parameters collection of parameter status keys and
 // their values.
 type Parameters map[ParameterStatus]string
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"math"
 
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
 	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
 )
@@ -99,11 +102,21 @@ func NewScanner(tm *pgtype.Map, column Column, format FormatCode) (Scanner, erro
 	}, nil
 }
 
+const (
+	// copyFlagOIDs is set inside the flags of the binary file header whenever
+	// an OID field is included within each row.
+	copyFlagOIDs = 1 << 16
+	// copyFlagsCritical masks the high-order flags (bits 16-31) signaling
+	// backwards-incompatible changes to the binary format. Unexpected critical
+	// flags are rejected while the low-order flags (bits 0-15) are ignored.
+	copyFlagsCritical = 0xffff0000
+)
+
 // NewBinaryColumnReader creates a new column reader that reads rows from the
 // given copy reader and returns the values as a slice of any values. The
 // columns are used to determine the format of the data that is read from the
-// reader. If the end of the copy-in stream is reached, an io.EOF error is
-// returned.
+// reader. If the end of the copy-in stream, or the file trailer, is reached an
+// io.EOF error is returned.
 func NewBinaryColumnReader(ctx context.Context, copy *CopyReader) (_ *BinaryCopyReader, err error) {
 	tm := TypeMap(ctx)
 	if tm == nil {
@@ -120,15 +133,17 @@ func NewBinaryColumnReader(ctx context.Context, copy *CopyReader) (_ *BinaryCopy
 
 	return &BinaryCopyReader{
 		typeMap:  tm,
-		reader:   copy,
+		stream:   copyStream{reader: copy},
 		scanners: scanners,
 	}, nil
 }
 
 type BinaryCopyReader struct {
 	typeMap  *pgtype.Map
-	reader   *CopyReader
+	stream   copyStream
 	scanners []Scanner
+	header   bool
+	oids     bool
 }
 
 // Read reads a single row from the copy-in stream. The read row is returned as a
@@ -139,49 +154,46 @@ func (r *BinaryCopyReader) Read(ctx context.Context) (_ []any, err error) {
 		return nil, ctx.Err()
 	}
 
-	// NOTE: read the next chunk from the copy-in stream if the current chunk is empty.
-	if len(r.reader.Msg) == 0 {
-		err = r.reader.Read()
+	if !r.header {
+		err = r.readHeader()
 		if err != nil {
 			return nil, err
 		}
-
-		has := bytes.HasPrefix(r.reader.Msg, CopySignature)
-		if has {
-			_, err = r.reader.GetBytes(len(CopySignature))
-			if err != nil {
-				return nil, err
-			}
-
-			// NOTE: 2 x 32-bit integer fields are send after the signature which we ignore for now.
-			_, err = r.reader.GetBytes(8)
-			if err != nil {
-				return nil, err
-			}
-		}
 	}
 
-	fields, err := r.reader.GetUint16()
+	count, err := r.stream.next(2)
 	if err != nil {
 		return nil, err
 	}
 
-	row := make([]any, fields)
-	for index := range fields {
-		length, err := r.reader.GetUint32()
+	// NOTE: the file trailer consists of a 16-bit integer word containing -1.
+	fields := int16(binary.BigEndian.Uint16(count))
+	if fields == -1 {
+		return nil, r.stream.drain()
+	}
+
+	if int(fields) != len(r.scanners) {
+		return nil, fmt.Errorf("%w: %d fields, expected %d", ErrCopyRowFields, fields, len(r.scanners))
+	}
+
+	// NOTE: the OID field immediately follows the field count and is not
+	// included within the field count.
+	if r.oids {
+		_, err = r.field()
 		if err != nil {
-			return nil, fmt.Errorf("unexpected field length: %w", err)
+			return nil, err
 		}
+	}
 
-		// NOTE: as a special case, -1 (or 255 255 255 255) indicates a NULL field value.
-		if length == math.MaxUint32 {
-			// r.row[index] = nil
-			continue
+	row := make([]any, fields)
+	for index := range row {
+		value, err := r.field()
+		if err != nil {
+			return nil, err
 		}
 
-		value, err := r.reader.GetBytes(int(length))
-		if err != nil {
-			return nil, fmt.Errorf("unexpected value: %w", err)
+		if value == nil {
+			continue
 		}
 
 		row[index], err = r.scanners[index](value)
@@ -193,6 +205,160 @@ func (r *BinaryCopyReader) Read(ctx context.Context) (_ []any, err error) {
 	return row, nil
 }
 
+// field reads a single length prefixed field value. Nil is returned for NULL
+// field values.
+func (r *BinaryCopyReader) field() ([]byte, error) {
+	bb, err := r.stream.next(4)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected field length: %w", err)
+	}
+
+	// NOTE: as a special case, -1 (or 255 255 255 255) indicates a NULL field value.
+	length := binary.BigEndian.Uint32(bb)
+	if length == math.MaxUint32 {
+		return nil, nil
+	}
+
+	value, err := r.stream.next(int(length))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected value: %w", err)
+	}
+
+	return value, nil
+}
+
+// readHeader reads and validates the binary file header. The header consists
+// of the signature, a 32-bit flags field and a 32-bit length of the header
+// extension area which is skipped.
+// https://www.postgresql.org/docs/current/sql-copy.html#id-1.9.3.55.10.3
+func (r *BinaryCopyReader) readHeader() error {
+	r.header = true
+
+	signature, err := r.stream.next(len(CopySignature))
+	if err == io.EOF {
+		return err
+	}
+
+	if err != nil || !bytes.Equal(signature, CopySignature) {
+		return newErrBadCopyFile("COPY file signature not recognized")
+	}
+
+	bb, err := r.stream.next(4)
+	if err != nil {
+		return newErrBadCopyFile("invalid COPY file header (missing flags)")
+	}
+
+	flags := binary.BigEndian.Uint32(bb)
+	if flags&^copyFlagOIDs&copyFlagsCritical != 0 {
+		return newErrBadCopyFile("unrecognized critical flags in COPY file header")
+	}
+
+	r.oids = flags&copyFlagOIDs != 0
+
+	bb, err = r.stream.next(4)
+	if err != nil {
+		return newErrBadCopyFile("invalid COPY file header (missing length)")
+	}
+
+	_, err = r.stream.next(int(binary.BigEndian.Uint32(bb)))
+	if err != nil {
+		return newErrBadCopyFile("invalid COPY file header (wrong length)")
+	}
+
+	return nil
+}
+
+// NewBinaryColumnWriter creates a new column writer that encodes rows in the
+// binary copy format and writes them to the given writer. Each row is written
+// using a single call to the given writer, a copy writer could be used to
+// write the rows as copy-out data. The values are encoded using the type of
+// the given columns. The file header is written before the first row and the
+// file trailer once the column writer is closed.
+func NewBinaryColumnWriter(ctx context.Context, writer io.Writer, columns Columns) (*BinaryCopyWriter, error) {
+	tm := TypeMap(ctx)
+	if tm == nil {
+		return nil, errors.New("postgres connection info has not been defined inside the given context")
+	}
+
+	return &BinaryCopyWriter{
+		typeMap: tm,
+		writer:  writer,
+		columns: columns,
+	}, nil
+}
+
+type BinaryCopyWriter struct {
+	typeMap *pgtype.Map
+	writer  io.Writer
+	columns Columns
+	header  bool
+}
+
+// Write encodes the given values using the defined columns and writes the row
+// to the underlying writer. Nil values are encoded as NULL values.
+func (w *BinaryCopyWriter) Write(ctx context.Context, values []any) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("unexpected columns, %d columns are defined inside the given table but %d were given", len(w.columns), len(values))
+	}
+
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	row := binary.BigEndian.AppendUint16(nil, uint16(len(values)))
+	for index, column := range w.columns {
+		value, err := w.typeMap.Encode(column.Oid, int16(BinaryFormat), values[index], make([]byte, 0))
+		if err != nil {
+			return err
+		}
+
+		// NOTE: as a special case, -1 indicates a NULL field value.
+		if value == nil {
+			row = binary.BigEndian.AppendUint32(row, math.MaxUint32)
+			continue
+		}
+
+		row = binary.BigEndian.AppendUint32(row, uint32(len(value)))
+		row = append(row, value...)
+	}
+
+	_, err = w.writer.Write(row)
+	return err
+}
+
+// Close writes the file trailer to the underlying writer. The file header is
+// written first if no rows have been written.
+func (w *BinaryCopyWriter) Close() error {
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the file trailer consists of a 16-bit integer word containing -1.
+	_, err = w.writer.Write([]byte{0xff, 0xff})
+	return err
+}
+
+// writeHeader writes the file header if it has not been written yet. The
+// signature is followed by the flags field and the header extension area
+// length, both are zero.
+func (w *BinaryCopyWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+
+	w.header = true
+
+	header := append(append([]byte(nil), CopySignature...), make([]byte, 8)...)
+	_, err := w.writer.Write(header)
+	return err
+}
+
 // read text data
 
 type TextCopyReader struct {
@@ -205,6 +371,12 @@ type TextCopyReader struct {
 	nullValue  string // PostgreSQL NULL value string (default empty)
 }
 
+// NewTextColumnReader creates a new column reader that reads CSV encoded rows
+// from the given copy reader using the given CSV reader and buffer.
+//
+// Deprecated: the reader does not follow the quoting and escaping rules of
+// the COPY CSV format. Use [NewCSVColumnReader] or [NewTextFormatColumnReader]
+// instead, both are configured using [CopyOptions].
 func NewTextColumnReader(ctx context.Context, copy *CopyReader, csvReader *csv.Reader, csvReaderBuffer *bytes.Buffer, nullValue string) (_ *TextCopyReader, err error) {
 	tm := TypeMap(ctx)
 	if tm == nil {
@@ -304,3 +476,884 @@ func (r *TextCopyReader) preprocessPostgreSQLCSV(data []byte) []byte {
 	result := bytes.ReplaceAll(data, []byte(`\"`), []byte(`""`))
 	return result
 }
+
+// ErrCopyRowFields is returned whenever a row read from a copy-in stream
+// contains an unexpected number of fields.
+var ErrCopyRowFields = errors.New("unexpected number of fields in copy row")
+
+// NewTextFormatColumnReader creates a new column reader that reads rows encoded
+// in the PostgreSQL text format from the given copy reader. Fields are
+// separated by the delimiter, NULL values are represented by the null string
+// and special characters are escaped using backslashes. The values are
+// returned as a slice of any values decoded using the column types. If the
+// end of the copy-in stream, or the \. end marker, is reached an io.EOF error
+// is returned.
+func NewTextFormatColumnReader(ctx context.Context, copy *CopyReader, options CopyOptions) (_ *TextFormatCopyReader, err error) {
+	scanners, err := newTextScanners(ctx, copy.columns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TextFormatCopyReader{
+		stream:   copyStream{reader: copy},
+		scanners: scanners,
+		options:  options.defaults(CopyText),
+	}, nil
+}
+
+type TextFormatCopyReader struct {
+	stream   copyStream
+	scanners []Scanner
+	options  CopyOptions
+	header   bool
+}
+
+// Read reads a single row from the copy-in stream. The read row is returned as a
+// slice of any values. If the end of the copy-in stream is reached, an io.EOF error
+// is returned.
+func (r *TextFormatCopyReader) Read(ctx context.Context) (_ []any, err error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	line, err := r.stream.record(textRecordEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := r.split(line)
+
+	if r.options.Header && !r.header {
+		r.header = true
+		if r.options.HeaderMatch {
+			names := make([]string, len(fields))
+			for index, field := range fields {
+				names[index] = string(unescapeCopyText(field))
+			}
+
+			err = matchCopyHeader(r.stream.reader.columns, names)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return r.Read(ctx)
+	}
+
+	if len(fields) != len(r.scanners) {
+		return nil, fmt.Errorf("%w: %d fields, expected %d", ErrCopyRowFields, len(fields), len(r.scanners))
+	}
+
+	row := make([]any, len(fields))
+	for index, field := range fields {
+		// NOTE: the null string is matched before backslash escapes are
+		// decoded, an escaped null string represents the literal value.
+		if string(field) == r.options.Null {
+			continue
+		}
+
+		row[index], err = r.scanners[index](unescapeCopyText(field))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan field %d: %w", index, err)
+		}
+	}
+
+	return row, nil
+}
+
+// split splits the given line into raw fields separated by unescaped
+// delimiters.
+func (r *TextFormatCopyReader) split(line []byte) [][]byte {
+	fields := make([][]byte, 0, len(r.scanners))
+
+	start := 0
+	for index := 0; index < len(line); index++ {
+		switch line[index] {
+		case '\\':
+			index++
+		case r.options.Delimiter:
+			fields = append(fields, line[start:index])
+			start = index + 1
+		}
+	}
+
+	return append(fields, line[start:])
+}
+
+// NewCSVColumnReader creates a new column reader that reads rows encoded in
+// the PostgreSQL CSV format from the given copy reader. The quote and escape
+// characters, header and the FORCE_NOT_NULL and FORCE_NULL columns defined
+// inside the given options are honored. The values are returned as a slice of
+// any values decoded using the column types. If the end of the copy-in stream,
+// or the \. end marker, is reached an io.EOF error is returned.
+func NewCSVColumnReader(ctx context.Context, copy *CopyReader, options CopyOptions) (_ *CSVCopyReader, err error) {
+	scanners, err := newTextScanners(ctx, copy.columns)
+	if err != nil {
+		return nil, err
+	}
+
+	options = options.defaults(CopyCSV)
+	return &CSVCopyReader{
+		stream:   copyStream{reader: copy},
+		scanners: scanners,
+		options:  options,
+	}, nil
+}
+
+type CSVCopyReader struct {
+	stream   copyStream
+	scanners []Scanner
+	options  CopyOptions
+	header   bool
+}
+
+// Read reads a single row from the copy-in stream. The read row is returned as a
+// slice of any values. If the end of the copy-in stream is reached, an io.EOF error
+// is returned.
+func (r *CSVCopyReader) Read(ctx context.Context) (_ []any, err error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	record, err := r.stream.record(r.recordEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, quoted := r.split(record)
+
+	if r.options.Header && !r.header {
+		r.header = true
+		if r.options.HeaderMatch {
+			names := make([]string, len(fields))
+			for index, field := range fields {
+				names[index] = string(field)
+			}
+
+			err = matchCopyHeader(r.stream.reader.columns, names)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return r.Read(ctx)
+	}
+
+	if len(fields) != len(r.scanners) {
+		return nil, fmt.Errorf("%w: %d fields, expected %d", ErrCopyRowFields, len(fields), len(r.scanners))
+	}
+
+	columns := r.stream.reader.columns
+	row := make([]any, len(fields))
+	for index, field := range fields {
+		// NOTE: unquoted values matching the null string are NULL unless
+		// forced not null, quoted values are only NULL when forced null.
+		null := string(field) == r.options.Null
+		if null && !quoted[index] && !forced(r.options.ForceNotNull, columns[index].Name) {
+			continue
+		}
+
+		if null && quoted[index] && forced(r.options.ForceNull, columns[index].Name) {
+			continue
+		}
+
+		row[index], err = r.scanners[index](field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan field %d: %w", index, err)
+		}
+	}
+
+	return row, nil
+}
+
+// recordEnd returns the index of the newline terminating the first record
+// inside the given data. Newlines inside quoted values are part of the
+// record. A negative index is returned if no complete record is available.
+func (r *CSVCopyReader) recordEnd(data []byte) int {
+	quoted := false
+	for index := 0; index < len(data); index++ {
+		char := data[index]
+		switch {
+		case !quoted && char == '\n':
+			return index
+		case !quoted && char == r.options.Quote:
+			quoted = true
+		case quoted && char == r.options.Escape && r.options.Escape != r.options.Quote:
+			if index+1 == len(data) {
+				return -1
+			}
+
+			if data[index+1] == r.options.Quote || data[index+1] == r.options.Escape {
+				index++
+			}
+		case quoted && char == r.options.Quote:
+			quoted = false
+		}
+	}
+
+	return -1
+}
+
+// split splits the given record into fields separated by unquoted delimiters.
+// Quote and escape characters are removed from the returned fields. Whether a
+// field contained quoted characters is returned for each field.
+func (r *CSVCopyReader) split(record []byte) (fields [][]byte, quoted []bool) {
+	field := []byte{}
+	inside := false
+	wasQuoted := false
+
+	for index := 0; index < len(record); index++ {
+		char := record[index]
+		if inside {
+			switch {
+			case char == r.options.Escape && index+1 < len(record) && (record[index+1] == r.options.Quote || record[index+1] == r.options.Escape):
+				index++
+				field = append(field, record[index])
+			case char == r.options.Quote:
+				inside = false
+			default:
+				field = append(field, char)
+			}
+
+			continue
+		}
+
+		switch char {
+		case r.options.Quote:
+			inside = true
+			wasQuoted = true
+		case r.options.Delimiter:
+			fields = append(fields, field)
+			quoted = append(quoted, wasQuoted)
+			field = []byte{}
+			wasQuoted = false
+		default:
+			field = append(field, char)
+		}
+	}
+
+	return append(fields, field), append(quoted, wasQuoted)
+}
+
+// newTextScanners constructs text format scanners for the given columns.
+func newTextScanners(ctx context.Context, columns Columns) (_ []Scanner, err error) {
+	tm := TypeMap(ctx)
+	if tm == nil {
+		return nil, errors.New("postgres connection info has not been defined inside the given context")
+	}
+
+	scanners := make([]Scanner, len(columns))
+	for index, column := range columns {
+		scanners[index], err = NewScanner(tm, column, TextFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return scanners, nil
+}
+
+// newErrBadCopyFile is returned whenever the data inside a copy-in stream is
+// malformed or its header does not match the defined columns.
+func newErrBadCopyFile(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.BadCopyFileFormat), psqlerr.LevelError)
+}
+
+// matchCopyHeader validates whether the given header names match the names of
+// the given columns.
+func matchCopyHeader(columns Columns, names []string) error {
+	if len(names) != len(columns) {
+		return newErrBadCopyFile("wrong number of fields in header line: got %d, expected %d", len(names), len(columns))
+	}
+
+	for index, column := range columns {
+		if names[index] != column.Name {
+			return newErrBadCopyFile("column name mismatch in header line field %d: got %q, expected %q", index+1, names[index], column.Name)
+		}
+	}
+
+	return nil
+}
+
+// textRecordEnd returns the index of the first newline inside the given data.
+// Newlines inside text format values are always escaped.
+func textRecordEnd(data []byte) int {
+	return bytes.IndexByte(data, '\n')
+}
+
+// copyStream reads newline terminated records from a copy-in stream. Data
+// chunks are buffered until a complete record is available.
+type copyStream struct {
+	reader  *CopyReader
+	pending []byte
+	eof     bool
+}
+
+// record returns the next record from the copy-in stream without the line
+// ending. The given function returns the index of the newline terminating the
+// first record inside the buffered data.
+func (s *copyStream) record(end func(data []byte) int) ([]byte, error) {
+	for {
+		if s.eof {
+			return nil, io.EOF
+		}
+
+		index := end(s.pending)
+		if index >= 0 {
+			line := s.pending[:index]
+			s.pending = s.pending[index+1:]
+			line = bytes.TrimSuffix(line, []byte{'\r'})
+
+			if string(line) == `\.` {
+				return nil, s.drain()
+			}
+
+			return line, nil
+		}
+
+		err := s.reader.Read()
+		if err == io.EOF && len(s.pending) > 0 {
+			// NOTE: the last line is not required to be terminated by a newline.
+			s.eof = true
+			line := bytes.TrimSuffix(s.pending, []byte{'\r'})
+			s.pending = nil
+
+			if string(line) == `\.` {
+				return nil, io.EOF
+			}
+
+			return line, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.pending = append(s.pending, s.reader.Msg...)
+		s.reader.Msg = s.reader.Msg[:0]
+	}
+}
+
+// next returns the next n bytes from the copy-in stream. Data chunks are read
+// from the copy-in stream until n bytes are buffered. An io.EOF error is
+// returned if the copy-in stream ends without buffered data.
+func (s *copyStream) next(n int) ([]byte, error) {
+	for len(s.pending) < n {
+		err := io.EOF
+		if !s.eof {
+			err = s.reader.Read()
+		}
+
+		if err == io.EOF {
+			s.eof = true
+			if len(s.pending) == 0 {
+				return nil, io.EOF
+			}
+
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.pending = append(s.pending, s.reader.Msg...)
+		s.reader.Msg = s.reader.Msg[:0]
+	}
+
+	data := s.pending[:n]
+	s.pending = s.pending[n:]
+	return data, nil
+}
+
+// drain discards all remaining data inside the copy-in stream once the end
+// marker has been read.
+func (s *copyStream) drain() error {
+	s.eof = true
+	s.pending = nil
+
+	for {
+		err := s.reader.Read()
+		if err != nil {
+			return err
+		}
+
+		s.reader.Msg = s.reader.Msg[:0]
+	}
+}
+
+// unescapeCopyText decodes the backslash escape sequences within the given
+// text format field.
+// https://www.postgresql.org/docs/current/sql-copy.html#id-1.9.3.55.9.2
+func unescapeCopyText(field []byte) []byte {
+	if bytes.IndexByte(field, '\\') < 0 {
+		return field
+	}
+
+	result := make([]byte, 0, len(field))
+	for index := 0; index < len(field); index++ {
+		char := field[index]
+		if char != '\\' || index+1 == len(field) {
+			result = append(result, char)
+			continue
+		}
+
+		index++
+		char = field[index]
+
+		switch char {
+		case 'b':
+			result = append(result, '\b')
+		case 'f':
+			result = append(result, '\f')
+		case 'n':
+			result = append(result, '\n')
+		case 'r':
+			result = append(result, '\r')
+		case 't':
+			result = append(result, '\t')
+		case 'v':
+			result = append(result, '\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// NOTE: backslash followed by one to three octal digits.
+			value := char - '0'
+			for digits := 1; digits < 3 && index+1 < len(field) && field[index+1] >= '0' && field[index+1] <= '7'; digits++ {
+				index++
+				value = value<<3 + field[index] - '0'
+			}
+
+			result = append(result, value)
+		case 'x':
+			// NOTE: backslash x followed by one or two hex digits. A
+			// backslash x without hex digits represents the character x.
+			var value byte
+			digits := 0
+			for ; digits < 2 && index+1 < len(field); digits++ {
+				digit, ok := hexDigit(field[index+1])
+				if !ok {
+					break
+				}
+
+				index++
+				value = value<<4 | digit
+			}
+
+			if digits == 0 {
+				result = append(result, char)
+				continue
+			}
+
+			result = append(result, value)
+		default:
+			// NOTE: any other backslashed character represents itself.
+			result = append(result, char)
+		}
+	}
+
+	return result
+}
+
+// hexDigit returns the value of the given hexadecimal digit.
+func hexDigit(char byte) (byte, bool) {
+	switch {
+	case char >= '0' && char <= '9':
+		return char - '0', true
+	case char >= 'a' && char <= 'f':
+		return char - 'a' + 10, true
+	case char >= 'A' && char <= 'F':
+		return char - 'A' + 10, true
+	}
+
+	return 0, false
+}
+
+// NewCopyWriter creates a new copy writer that writes copy-out data to the
+// given writer. The columns are used to encode the rows written to the copy
+// writer using the given format and options. The format defined inside the
+// given options is replaced by the given format.
+func NewCopyWriter(ctx context.Context, writer *buffer.Writer, columns Columns, format CopyFormat, options CopyOptions) (_ *CopyWriter, err error) {
+	tm := TypeMap(ctx)
+	if tm == nil {
+		return nil, errors.New("postgres connection info has not been defined inside the given context")
+	}
+
+	options.Format = format
+
+	copy := &CopyWriter{
+		ctx:     ctx,
+		typeMap: tm,
+		writer:  writer,
+		columns: columns,
+		format:  format,
+		options: options.defaults(format),
+		counter: copyRowCounter{format: format},
+		complete: func(description string) error {
+			return commandComplete(writer, description)
+		},
+	}
+
+	copy.counter.header = copy.options.Header && format != CopyBinary
+	copy.counter.quote = copy.options.Quote
+	copy.counter.escape = copy.options.Escape
+
+	if format == CopyBinary {
+		copy.binary, err = NewBinaryColumnWriter(ctx, copyDataWriter{copy: copy}, columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return copy, nil
+}
+
+// CopyWriter writes rows or raw chunks as CopyData messages to the client.
+type CopyWriter struct {
+	ctx      context.Context
+	typeMap  *pgtype.Map
+	writer   *buffer.Writer
+	columns  Columns
+	format   CopyFormat
+	options  CopyOptions
+	binary   *BinaryCopyWriter
+	started  bool
+	raw      bool
+	written  uint32
+	counter  copyRowCounter
+	complete func(description string) error
+}
+
+// copyDataWriter writes chunks as CopyData messages to the client without
+// marking the copy writer as written using raw chunks.
+type copyDataWriter struct {
+	copy *CopyWriter
+}
+
+func (w copyDataWriter) Write(chunk []byte) (int, error) {
+	err := w.copy.data(chunk)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(chunk), nil
+}
+
+// Columns returns the columns that are currently defined within the copy writer.
+func (w *CopyWriter) Columns() Columns {
+	return w.columns
+}
+
+// Written returns the number of rows written to the client, including the
+// rows written inside raw chunks.
+func (w *CopyWriter) Written() uint32 {
+	return w.written + w.counter.count()
+}
+
+// Write writes the given raw chunk as a single CopyData message to the client.
+// The chunk is expected to be encoded in the format of the copy operation.
+// The rows inside the written chunks are counted as written rows, rows are
+// allowed to span multiple chunks.
+func (w *CopyWriter) Write(chunk []byte) (int, error) {
+	if w.ctx.Err() != nil {
+		return 0, w.ctx.Err()
+	}
+
+	if w.started {
+		// NOTE: the header line has already been written by the copy writer.
+		w.counter.header = false
+	}
+
+	w.raw = true
+	err := w.data(chunk)
+	if err != nil {
+		return 0, err
+	}
+
+	w.counter.write(chunk)
+	return len(chunk), nil
+}
+
+// Row encodes the given values using the defined columns and writes the row as
+// a single CopyData message to the client. Nil values are encoded as NULL
+// values.
+func (w *CopyWriter) Row(values []any) error {
+	if w.ctx.Err() != nil {
+		return w.ctx.Err()
+	}
+
+	if w.binary != nil {
+		err := w.binary.Write(w.ctx, values)
+		if err != nil {
+			return err
+		}
+
+		w.written++
+		return nil
+	}
+
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("unexpected columns, %d columns are defined inside the given table but %d were given", len(w.columns), len(values))
+	}
+
+	err := w.start()
+	if err != nil {
+		return err
+	}
+
+	var row []byte
+	for index, column := range w.columns {
+		value, err := w.typeMap.Encode(column.Oid, int16(TextFormat), values[index], make([]byte, 0))
+		if err != nil {
+			return err
+		}
+
+		if index > 0 {
+			row = append(row, w.options.Delimiter)
+		}
+
+		if value == nil {
+			row = append(row, w.options.Null...)
+			continue
+		}
+
+		row = w.appendField(row, value, forced(w.options.ForceQuote, column.Name))
+	}
+
+	err = w.data(append(row, '\n'))
+	if err != nil {
+		return err
+	}
+
+	w.written++
+	return nil
+}
+
+// Complete announces to the client that all copy data has been written. The
+// copy operation is completed using a COPY command tag containing the number
+// of written rows.
+func (w *CopyWriter) Complete() error {
+	var err error
+	switch {
+	case w.binary != nil && (!w.raw || w.written > 0):
+		err = w.binary.Close()
+	case !w.raw:
+		err = w.start()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	w.writer.Start(types.ServerCopyDone)
+	err = w.writer.End()
+	if err != nil {
+		return err
+	}
+
+	return w.complete(fmt.Sprintf("COPY %d", w.Written()))
+}
+
+// copyRowStage represents the part of the binary copy stream which is
+// expected next by the copy row counter.
+type copyRowStage int
+
+const (
+	copyRowHeader copyRowStage = iota
+	copyRowTuple
+	copyRowField
+	copyRowTrailer
+)
+
+// copyRowCounter counts the rows inside the raw chunks written to a copy
+// writer. Text rows are terminated by a newline, CSV rows by a newline outside
+// a quoted value and binary rows are counted by decoding the tuple headers.
+// Rows are allowed to span multiple chunks.
+type copyRowCounter struct {
+	format  CopyFormat
+	quote   byte
+	escape  byte
+	header  bool
+	rows    uint32
+	partial bool
+	quoted  bool
+	escaped bool
+	stage   copyRowStage
+	pending []byte
+	skip    int
+	fields  int
+}
+
+// count returns the number of rows counted so far. Text and CSV rows which
+// have not been terminated by a newline are included.
+func (counter *copyRowCounter) count() uint32 {
+	if counter.partial && !counter.header {
+		return counter.rows + 1
+	}
+
+	return counter.rows
+}
+
+// write counts the rows inside the given chunk.
+func (counter *copyRowCounter) write(chunk []byte) {
+	if counter.format == CopyBinary {
+		counter.binary(chunk)
+		return
+	}
+
+	for _, char := range chunk {
+		switch {
+		case counter.escaped:
+			counter.escaped = false
+		case counter.quoted && char == counter.escape && counter.escape != counter.quote:
+			counter.escaped = true
+		case counter.format == CopyCSV && char == counter.quote:
+			counter.quoted = !counter.quoted
+		case char == '\n' && !counter.quoted:
+			counter.partial = false
+			if counter.header {
+				counter.header = false
+				continue
+			}
+
+			counter.rows++
+			continue
+		}
+
+		counter.partial = true
+	}
+}
+
+// binary counts the tuples inside the given binary copy chunk. The signature,
+// flags and header extension are skipped, decoding stops at the trailer.
+func (counter *copyRowCounter) binary(chunk []byte) {
+	for len(chunk) > 0 && counter.stage != copyRowTrailer {
+		if counter.skip > 0 {
+			length := min(counter.skip, len(chunk))
+			counter.skip -= length
+			chunk = chunk[length:]
+			continue
+		}
+
+		size := 4
+		switch counter.stage {
+		case copyRowHeader:
+			size = len(CopySignature) + 8
+		case copyRowTuple:
+			size = 2
+		}
+
+		length := min(size-len(counter.pending), len(chunk))
+		counter.pending = append(counter.pending, chunk[:length]...)
+		chunk = chunk[length:]
+		if len(counter.pending) < size {
+			return
+		}
+
+		switch counter.stage {
+		case copyRowHeader:
+			counter.skip = int(binary.BigEndian.Uint32(counter.pending[size-4:]))
+			counter.stage = copyRowTuple
+		case copyRowTuple:
+			fields := int16(binary.BigEndian.Uint16(counter.pending))
+			if fields < 0 {
+				counter.stage = copyRowTrailer
+				break
+			}
+
+			counter.rows++
+			counter.fields = int(fields)
+			if counter.fields > 0 {
+				counter.stage = copyRowField
+			}
+		case copyRowField:
+			counter.skip = max(int(int32(binary.BigEndian.Uint32(counter.pending))), 0)
+			counter.fields--
+			if counter.fields == 0 {
+				counter.stage = copyRowTuple
+			}
+		}
+
+		counter.pending = counter.pending[:0]
+	}
+}
+
+// start writes the header line containing the column names, if requested,
+// before the first row is written.
+func (w *CopyWriter) start() error {
+	if w.started {
+		return nil
+	}
+
+	w.started = true
+	if !w.options.Header {
+		return nil
+	}
+
+	var line []byte
+	for index, column := range w.columns {
+		if index > 0 {
+			line = append(line, w.options.Delimiter)
+		}
+
+		line = w.appendField(line, []byte(column.Name), false)
+	}
+
+	return w.data(append(line, '\n'))
+}
+
+// appendField appends the given non-NULL text value to the given line. The
+// value is escaped according to the text or CSV copy format. CSV values are
+// quoted when required or when forced.
+func (w *CopyWriter) appendField(line []byte, value []byte, force bool) []byte {
+	if w.format == CopyCSV {
+		quote := force || string(value) == w.options.Null || string(value) == `\.` ||
+			bytes.ContainsAny(value, string([]byte{w.options.Delimiter, w.options.Quote, '\r', '\n'}))
+
+		if !quote {
+			return append(line, value...)
+		}
+
+		line = append(line, w.options.Quote)
+		for _, char := range value {
+			if char == w.options.Quote || char == w.options.Escape {
+				line = append(line, w.options.Escape)
+			}
+
+			line = append(line, char)
+		}
+
+		return append(line, w.options.Quote)
+	}
+
+	for _, char := range value {
+		switch char {
+		case '\\':
+			line = append(line, '\\', '\\')
+		case '\b':
+			line = append(line, '\\', 'b')
+		case '\f':
+			line = append(line, '\\', 'f')
+		case '\n':
+			line = append(line, '\\', 'n')
+		case '\r':
+			line = append(line, '\\', 'r')
+		case '\t':
+			line = append(line, '\\', 't')
+		case '\v':
+			line = append(line, '\\', 'v')
+		case w.options.Delimiter:
+			line = append(line, '\\', char)
+		default:
+			line = append(line, char)
+		}
+	}
+
+	return line
+}
+
+// data writes the given chunk as a CopyData message to the client.
+func (w *CopyWriter) data(chunk []byte) error {
+	w.writer.Start(types.ServerCopyData)
+	w.writer.AddBytes(chunk)
+	return w.writer.End()
+}
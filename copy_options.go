@@ -0,0 +1,548 @@
+package wire
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// newErrCopySyntax is returned whenever the options of a COPY statement could
+// not be parsed or are conflicting.
+func newErrCopySyntax(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.Syntax), psqlerr.LevelError)
+}
+
+// newErrCopyUnsupported is returned whenever a COPY option is used in a copy
+// format which does not support the given option.
+func newErrCopyUnsupported(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.FeatureNotSupported), psqlerr.LevelError)
+}
+
+// newErrCopyParameter is returned whenever a COPY option contains an invalid
+// value.
+func newErrCopyParameter(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.InvalidParameterValue), psqlerr.LevelError)
+}
+
+// CopyAllColumns could be used inside the FORCE_QUOTE, FORCE_NOT_NULL and
+// FORCE_NULL column lists to apply the option to all columns.
+const CopyAllColumns = "*"
+
+// CopyOptions represents the options of a COPY operation. Zero values are
+// replaced by the defaults of the used copy format. Options could be parsed
+// from a COPY statement using [ParseCopyOptions].
+type CopyOptions struct {
+	// Format is the data format of the COPY operation.
+	Format CopyFormat
+	// Delimiter separates the columns within a row. Defaults to a tab
+	// character in text format and a comma in CSV format.
+	Delimiter byte
+	// Null is the string representing a NULL value. Defaults to \N in text
+	// format and an unquoted empty string in CSV format.
+	Null string
+	// NullSet marks the null string as explicitly defined, allowing an empty
+	// null string to be used in text format.
+	NullSet bool
+	// Header writes a line containing the column names before the rows. The
+	// first line is skipped when reading rows.
+	Header bool
+	// HeaderMatch validates whether the column names inside the header line
+	// match the defined columns when reading rows.
+	HeaderMatch bool
+	// Quote is the quoting character used in CSV format. Defaults to a double
+	// quote.
+	Quote byte
+	// Escape is the character escaping quote characters in CSV format.
+	// Defaults to the quote character.
+	Escape byte
+	// ForceQuote lists the columns which are always quoted in CSV format when
+	// not NULL.
+	ForceQuote []string
+	// ForceNotNull lists the columns whose values are never matched against
+	// the null string in CSV format.
+	ForceNotNull []string
+	// ForceNull lists the columns whose quoted values are matched against the
+	// null string in CSV format.
+	ForceNull []string
+	// Freeze requests the copied rows to be frozen.
+	Freeze bool
+	// Encoding is the encoding of the copied data.
+	Encoding string
+}
+
+// defaults returns the options with all unset values replaced by the defaults
+// of the given copy format.
+func (options CopyOptions) defaults(format CopyFormat) CopyOptions {
+	if options.Delimiter == 0 {
+		options.Delimiter = '\t'
+		if format == CopyCSV {
+			options.Delimiter = ','
+		}
+	}
+
+	if options.Null == "" && !options.NullSet && format == CopyText {
+		options.Null = `\N`
+	}
+
+	if options.Quote == 0 {
+		options.Quote = '"'
+	}
+
+	if options.Escape == 0 {
+		options.Escape = options.Quote
+	}
+
+	return options
+}
+
+// forced returns whether the given column is included inside the given
+// column list.
+func forced(columns []string, column string) bool {
+	return slices.Contains(columns, CopyAllColumns) || slices.Contains(columns, column)
+}
+
+// ParseCopyOptions parses the options of the given COPY statement. Both the
+// WITH (option value, ...) syntax and the legacy syntax, such as
+// WITH DELIMITER ',' CSV HEADER, are supported. The given statement could be
+// a complete COPY statement or only its options clause.
+// https://www.postgresql.org/docs/current/sql-copy.html
+func ParseCopyOptions(statement string) (CopyOptions, error) {
+	tokens, err := tokenizeStatement(statement)
+	if err != nil {
+		return CopyOptions{}, err
+	}
+
+	tokens = copyOptionTokens(tokens)
+	parser := &copyOptionParser{
+		tokens: tokens,
+		seen:   make(map[string]bool),
+	}
+
+	if parser.keyword("with") {
+		parser.next()
+	}
+
+	if parser.punct("(") {
+		err = parser.parseList()
+	} else {
+		err = parser.parseLegacy()
+	}
+
+	if err != nil {
+		return CopyOptions{}, err
+	}
+
+	err = parser.options.validate()
+	if err != nil {
+		return CopyOptions{}, err
+	}
+
+	return parser.options, nil
+}
+
+// validate checks whether the given options could be used together.
+func (options CopyOptions) validate() error {
+	if options.Format == CopyBinary {
+		switch {
+		case options.Delimiter != 0:
+			return newErrCopySyntax("cannot specify DELIMITER in BINARY mode")
+		case options.NullSet:
+			return newErrCopySyntax("cannot specify NULL in BINARY mode")
+		case options.Header:
+			return newErrCopySyntax("cannot specify HEADER in BINARY mode")
+		}
+	}
+
+	if options.Format != CopyCSV {
+		switch {
+		case options.Quote != 0:
+			return newErrCopyUnsupported("COPY QUOTE requires CSV mode")
+		case options.Escape != 0:
+			return newErrCopyUnsupported("COPY ESCAPE requires CSV mode")
+		case len(options.ForceQuote) > 0:
+			return newErrCopyUnsupported("COPY FORCE_QUOTE requires CSV mode")
+		case len(options.ForceNotNull) > 0:
+			return newErrCopyUnsupported("COPY FORCE_NOT_NULL requires CSV mode")
+		case len(options.ForceNull) > 0:
+			return newErrCopyUnsupported("COPY FORCE_NULL requires CSV mode")
+		}
+	}
+
+	resolved := options.defaults(options.Format)
+	if resolved.Delimiter == '\n' || resolved.Delimiter == '\r' {
+		return newErrCopyParameter("COPY delimiter cannot be newline or carriage return")
+	}
+
+	if strings.ContainsAny(resolved.Null, "\r\n") {
+		return newErrCopyParameter("COPY null representation cannot use newline or carriage return")
+	}
+
+	if options.Format != CopyBinary && strings.IndexByte(resolved.Null, resolved.Delimiter) >= 0 {
+		return newErrCopyParameter("COPY delimiter character must not appear in the NULL specification")
+	}
+
+	if options.Format == CopyCSV && resolved.Delimiter == resolved.Quote {
+		return newErrCopyParameter("COPY delimiter and quote must be different")
+	}
+
+	if options.Format == CopyCSV && strings.IndexByte(resolved.Null, resolved.Quote) >= 0 {
+		return newErrCopyUnsupported("CSV quote character must not appear in the NULL specification")
+	}
+
+	return nil
+}
+
+// copyOptionTokens returns the tokens containing the options of the given
+// COPY statement. Tokens which do not start with the COPY keyword are
+// expected to only contain the options.
+func copyOptionTokens(tokens []statementToken) []statementToken {
+	if len(tokens) == 0 || tokens[0].literal || tokens[0].value != "copy" {
+		return tokens
+	}
+
+	depth := 0
+	for index, token := range tokens {
+		switch {
+		case token.punct && token.value == "(":
+			depth++
+		case token.punct && token.value == ")":
+			depth--
+		case depth == 0 && !token.literal && (token.value == "from" || token.value == "to"):
+			// NOTE: the keyword is followed by the source or destination
+			// which is optionally prefixed by the PROGRAM keyword.
+			index++
+			if index < len(tokens) && !tokens[index].literal && tokens[index].value == "program" {
+				index++
+			}
+
+			options := tokens[min(index+1, len(tokens)):]
+			for end, token := range options {
+				if !token.literal && !token.punct && token.value == "where" {
+					return options[:end]
+				}
+			}
+
+			return options
+		}
+	}
+
+	return nil
+}
+
+// copyOptionParser parses the options of a COPY statement.
+type copyOptionParser struct {
+	tokens  []statementToken
+	options CopyOptions
+	seen    map[string]bool
+}
+
+func (parser *copyOptionParser) done() bool {
+	return len(parser.tokens) == 0
+}
+
+func (parser *copyOptionParser) peek() statementToken {
+	if parser.done() {
+		return statementToken{}
+	}
+
+	return parser.tokens[0]
+}
+
+func (parser *copyOptionParser) next() statementToken {
+	token := parser.peek()
+	if !parser.done() {
+		parser.tokens = parser.tokens[1:]
+	}
+
+	return token
+}
+
+// keyword returns whether the next token is the given keyword.
+func (parser *copyOptionParser) keyword(keyword string) bool {
+	token := parser.peek()
+	return !parser.done() && !token.literal && !token.punct && token.value == keyword
+}
+
+// punct returns whether the next token is the given punctuation.
+func (parser *copyOptionParser) punct(punct string) bool {
+	token := parser.peek()
+	return token.punct && token.value == punct
+}
+
+// parseList parses the options enclosed in parentheses.
+func (parser *copyOptionParser) parseList() error {
+	parser.next()
+
+	for {
+		if parser.done() {
+			return newErrCopySyntax("unexpected end of COPY options")
+		}
+
+		name := parser.next()
+		if name.literal || name.punct {
+			return newErrCopySyntax("syntax error at or near %q", name.value)
+		}
+
+		var value []statementToken
+		depth := 0
+		for !parser.done() {
+			if depth == 0 && (parser.punct(",") || parser.punct(")")) {
+				break
+			}
+
+			token := parser.next()
+			switch {
+			case token.punct && token.value == "(":
+				depth++
+			case token.punct && token.value == ")":
+				depth--
+			}
+
+			value = append(value, token)
+		}
+
+		err := parser.apply(name.value, value)
+		if err != nil {
+			return err
+		}
+
+		token := parser.next()
+		switch {
+		case token.punct && token.value == ")":
+			if !parser.done() {
+				return newErrCopySyntax("syntax error at or near %q", parser.peek().value)
+			}
+
+			return nil
+		case token.punct && token.value == ",":
+			continue
+		default:
+			return newErrCopySyntax("unexpected end of COPY options")
+		}
+	}
+}
+
+// parseLegacy parses the options defined using the legacy syntax.
+func (parser *copyOptionParser) parseLegacy() error {
+	for !parser.done() {
+		token := parser.next()
+		if token.literal || token.punct {
+			return newErrCopySyntax("syntax error at or near %q", token.value)
+		}
+
+		var err error
+		switch token.value {
+		case "binary":
+			err = parser.apply("format", []statementToken{{value: "binary"}})
+		case "csv":
+			err = parser.apply("format", []statementToken{{value: "csv"}})
+		case "header":
+			err = parser.apply("header", nil)
+		case "delimiter", "null", "quote", "escape":
+			if parser.keyword("as") {
+				parser.next()
+			}
+
+			err = parser.apply(token.value, []statementToken{parser.next()})
+		case "force":
+			name := "force_null"
+			switch {
+			case parser.keyword("quote"):
+				name = "force_quote"
+				parser.next()
+			case parser.keyword("not"):
+				name = "force_not_null"
+				parser.next()
+				if !parser.keyword("null") {
+					return newErrCopySyntax("syntax error at or near %q", parser.peek().value)
+				}
+
+				parser.next()
+			case parser.keyword("null"):
+				parser.next()
+			default:
+				return newErrCopySyntax("syntax error at or near %q", parser.peek().value)
+			}
+
+			err = parser.apply(name, parser.legacyColumns())
+		default:
+			return newErrCopySyntax("option %q not recognized", token.value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyColumns returns the tokens of the comma separated column list
+// following a legacy FORCE option.
+func (parser *copyOptionParser) legacyColumns() []statementToken {
+	if parser.punct("*") {
+		return []statementToken{parser.next()}
+	}
+
+	var columns []statementToken
+	for !parser.done() {
+		columns = append(columns, parser.next())
+		if !parser.punct(",") {
+			break
+		}
+
+		columns = append(columns, parser.next())
+	}
+
+	// NOTE: the columns are wrapped in parentheses to share the column list
+	// parsing with the options enclosed in parentheses.
+	columns = append([]statementToken{{value: "(", punct: true}}, columns...)
+	return append(columns, statementToken{value: ")", punct: true})
+}
+
+// apply applies the given option and value to the parsed options.
+func (parser *copyOptionParser) apply(name string, value []statementToken) (err error) {
+	if parser.seen[name] {
+		return newErrCopySyntax("conflicting or redundant options")
+	}
+
+	parser.seen[name] = true
+	options := &parser.options
+
+	switch name {
+	case "format":
+		format, err := copyString(name, value)
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(format) {
+		case "text":
+			options.Format = CopyText
+		case "csv":
+			options.Format = CopyCSV
+		case "binary":
+			options.Format = CopyBinary
+		default:
+			return newErrCopyParameter("COPY format %q not recognized", format)
+		}
+	case "freeze":
+		options.Freeze, err = copyBool(name, value)
+	case "delimiter":
+		options.Delimiter, err = copyChar(name, value)
+	case "null":
+		options.Null, err = copyString(name, value)
+		options.NullSet = true
+	case "header":
+		if len(value) == 1 && !value[0].literal && value[0].value == "match" {
+			options.Header = true
+			options.HeaderMatch = true
+			return nil
+		}
+
+		options.Header, err = copyBool(name, value)
+	case "quote":
+		options.Quote, err = copyChar(name, value)
+	case "escape":
+		options.Escape, err = copyChar(name, value)
+	case "force_quote":
+		options.ForceQuote, err = copyColumns(name, value)
+	case "force_not_null":
+		options.ForceNotNull, err = copyColumns(name, value)
+	case "force_null":
+		options.ForceNull, err = copyColumns(name, value)
+	case "encoding":
+		options.Encoding, err = copyString(name, value)
+	case "oids":
+		return newErrCopyUnsupported("COPY OIDS is not supported")
+	default:
+		return newErrCopySyntax("option %q not recognized", name)
+	}
+
+	return err
+}
+
+// copyString returns the single string value of the given option.
+func copyString(name string, value []statementToken) (string, error) {
+	if len(value) != 1 || value[0].punct {
+		return "", newErrCopySyntax("%s requires a single value", name)
+	}
+
+	return value[0].value, nil
+}
+
+// copyChar returns the single one-byte character value of the given option.
+func copyChar(name string, value []statementToken) (byte, error) {
+	str, err := copyString(name, value)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(str) != 1 {
+		return 0, newErrCopyUnsupported("COPY %s must be a single one-byte character", name)
+	}
+
+	return str[0], nil
+}
+
+// copyBool returns the boolean value of the given option. Options without a
+// value are enabled.
+func copyBool(name string, value []statementToken) (bool, error) {
+	if len(value) == 0 {
+		return true, nil
+	}
+
+	str, err := copyString(name, value)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(str) {
+	case "true", "on", "1", "yes":
+		return true, nil
+	case "false", "off", "0", "no":
+		return false, nil
+	}
+
+	return false, newErrCopyParameter("%s requires a Boolean value", name)
+}
+
+// copyColumns returns the columns listed inside the value of the given option.
+func copyColumns(name string, value []statementToken) ([]string, error) {
+	if len(value) == 1 && value[0].punct && value[0].value == CopyAllColumns {
+		return []string{CopyAllColumns}, nil
+	}
+
+	if len(value) < 3 || !value[0].punct || value[0].value != "(" || !value[len(value)-1].punct || value[len(value)-1].value != ")" {
+		return nil, newErrCopySyntax("%s requires a column list", name)
+	}
+
+	var columns []string
+	for index, token := range value[1 : len(value)-1] {
+		if index%2 == 1 {
+			if !token.punct || token.value != "," {
+				return nil, newErrCopySyntax("syntax error at or near %q", token.value)
+			}
+
+			continue
+		}
+
+		if token.punct || token.literal {
+			return nil, newErrCopySyntax("syntax error at or near %q", token.value)
+		}
+
+		columns = append(columns, token.value)
+	}
+
+	if len(value)%2 == 0 {
+		return nil, newErrCopySyntax("%s requires a column list", name)
+	}
+
+	return columns, nil
+}
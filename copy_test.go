@@ -8,11 +8,18 @@ import (
 	"io"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCopyReaderText(t *testing.T) {
@@ -228,3 +235,524 @@ func TestCopyReaderTextNullAndEscape(t *testing.T) {
 		}
 	})
 }
+
+func TestCopyWriter(t *testing.T) {
+	table := Columns{
+		{
+			Table: 0,
+			Name:  "id",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+		{
+			Table: 0,
+			Name:  "name",
+			Oid:   pgtype.TextOID,
+			Width: 256,
+		},
+	}
+
+	rows := [][]any{
+		{1, "Luke Skywalker"},
+		{2, "Obi-Wan\t\"Ben\" Kenobi"},
+		{3, nil},
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		options, err := ParseCopyOptions(query)
+		if err != nil {
+			return nil, err
+		}
+
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			copy, err := writer.(CopyOutWriter).CopyOut(options.Format, options)
+			if err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				err = copy.Row(row)
+				if err != nil {
+					return err
+				}
+			}
+
+			return copy.Complete()
+		}
+
+		return Prepared(NewStatement(handle, WithColumns(table))), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	copyTo := func(query string) []byte {
+		var output bytes.Buffer
+		tag, err := conn.PgConn().CopyTo(ctx, &output, query)
+		require.NoError(t, err)
+		require.Equal(t, "COPY 3", tag.String())
+		return output.Bytes()
+	}
+
+	output := copyTo(`COPY "jedis" TO STDOUT`)
+	require.Equal(t, "1\tLuke Skywalker\n2\tObi-Wan\\t\"Ben\" Kenobi\n3\t\\N\n", string(output))
+
+	output = copyTo(`COPY "jedis" TO STDOUT WITH (FORMAT CSV, HEADER)`)
+	require.Equal(t, "id,name\n1,Luke Skywalker\n2,\"Obi-Wan\t\"\"Ben\"\" Kenobi\"\n3,\n", string(output))
+
+	output = copyTo(`COPY "jedis" TO STDOUT WITH (FORMAT CSV, FORCE_QUOTE (name), DELIMITER ';')`)
+	require.Equal(t, "1;\"Luke Skywalker\"\n2;\"Obi-Wan\t\"\"Ben\"\" Kenobi\"\n3;\n", string(output))
+
+	output = copyTo(`COPY "jedis" TO STDOUT WITH (FORMAT BINARY)`)
+	require.True(t, bytes.HasPrefix(output, CopySignature))
+	require.True(t, bytes.HasSuffix(output, []byte{0xff, 0xff}))
+
+	expected := []byte{0, 2, 0, 0, 0, 4, 0, 0, 0, 1, 0, 0, 0, 14}
+	expected = append(expected, "Luke Skywalker"...)
+	require.Equal(t, expected, output[len(CopySignature)+8:len(CopySignature)+8+len(expected)])
+}
+
+func TestCopyWriterRawRows(t *testing.T) {
+	t.Parallel()
+
+	table := Columns{
+		{Name: "id", Oid: pgtype.Int4OID},
+		{Name: "name", Oid: pgtype.TextOID},
+	}
+
+	var binary bytes.Buffer
+	ctx := setTypeInfo(context.Background(), pgtype.NewMap())
+	encoder, err := NewBinaryColumnWriter(ctx, &binary, table)
+	require.NoError(t, err)
+	require.NoError(t, encoder.Write(ctx, []any{1, "Luke Skywalker"}))
+	require.NoError(t, encoder.Write(ctx, []any{2, nil}))
+	require.NoError(t, encoder.Close())
+
+	extension := append(append([]byte{}, CopySignature...), 0, 0, 0, 0, 0, 0, 0, 2, 'e', 'x')
+	extension = append(extension, binary.Bytes()[len(CopySignature)+8:]...)
+
+	tests := map[string]struct {
+		format  CopyFormat
+		options CopyOptions
+		data    string
+		rows    uint32
+	}{
+		"text":               {format: CopyText, data: "1\tLuke\n2\t\\N\n", rows: 2},
+		"text unterminated":  {format: CopyText, data: "1\tLuke\n2\tObi-Wan", rows: 2},
+		"text header":        {format: CopyText, options: CopyOptions{Header: true}, data: "id\tname\n1\tLuke\n", rows: 1},
+		"csv quoted newline": {format: CopyCSV, data: "1,\"Luke\nSkywalker\"\n2,\"\"\"\"\n", rows: 2},
+		"csv escape":         {format: CopyCSV, options: CopyOptions{Escape: '\\'}, data: "1,\"\\\"\n\"\n", rows: 1},
+		"csv header":         {format: CopyCSV, options: CopyOptions{Header: true}, data: "id,name\n1,Luke\n", rows: 1},
+		"binary":             {format: CopyBinary, data: binary.String(), rows: 2},
+		"binary extension":   {format: CopyBinary, data: string(extension), rows: 2},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, size := range []int{1, len(test.data)} {
+				writer, err := NewCopyWriter(ctx, buffer.NewWriter(slogt.New(t), io.Discard), table, test.format, test.options)
+				require.NoError(t, err)
+
+				for chunk := range slices.Chunk([]byte(test.data), size) {
+					_, err = writer.Write(chunk)
+					require.NoError(t, err)
+				}
+
+				require.Equal(t, test.rows, writer.Written())
+			}
+		})
+	}
+}
+
+func TestTextFormatCopyReader(t *testing.T) {
+	table := Columns{
+		{
+			Table: 0,
+			Name:  "id",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+		{
+			Table: 0,
+			Name:  "name",
+			Oid:   pgtype.TextOID,
+			Width: 256,
+		},
+	}
+
+	var rows [][]any
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			copy, err := writer.CopyIn(TextFormat)
+			if err != nil {
+				return err
+			}
+
+			reader, err := NewTextFormatColumnReader(ctx, copy, CopyOptions{})
+			if err != nil {
+				return err
+			}
+
+			for {
+				row, err := reader.Read(ctx)
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					return err
+				}
+
+				rows = append(rows, row)
+			}
+
+			return writer.Complete(fmt.Sprintf("COPY %d", len(rows)))
+		}
+
+		return Prepared(NewStatement(handle, WithColumns(table))), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	data := "1\tLuke\\tSkywalker\n" +
+		"2\t\\N\n" +
+		"3\t\\\\N\r\n" +
+		"4\tObi\\x2dWan\\040Kenobi\\n\n" +
+		"\\.\n"
+
+	tag, err := conn.PgConn().CopyFrom(ctx, strings.NewReader(data), `COPY "jedis" FROM STDIN`)
+	require.NoError(t, err)
+	require.Equal(t, "COPY 4", tag.String())
+
+	expected := [][]any{
+		{int32(1), "Luke\tSkywalker"},
+		{int32(2), nil},
+		{int32(3), `\N`},
+		{int32(4), "Obi-Wan Kenobi\n"},
+	}
+
+	require.Equal(t, expected, rows)
+}
+
+func TestUnescapeCopyText(t *testing.T) {
+	tests := map[string]string{
+		`plain`:        "plain",
+		`tab\there`:    "tab\there",
+		`\b\f\n\r\t\v`: "\b\f\n\r\t\v",
+		`back\\slash`:  `back\slash`,
+		`\101\60\0`:    "A0\x00",
+		`\x41\x4a\xg`:  "AJxg",
+		`\z\.`:         "z.",
+		`trailing\`:    `trailing\`,
+	}
+
+	for input, expected := range tests {
+		require.Equal(t, expected, string(unescapeCopyText([]byte(input))), input)
+	}
+}
+
+func TestCSVCopyReader(t *testing.T) {
+	table := Columns{
+		{
+			Table: 0,
+			Name:  "id",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+		{
+			Table: 0,
+			Name:  "name",
+			Oid:   pgtype.TextOID,
+			Width: 256,
+		},
+		{
+			Table: 0,
+			Name:  "title",
+			Oid:   pgtype.TextOID,
+			Width: 256,
+		},
+	}
+
+	var rows [][]any
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		options, err := ParseCopyOptions(query)
+		if err != nil {
+			return nil, err
+		}
+
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			copy, err := writer.CopyIn(TextFormat)
+			if err != nil {
+				return err
+			}
+
+			reader, err := NewCSVColumnReader(ctx, copy, options)
+			if err != nil {
+				return err
+			}
+
+			rows = nil
+			for {
+				row, err := reader.Read(ctx)
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					return err
+				}
+
+				rows = append(rows, row)
+			}
+
+			return writer.Complete(fmt.Sprintf("COPY %d", len(rows)))
+		}
+
+		return Prepared(NewStatement(handle, WithColumns(table))), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	data := "id,name,title\n" +
+		"1,\"Skywalker, Luke\",\n" +
+		"2,,\"\"\n" +
+		"3,\"Obi-Wan \"\"Ben\"\"\nKenobi\",Master\n"
+
+	tag, err := conn.PgConn().CopyFrom(ctx, strings.NewReader(data), `COPY "jedis" FROM STDIN WITH (FORMAT csv, HEADER MATCH, FORCE_NULL (title), FORCE_NOT_NULL (name))`)
+	require.NoError(t, err)
+	require.Equal(t, "COPY 3", tag.String())
+
+	expected := [][]any{
+		{int32(1), "Skywalker, Luke", nil},
+		{int32(2), "", nil},
+		{int32(3), "Obi-Wan \"Ben\"\nKenobi", "Master"},
+	}
+
+	require.Equal(t, expected, rows)
+
+	data = `1|'Luke \'Red Five\''|` + "\n" +
+		`2|'back\\slash'|NULL` + "\n"
+
+	tag, err = conn.PgConn().CopyFrom(ctx, strings.NewReader(data), `COPY "jedis" FROM STDIN WITH DELIMITER '|' NULL AS 'NULL' CSV QUOTE '''' ESCAPE '\'`)
+	require.NoError(t, err)
+	require.Equal(t, "COPY 2", tag.String())
+
+	expected = [][]any{
+		{int32(1), "Luke 'Red Five'", ""},
+		{int32(2), `back\slash`, nil},
+	}
+
+	require.Equal(t, expected, rows)
+
+	_, err = conn.PgConn().CopyFrom(ctx, strings.NewReader("id,title,name\n"), `COPY "jedis" FROM STDIN WITH (FORMAT csv, HEADER MATCH)`)
+	require.ErrorContains(t, err, "column name mismatch in header line field 2")
+}
+
+func TestParseCopyOptions(t *testing.T) {
+	tests := map[string]CopyOptions{
+		`COPY "jedis" FROM STDIN`: {},
+		`COPY jedis (id, name) TO STDOUT WITH (FORMAT csv, HEADER true, DELIMITER ';', NULL 'null')`: {
+			Format:    CopyCSV,
+			Header:    true,
+			Delimiter: ';',
+			Null:      "null",
+			NullSet:   true,
+		},
+		`COPY (SELECT * FROM jedis) TO STDOUT (FORMAT binary)`: {
+			Format: CopyBinary,
+		},
+		`COPY jedis FROM STDIN WITH (FORMAT 'csv', HEADER MATCH, QUOTE '''', ESCAPE E'\\', FORCE_NULL *, FORCE_NOT_NULL ("Name", title)) WHERE id > 1`: {
+			Format:       CopyCSV,
+			Header:       true,
+			HeaderMatch:  true,
+			Quote:        '\'',
+			Escape:       '\\',
+			ForceNull:    []string{CopyAllColumns},
+			ForceNotNull: []string{"Name", "title"},
+		},
+		`COPY jedis FROM STDIN WITH (FORMAT text, NULL '', DELIMITER E'\t', FREEZE, ENCODING 'UTF8')`: {
+			NullSet:   true,
+			Delimiter: '\t',
+			Freeze:    true,
+			Encoding:  "UTF8",
+		},
+		`COPY jedis TO STDOUT WITH DELIMITER AS '|' NULL AS '' CSV HEADER QUOTE AS '"' FORCE QUOTE id, name`: {
+			Format:     CopyCSV,
+			Header:     true,
+			Delimiter:  '|',
+			Quote:      '"',
+			NullSet:    true,
+			ForceQuote: []string{"id", "name"},
+		},
+		`COPY jedis FROM PROGRAM 'cat jedis.csv' CSV FORCE NOT NULL name`: {
+			Format:       CopyCSV,
+			ForceNotNull: []string{"name"},
+		},
+		`WITH BINARY`: {
+			Format: CopyBinary,
+		},
+	}
+
+	for statement, expected := range tests {
+		options, err := ParseCopyOptions(statement)
+		require.NoError(t, err, statement)
+		require.Equal(t, expected, options, statement)
+	}
+
+	invalid := map[string]codes.Code{
+		`COPY jedis FROM STDIN WITH (FORMAT xml)`:                     codes.InvalidParameterValue,
+		`COPY jedis FROM STDIN WITH (FORMAT csv, FORMAT text)`:        codes.Syntax,
+		`COPY jedis FROM STDIN WITH (QUOTE '"')`:                      codes.FeatureNotSupported,
+		`COPY jedis FROM STDIN WITH (FORMAT binary, HEADER)`:          codes.Syntax,
+		`COPY jedis FROM STDIN WITH (DELIMITER '||')`:                 codes.FeatureNotSupported,
+		`COPY jedis FROM STDIN WITH (FORMAT csv, DELIMITER '"')`:      codes.InvalidParameterValue,
+		`COPY jedis FROM STDIN WITH (UNKNOWN 1)`:                      codes.Syntax,
+		`COPY jedis FROM STDIN WITH (FORMAT csv, FORCE_NULL name)`:    codes.Syntax,
+		`COPY jedis FROM STDIN WITH (NULL E'\t')`:                     codes.InvalidParameterValue,
+		`COPY jedis FROM STDIN WITH (DELIMITER 'x'`:                   codes.Syntax,
+		`COPY jedis FROM STDIN WITH DELIMITER 'x' NULL 'unterminated`: codes.Syntax,
+		`COPY jedis FROM STDIN WITH (FORMAT csv, NULL 'x"')`:          codes.FeatureNotSupported,
+	}
+
+	for statement, code := range invalid {
+		_, err := ParseCopyOptions(statement)
+		require.Error(t, err, statement)
+		require.Equal(t, code, psqlerr.GetCode(err), statement)
+	}
+
+	// NOTE: an explicitly defined empty null string is preserved in text format.
+	require.Equal(t, `\N`, CopyOptions{}.defaults(CopyText).Null)
+	require.Equal(t, "", CopyOptions{NullSet: true}.defaults(CopyText).Null)
+}
+
+func TestBinaryCopy(t *testing.T) {
+	table := Columns{
+		{
+			Table: 0,
+			Name:  "id",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+		{
+			Table: 0,
+			Name:  "name",
+			Oid:   pgtype.TextOID,
+			Width: 256,
+		},
+	}
+
+	var rows [][]any
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			copy, err := writer.CopyIn(BinaryFormat)
+			if err != nil {
+				return err
+			}
+
+			reader, err := NewBinaryColumnReader(ctx, copy)
+			if err != nil {
+				return err
+			}
+
+			rows = nil
+			for {
+				row, err := reader.Read(ctx)
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					return err
+				}
+
+				rows = append(rows, row)
+			}
+
+			return writer.Complete(fmt.Sprintf("COPY %d", len(rows)))
+		}
+
+		return Prepared(NewStatement(handle, WithColumns(table))), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	expected := [][]any{
+		{int32(1), "Luke Skywalker"},
+		{int32(2), nil},
+	}
+
+	// NOTE: the rows are encoded using the binary column writer and send one
+	// byte at a time, splitting the header and rows across CopyData messages.
+	var data bytes.Buffer
+	writer, err := NewBinaryColumnWriter(setTypeInfo(ctx, pgtype.NewMap()), &data, table)
+	require.NoError(t, err)
+
+	for _, row := range expected {
+		require.NoError(t, writer.Write(ctx, row))
+	}
+
+	require.NoError(t, writer.Close())
+
+	copyFrom := func(data []byte) error {
+		_, err := conn.PgConn().CopyFrom(ctx, iotest.OneByteReader(bytes.NewReader(data)), `COPY "jedis" FROM STDIN WITH (FORMAT binary)`)
+		return err
+	}
+
+	require.NoError(t, copyFrom(data.Bytes()))
+	require.Equal(t, expected, rows)
+
+	// NOTE: the header extension is skipped and the OID field, which is not
+	// included in the field count, is ignored.
+	header := append(append([]byte{}, CopySignature...), 0, 1, 0, 0, 0, 0, 0, 3, 'e', 'x', 't')
+	row := []byte{0, 2, 0, 0, 0, 4, 0, 0, 0, 42, 0, 0, 0, 4, 0, 0, 0, 3, 0, 0, 0, 3, 'Y', 'o', 'd'}
+	trailer := []byte{0xff, 0xff, 'i', 'g', 'n', 'o', 'r', 'e', 'd'}
+
+	require.NoError(t, copyFrom(append(append(header, row...), trailer...)))
+	require.Equal(t, [][]any{{int32(3), "Yod"}}, rows)
+
+	// NOTE: the low-order flags are reserved to signal backwards-compatible
+	// format issues and are ignored.
+	header = append(append([]byte{}, CopySignature...), 0, 0, 0, 1, 0, 0, 0, 0)
+	row = []byte{0, 2, 0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 3, 'Y', 'o', 'd'}
+	require.NoError(t, copyFrom(append(append(header, row...), 0xff, 0xff)))
+	require.Equal(t, [][]any{{int32(4), "Yod"}}, rows)
+
+	// NOTE: unrecognized critical flags are rejected.
+	header = append(append([]byte{}, CopySignature...), 0, 2, 0, 0, 0, 0, 0, 0)
+	err = copyFrom(append(header, 0xff, 0xff))
+	require.ErrorContains(t, err, "unrecognized critical flags in COPY file header")
+
+	err = copyFrom([]byte("PGCOPY\n"))
+	require.ErrorContains(t, err, "COPY file signature not recognized")
+}
@@ -0,0 +1,612 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// newErrCursorSyntax is returned whenever a cursor command could not be parsed.
+func newErrCursorSyntax(command string) error {
+	err := fmt.Errorf("syntax error in %s statement", command)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.Syntax), psqlerr.LevelError)
+}
+
+// newErrCursorMultipleStatements is returned whenever a cursor command is
+// combined with other statements inside a single query.
+func newErrCursorMultipleStatements(command string) error {
+	err := fmt.Errorf("cannot insert multiple commands into a %s statement", command)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.Syntax), psqlerr.LevelError)
+}
+
+// newErrUnknownCursor is returned whenever a cursor is referenced which does
+// not exist.
+func newErrUnknownCursor(name string) error {
+	err := fmt.Errorf("cursor %q does not exist", name)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.InvalidCursorName), psqlerr.LevelError)
+}
+
+// newErrDuplicateCursor is returned whenever a cursor is declared using the
+// name of an existing cursor.
+func newErrDuplicateCursor(name string) error {
+	err := fmt.Errorf("cursor %q already exists", name)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.DuplicateCursor), psqlerr.LevelError)
+}
+
+// newErrCursorOutsideTx is returned whenever a cursor without hold is declared
+// outside a transaction block.
+func newErrCursorOutsideTx() error {
+	err := errors.New("DECLARE CURSOR can only be used in transaction blocks")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.NoActiveSQLTransaction), psqlerr.LevelError)
+}
+
+// newErrCursorForwardOnly is returned whenever a forward-only cursor is
+// scanned backward.
+func newErrCursorForwardOnly() error {
+	err := errors.New("cursor can only scan forward")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.ObjectNotInPrerequisiteState), psqlerr.LevelError)
+}
+
+// newErrScrollNotEnabled is returned whenever a scrollable cursor is declared
+// while scrollable cursors have not been enabled.
+func newErrScrollNotEnabled() error {
+	err := errors.New("scrollable cursors are not supported")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.FeatureNotSupported), psqlerr.LevelError)
+}
+
+// cursorDirection represents the direction in which a cursor is moved.
+type cursorDirection int
+
+const (
+	// cursorForward moves the cursor count rows forward.
+	cursorForward cursorDirection = iota
+	// cursorBackward moves the cursor count rows backward.
+	cursorBackward
+	// cursorAbsolute moves the cursor to the given row. Negative positions
+	// are counted from the end.
+	cursorAbsolute
+	// cursorRelative moves the cursor to the given row relative to the
+	// current position.
+	cursorRelative
+)
+
+// cursorMove represents the direction and the number of rows of a FETCH or
+// MOVE command.
+type cursorMove struct {
+	direction cursorDirection
+	count     int64
+	all       bool
+}
+
+// cursor represents a cursor declared using the simple query protocol. The
+// cursor is backed by a named portal inside the portal cache of the session.
+// The rows produced by the portal are written to a buffer before they are
+// written to the client.
+type cursor struct {
+	name    string
+	columns Columns
+	formats []FormatCode
+	scroll  bool
+	hold    bool
+	// pending is set for cursors declared WITH HOLD inside a transaction which
+	// has not been committed yet. Pending cursors are closed once the
+	// transaction is rolled back.
+	pending bool
+	buf     *bytes.Buffer
+	writer  *buffer.Writer
+
+	// rows contains the rows produced by a scrollable cursor or the rows of a
+	// held cursor materialized at commit. Rows is nil for forward-only cursors
+	// which have not been materialized. The position is the index of the current row
+	// starting at 1, a position of 0 is located before the first row.
+	rows     *rowBuffer
+	position int64
+	// exhausted is set once the portal backing the cursor has completed.
+	exhausted bool
+	// onRow is set while a forward-only cursor is located on the row which
+	// has been fetched last.
+	onRow bool
+}
+
+// handleCursorQuery handles the given simple query when it contains a
+// DECLARE, FETCH, MOVE or CLOSE statement and cursors are enabled. False is
+// returned when the given query is not a cursor statement.
+func (srv *Session) handleCursorQuery(ctx context.Context, query string, reader *buffer.Reader, writer *buffer.Writer) (bool, error) {
+	if !srv.Cursors {
+		return false, nil
+	}
+
+	// NOTE: queries which could not be tokenized are passed to the parse
+	// function which is expected to report the syntax error.
+	statement := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens, err := tokenizeStatement(statement)
+	if err != nil || len(tokens) == 0 || !tokens[0].keyword(tokens[0].value) {
+		return false, nil
+	}
+
+	var fn func(tokens []statementToken) error
+	command := strings.ToUpper(tokens[0].value)
+	switch command {
+	case "DECLARE":
+		fn = func(tokens []statementToken) error { return srv.declareCursor(ctx, statement, tokens, writer) }
+	case "FETCH":
+		fn = func(tokens []statementToken) error { return srv.fetchCursor(ctx, tokens, false, reader, writer) }
+	case "MOVE":
+		fn = func(tokens []statementToken) error { return srv.fetchCursor(ctx, tokens, true, reader, writer) }
+	case "CLOSE":
+		fn = func(tokens []statementToken) error { return srv.closeCursor(ctx, tokens, writer) }
+	default:
+		return false, nil
+	}
+
+	srv.logger.Debug("incoming cursor statement", "statement", statement)
+
+	// NOTE: cursor statements are handled in their entirety, statements
+	// following the cursor statement would otherwise be ignored.
+	if multipleStatements(statement) {
+		return true, srv.WriteError(writer, newErrCursorMultipleStatements(command))
+	}
+
+	err = srv.beginTx(ctx)
+	if err != nil {
+		return true, srv.WriteError(writer, err)
+	}
+
+	err = fn(tokens)
+	if err != nil {
+		return true, srv.rollbackAndWriteError(ctx, writer, err)
+	}
+
+	err = srv.endImplicitTx(ctx, true)
+	if err != nil {
+		return true, srv.WriteError(writer, err)
+	}
+
+	return true, srv.readyForQuery(writer)
+}
+
+// declareCursor declares a new cursor for the query inside the given DECLARE
+// statement. The query is parsed and bound to a portal named after the cursor.
+func (srv *Session) declareCursor(ctx context.Context, statement string, tokens []statementToken, writer *buffer.Writer) error {
+	if len(tokens) < 2 || !tokens[1].identifier() {
+		return newErrCursorSyntax("DECLARE")
+	}
+
+	name := tokens[1].value
+	tokens = tokens[2:]
+
+	var formats []FormatCode
+	var scroll bool
+	for len(tokens) > 0 && !tokens[0].keyword("cursor") {
+		switch {
+		case tokens[0].keyword("binary"):
+			formats = []FormatCode{BinaryFormat}
+		case tokens[0].keyword("scroll"):
+			scroll = true
+		case tokens[0].keyword("insensitive"), tokens[0].keyword("asensitive"):
+		case tokens[0].keyword("no"):
+			if len(tokens) < 2 || !tokens[1].keyword("scroll") {
+				return newErrCursorSyntax("DECLARE")
+			}
+
+			tokens = tokens[1:]
+		default:
+			return newErrCursorSyntax("DECLARE")
+		}
+
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 0 {
+		return newErrCursorSyntax("DECLARE")
+	}
+
+	tokens = tokens[1:]
+
+	var hold bool
+	if len(tokens) >= 2 && (tokens[0].keyword("with") || tokens[0].keyword("without")) && tokens[1].keyword("hold") {
+		hold = tokens[0].keyword("with")
+		tokens = tokens[2:]
+	}
+
+	if len(tokens) < 2 || !tokens[0].keyword("for") {
+		return newErrCursorSyntax("DECLARE")
+	}
+
+	query := statement[tokens[1].offset:]
+
+	if !hold && !inTxBlock(srv.TxStatus()) {
+		return newErrCursorOutsideTx()
+	}
+
+	if scroll && !srv.ScrollableCursors.Enabled {
+		return newErrScrollNotEnabled()
+	}
+
+	if srv.lookupCursor(name) != nil {
+		return newErrDuplicateCursor(name)
+	}
+
+	prepared, err := singleStatement(srv.parseQuery(ctx, query))
+	if err != nil {
+		return err
+	}
+
+	stmt := &Statement{
+		fn:         prepared.fn,
+		parameters: prepared.parameters,
+		columns:    prepared.columns,
+		query:      query,
+	}
+
+	err = srv.Portals.Bind(ctx, name, stmt, nil, formats)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	cur := &cursor{
+		name:    name,
+		columns: prepared.columns,
+		formats: formats,
+		scroll:  scroll,
+		hold:    hold,
+		pending: hold,
+		buf:     buf,
+		writer:  buffer.NewWriter(srv.logger, buf),
+	}
+
+	if scroll {
+		cur.rows = newRowBuffer(srv.ScrollableCursors)
+	}
+
+	srv.cursorsMu.Lock()
+	if srv.declaredCursors == nil {
+		srv.declaredCursors = make(map[string]*cursor)
+	}
+
+	srv.declaredCursors[name] = cur
+	srv.cursorsMu.Unlock()
+
+	return commandComplete(writer, "DECLARE CURSOR")
+}
+
+// fetchCursor handles the given FETCH or MOVE statement. Rows fetched from the
+// cursor are written to the client unless the cursor is moved.
+func (srv *Session) fetchCursor(ctx context.Context, tokens []statementToken, move bool, reader *buffer.Reader, writer *buffer.Writer) error {
+	command := strings.ToUpper(tokens[0].value)
+	name, movement, err := parseCursorMove(tokens)
+	if err != nil {
+		return err
+	}
+
+	cur := srv.lookupCursor(name)
+	if cur == nil {
+		return newErrUnknownCursor(name)
+	}
+
+	// NOTE: a count of zero refetches the current row without moving the
+	// cursor, regardless of the direction.
+	refetch := !movement.all && movement.count == 0 && movement.direction != cursorAbsolute
+	if !cur.scroll && refetch {
+		movement.direction = cursorForward
+	}
+
+	if !cur.scroll && movement.direction != cursorForward {
+		return newErrCursorForwardOnly()
+	}
+
+	if !move {
+		err = cur.columns.Define(ctx, writer, cur.formats)
+		if err != nil {
+			return err
+		}
+	}
+
+	emit := func(row []byte) error {
+		if move {
+			return nil
+		}
+
+		_, err := writer.Write(row)
+		return err
+	}
+
+	var rows int64
+	switch {
+	case cur.rows != nil:
+		rows, err = srv.scrollCursor(ctx, cur, movement, reader, writer, emit)
+	case refetch:
+		rows, err = refetchForwardCursor(cur, move)
+	default:
+		rows, err = srv.forwardCursor(ctx, cur, movement, reader, writer, emit)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return commandComplete(writer, command+" "+strconv.FormatInt(rows, 10))
+}
+
+// forwardCursor moves the given forward-only cursor forward. The rows passed
+// are directly produced by the portal backing the cursor.
+func (srv *Session) forwardCursor(ctx context.Context, cur *cursor, movement cursorMove, reader *buffer.Reader, writer *buffer.Writer, emit func(row []byte) error) (int64, error) {
+	limit := NoLimit
+	if !movement.all {
+		limit = Limit(min(movement.count, math.MaxUint32))
+	}
+
+	rows, _, err := srv.executeCursor(ctx, cur, limit, reader, writer, emit)
+	cur.onRow = limit != NoLimit && rows == int64(limit)
+	return rows, err
+}
+
+// refetchForwardCursor handles a FETCH or MOVE of zero rows on the given
+// forward-only cursor. Moving reports whether the cursor is located on a row.
+// The current row could not be fetched again since the cursor could not be
+// scanned backward, similar to cursors declared NO SCROLL in Postgres.
+func refetchForwardCursor(cur *cursor, move bool) (int64, error) {
+	if !cur.onRow {
+		return 0, nil
+	}
+
+	if move {
+		return 1, nil
+	}
+
+	return 0, newErrCursorForwardOnly()
+}
+
+// executeCursor executes the portal backing the given cursor until the given
+// number of rows has been produced. Each produced data row message is passed
+// to the given function, all other messages are written to the client. The
+// number of produced rows is returned and whether the portal has completed.
+func (srv *Session) executeCursor(ctx context.Context, cur *cursor, limit Limit, reader *buffer.Reader, writer *buffer.Writer, fn func(row []byte) error) (rows int64, done bool, err error) {
+	err = srv.Portals.Execute(ctx, cur.name, limit, reader, cur.writer)
+	defer cur.buf.Reset()
+
+	for data := cur.buf.Bytes(); len(data) > 5; {
+		length := int(binary.BigEndian.Uint32(data[1:5])) + 1
+		message := data[:length]
+		data = data[length:]
+
+		switch types.ServerMessage(message[0]) {
+		case types.ServerDataRow:
+			rows++
+			ferr := fn(message)
+			if ferr != nil {
+				return rows, done, ferr
+			}
+		case types.ServerCommandComplete:
+			// NOTE: the command tag of the portal is replaced by the tag of
+			// the cursor statement.
+			done = true
+		case types.ServerPortalSuspended:
+		default:
+			_, werr := writer.Write(message)
+			if werr != nil {
+				return rows, done, werr
+			}
+		}
+	}
+
+	return rows, done, err
+}
+
+// closeCursor handles the given CLOSE statement closing the referenced cursor
+// or all cursors.
+func (srv *Session) closeCursor(ctx context.Context, tokens []statementToken, writer *buffer.Writer) error {
+	if len(tokens) != 2 || !tokens[1].identifier() {
+		return newErrCursorSyntax("CLOSE")
+	}
+
+	if tokens[1].keyword("all") {
+		srv.closeCursors(ctx, func(*cursor) bool { return true })
+		return commandComplete(writer, "CLOSE CURSOR")
+	}
+
+	name := tokens[1].value
+	if srv.lookupCursor(name) == nil {
+		return newErrUnknownCursor(name)
+	}
+
+	srv.closeCursors(ctx, func(cur *cursor) bool { return cur.name == name })
+	return commandComplete(writer, "CLOSE CURSOR")
+}
+
+// lookupCursor returns the cursor with the given name. Nil is returned when no
+// cursor with the given name has been declared.
+func (srv *Session) lookupCursor(name string) *cursor {
+	srv.cursorsMu.Lock()
+	defer srv.cursorsMu.Unlock()
+	return srv.declaredCursors[name]
+}
+
+// closeCursors closes all cursors matching the given function and deletes the
+// portals backing them.
+func (srv *Session) closeCursors(ctx context.Context, fn func(*cursor) bool) {
+	srv.cursorsMu.Lock()
+	defer srv.cursorsMu.Unlock()
+
+	for name, cur := range srv.declaredCursors {
+		if !fn(cur) {
+			continue
+		}
+
+		srv.Portals.Delete(ctx, name) //nolint:errcheck
+		delete(srv.declaredCursors, name)
+
+		if cur.rows != nil {
+			err := cur.rows.Close()
+			if err != nil {
+				srv.logger.Error("unable to close cursor rows", "cursor", name, "err", err)
+			}
+		}
+	}
+}
+
+// endCursors closes the cursors which do not outlive the transaction which has
+// been ended. Cursors declared WITH HOLD outlive committed transactions. The
+// remaining rows of held cursors are materialized before the transaction is
+// committed, the statements backing held cursors are therefore never executed
+// after their transaction has ended. An error is returned when a held cursor
+// could not be materialized, the transaction is then expected to be rolled
+// back.
+func (srv *Session) endCursors(ctx context.Context, commit bool) (err error) {
+	if commit {
+		err = srv.materializeCursors(ctx)
+		commit = err == nil
+	}
+
+	srv.closeCursors(ctx, func(cur *cursor) bool {
+		if !cur.hold || (cur.pending && !commit) {
+			return true
+		}
+
+		cur.pending = false
+		return false
+	})
+
+	return err
+}
+
+// materializeCursors buffers the remaining rows of the cursors declared WITH
+// HOLD inside the ongoing transaction. Rows are kept in memory or spilled to
+// disk using the configuration of scrollable cursors.
+func (srv *Session) materializeCursors(ctx context.Context) error {
+	srv.cursorsMu.Lock()
+	var pending []*cursor
+	for _, cur := range srv.declaredCursors {
+		if cur.pending {
+			pending = append(pending, cur)
+		}
+	}
+	srv.cursorsMu.Unlock()
+
+	for _, cur := range pending {
+		if cur.rows == nil {
+			cur.rows = newRowBuffer(srv.ScrollableCursors)
+		}
+
+		if cur.exhausted {
+			continue
+		}
+
+		_, _, err := srv.executeCursor(ctx, cur, NoLimit, srv.reader, srv.writer, cur.rows.Append)
+		if err != nil {
+			return err
+		}
+
+		cur.exhausted = true
+	}
+
+	return nil
+}
+
+// parseCursorMove parses the direction and cursor name of the given FETCH or
+// MOVE statement tokens.
+func parseCursorMove(tokens []statementToken) (string, cursorMove, error) {
+	command := strings.ToUpper(tokens[0].value)
+	if len(tokens) < 2 || !tokens[len(tokens)-1].identifier() {
+		return "", cursorMove{}, newErrCursorSyntax(command)
+	}
+
+	name := tokens[len(tokens)-1].value
+	tokens = tokens[1 : len(tokens)-1]
+	if len(tokens) > 0 {
+		if last := tokens[len(tokens)-1]; last.keyword("from") || last.keyword("in") {
+			tokens = tokens[:len(tokens)-1]
+		}
+	}
+
+	count := func(index int) (int64, bool) {
+		if len(tokens) <= index {
+			return 1, true
+		}
+
+		if tokens[index].literal || tokens[index].quoted || tokens[index].punct {
+			return 0, false
+		}
+
+		value, err := strconv.ParseInt(tokens[index].value, 10, 64)
+		return value, err == nil && len(tokens) == index+1
+	}
+
+	if len(tokens) == 0 {
+		return name, cursorMove{direction: cursorForward, count: 1}, nil
+	}
+
+	var movement cursorMove
+	var ok bool
+
+	// NOTE: quoted identifiers and literals are never matched as keywords.
+	var keyword string
+	if tokens[0].keyword(tokens[0].value) {
+		keyword = tokens[0].value
+	}
+
+	switch keyword {
+	case "next":
+		movement, ok = cursorMove{direction: cursorForward, count: 1}, len(tokens) == 1
+	case "prior":
+		movement, ok = cursorMove{direction: cursorBackward, count: 1}, len(tokens) == 1
+	case "first":
+		movement, ok = cursorMove{direction: cursorAbsolute, count: 1}, len(tokens) == 1
+	case "last":
+		movement, ok = cursorMove{direction: cursorAbsolute, count: -1}, len(tokens) == 1
+	case "all":
+		movement, ok = cursorMove{direction: cursorForward, all: true}, len(tokens) == 1
+	case "absolute", "relative":
+		direction := cursorAbsolute
+		if keyword == "relative" {
+			direction = cursorRelative
+		}
+
+		movement.direction = direction
+		movement.count, ok = count(1)
+		ok = ok && len(tokens) == 2
+	case "forward", "backward":
+		direction := cursorForward
+		if keyword == "backward" {
+			direction = cursorBackward
+		}
+
+		movement.direction = direction
+		if len(tokens) == 2 && tokens[1].keyword("all") {
+			movement.all, ok = true, true
+			break
+		}
+
+		movement.count, ok = count(1)
+	default:
+		movement.direction = cursorForward
+		movement.count, ok = count(0)
+	}
+
+	if !ok {
+		return "", cursorMove{}, newErrCursorSyntax(command)
+	}
+
+	// NOTE: negative counts move the cursor in the opposite direction.
+	if movement.count < 0 && (movement.direction == cursorForward || movement.direction == cursorBackward) {
+		movement.count = -movement.count
+		if movement.direction == cursorForward {
+			movement.direction = cursorBackward
+		} else {
+			movement.direction = cursorForward
+		}
+	}
+
+	return name, movement, nil
+}
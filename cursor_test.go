@@ -0,0 +1,475 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+// cursorHandler returns a parse function producing the numbers 1 to 10 for
+// SELECT queries. All other queries are handled by the transaction handler.
+func cursorHandler(ctx context.Context, query string) (PreparedStatements, error) {
+	if !strings.HasPrefix(strings.ToUpper(query), "SELECT") {
+		return txHandler(ctx, query)
+	}
+
+	columns := Columns{
+		{
+			Table: 0,
+			Name:  "n",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+	}
+
+	handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+		for n := 1; n <= 10; n++ {
+			err := writer.Row([]any{n})
+			if err != nil {
+				return err
+			}
+		}
+
+		return writer.Complete("SELECT 10")
+	}
+
+	return Prepared(NewStatement(handle, WithColumns(columns))), nil
+}
+
+func TestCursors(t *testing.T) {
+	t.Parallel()
+
+	params := Parameters{"standard_conforming_strings": "on"}
+	server, err := NewServer(cursorHandler, Logger(slogt.New(t)), GlobalParameters(params), Cursors())
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	exec := func(query string) (string, error) {
+		tag, err := conn.Exec(ctx, query, pgx.QueryExecModeSimpleProtocol)
+		return tag.String(), err
+	}
+
+	fetch := func(query string) ([]int32, string) {
+		rows, err := conn.Query(ctx, query, pgx.QueryExecModeSimpleProtocol)
+		require.NoError(t, err)
+
+		result, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+		require.NoError(t, err)
+		return result, rows.CommandTag().String()
+	}
+
+	code := func(err error) string {
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		return pgErr.Code
+	}
+
+	// NOTE: rows are fetched in forward direction until the cursor is exhausted.
+	_, err = exec("BEGIN")
+	require.NoError(t, err)
+
+	tag, err := exec("DECLARE c CURSOR FOR SELECT n FROM numbers")
+	require.NoError(t, err)
+	require.Equal(t, "DECLARE CURSOR", tag)
+
+	rows, tag := fetch("FETCH 3 FROM c")
+	require.Equal(t, []int32{1, 2, 3}, rows)
+	require.Equal(t, "FETCH 3", tag)
+
+	rows, tag = fetch("FETCH NEXT c")
+	require.Equal(t, []int32{4}, rows)
+	require.Equal(t, "FETCH 1", tag)
+
+	tag, err = exec("MOVE FORWARD 2 IN c")
+	require.NoError(t, err)
+	require.Equal(t, "MOVE 2", tag)
+
+	rows, tag = fetch("FETCH ALL FROM c")
+	require.Equal(t, []int32{7, 8, 9, 10}, rows)
+	require.Equal(t, "FETCH 4", tag)
+
+	rows, tag = fetch("FETCH c")
+	require.Empty(t, rows)
+	require.Equal(t, "FETCH 0", tag)
+
+	_, err = exec("FETCH PRIOR FROM c")
+	require.Equal(t, string(codes.ObjectNotInPrerequisiteState), code(err))
+
+	_, err = exec("ROLLBACK")
+	require.NoError(t, err)
+
+	_, err = exec("FETCH c")
+	require.Equal(t, string(codes.InvalidCursorName), code(err))
+
+	// NOTE: cursors without hold could only be declared inside transaction blocks.
+	_, err = exec("DECLARE c CURSOR FOR SELECT n FROM numbers")
+	require.Equal(t, string(codes.NoActiveSQLTransaction), code(err))
+
+	_, err = exec("DECLARE scrolled SCROLL CURSOR WITH HOLD FOR SELECT n FROM numbers")
+	require.Equal(t, string(codes.FeatureNotSupported), code(err))
+
+	// NOTE: cursors declared with hold outlive committed transactions.
+	_, err = exec("BEGIN")
+	require.NoError(t, err)
+
+	_, err = exec("DECLARE held CURSOR WITH HOLD FOR SELECT n FROM numbers")
+	require.NoError(t, err)
+
+	_, err = exec(`DECLARE "Unheld" NO SCROLL CURSOR WITHOUT HOLD FOR SELECT n FROM numbers`)
+	require.NoError(t, err)
+
+	_, err = exec("DECLARE held CURSOR FOR SELECT n FROM numbers")
+	require.Equal(t, string(codes.DuplicateCursor), code(err))
+
+	_, err = exec("ROLLBACK")
+	require.NoError(t, err)
+
+	_, err = exec("FETCH held")
+	require.Equal(t, string(codes.InvalidCursorName), code(err))
+
+	_, err = exec("BEGIN")
+	require.NoError(t, err)
+
+	_, err = exec("DECLARE held CURSOR WITH HOLD FOR SELECT n FROM numbers")
+	require.NoError(t, err)
+
+	_, err = exec(`DECLARE "Unheld" CURSOR FOR SELECT n FROM numbers`)
+	require.NoError(t, err)
+
+	rows, _ = fetch(`FETCH 2 FROM "Unheld"`)
+	require.Equal(t, []int32{1, 2}, rows)
+
+	_, err = exec("COMMIT")
+	require.NoError(t, err)
+
+	rows, _ = fetch("FETCH 2 FROM held")
+	require.Equal(t, []int32{1, 2}, rows)
+
+	_, err = exec(`FETCH "Unheld"`)
+	require.Equal(t, string(codes.InvalidCursorName), code(err))
+
+	tag, err = exec("CLOSE held")
+	require.NoError(t, err)
+	require.Equal(t, "CLOSE CURSOR", tag)
+
+	_, err = exec("FETCH held")
+	require.Equal(t, string(codes.InvalidCursorName), code(err))
+
+	// NOTE: quoted cursor names could contain spaces, cursor statements could
+	// not be combined with other statements.
+	_, err = exec("BEGIN")
+	require.NoError(t, err)
+
+	_, err = exec(`DECLARE "my cur" CURSOR FOR SELECT n FROM numbers`)
+	require.NoError(t, err)
+
+	// NOTE: refetching the current row is not supported by forward-only
+	// cursors, cursors not located on a row return no rows.
+	rows, tag = fetch(`FETCH 0 FROM "my cur"`)
+	require.Empty(t, rows)
+	require.Equal(t, "FETCH 0", tag)
+
+	rows, _ = fetch(`FETCH 2 FROM "my cur"`)
+	require.Equal(t, []int32{1, 2}, rows)
+
+	_, err = exec(`FETCH 0 FROM "my cur"`)
+	require.Equal(t, string(codes.ObjectNotInPrerequisiteState), code(err))
+
+	_, err = exec("ROLLBACK")
+	require.NoError(t, err)
+
+	_, err = exec("BEGIN")
+	require.NoError(t, err)
+
+	_, err = exec(`/* comment */ DECLARE "my cur" CURSOR FOR SELECT n FROM numbers`)
+	require.NoError(t, err)
+
+	rows, _ = fetch(`-- comment` + "\n" + `FETCH 2 FROM "my cur"`)
+	require.Equal(t, []int32{1, 2}, rows)
+
+	tag, err = exec(`MOVE RELATIVE 0 IN "my cur"`)
+	require.NoError(t, err)
+	require.Equal(t, "MOVE 1", tag)
+
+	_, err = exec(`FETCH RELATIVE 0 FROM "my cur"`)
+	require.Equal(t, string(codes.ObjectNotInPrerequisiteState), code(err))
+
+	_, err = exec("ROLLBACK")
+	require.NoError(t, err)
+
+	_, err = exec("BEGIN")
+	require.NoError(t, err)
+
+	_, err = exec(`DECLARE "my cur" CURSOR FOR SELECT n FROM numbers`)
+	require.NoError(t, err)
+
+	rows, _ = fetch(`/* FETCH 5 */ FETCH 2 FROM "my cur"`)
+	require.Equal(t, []int32{1, 2}, rows)
+
+	_, err = exec(`FETCH 1 FROM "my cur"; SELECT 1`)
+	require.Equal(t, string(codes.Syntax), code(err))
+
+	_, err = exec("ROLLBACK")
+	require.NoError(t, err)
+
+	_, err = exec(`DECLARE c CURSOR WITH HOLD FOR SELECT n FROM numbers; SELECT 1`)
+	require.Equal(t, string(codes.Syntax), code(err))
+
+	_, err = exec("FETCH c")
+	require.Equal(t, string(codes.InvalidCursorName), code(err))
+}
+
+func TestHeldCursors(t *testing.T) {
+	t.Parallel()
+
+	transactions := &recordingTxHandler{}
+	columns := Columns{
+		{
+			Table: 0,
+			Name:  "n",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+	}
+
+	// NOTE: the produced rows are recorded, allowing to verify that the rows
+	// of held cursors are produced inside the transaction declaring them.
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		if !strings.HasPrefix(strings.ToUpper(query), "SELECT") {
+			return txHandler(ctx, query)
+		}
+
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			for n := 1; n <= 3; n++ {
+				transactions.record(fmt.Sprintf("row %d", n)) //nolint:errcheck
+				err := writer.Row([]any{n})
+				if err != nil {
+					return err
+				}
+			}
+
+			return writer.Complete("SELECT 3")
+		}
+
+		return Prepared(NewStatement(handle, WithColumns(columns))), nil
+	}
+
+	params := Parameters{"standard_conforming_strings": "on"}
+	server, err := NewServer(handler, Logger(slogt.New(t)), GlobalParameters(params), Cursors(), Transactions(transactions))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	exec := func(query string) {
+		_, err := conn.Exec(ctx, query, pgx.QueryExecModeSimpleProtocol)
+		require.NoError(t, err)
+	}
+
+	fetch := func(query string) []int32 {
+		rows, err := conn.Query(ctx, query, pgx.QueryExecModeSimpleProtocol)
+		require.NoError(t, err)
+
+		result, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+		require.NoError(t, err)
+		return result
+	}
+
+	exec("BEGIN")
+	exec("DECLARE held CURSOR WITH HOLD FOR SELECT n FROM numbers")
+	require.Equal(t, []int32{1}, fetch("FETCH 1 FROM held"))
+	exec("COMMIT")
+
+	// NOTE: the remaining rows are materialized before the transaction is
+	// committed.
+	require.Equal(t, []string{"begin", "row 1", "row 2", "row 3", "commit"}, transactions.flush())
+	require.Equal(t, []int32{2, 3}, fetch("FETCH ALL FROM held"))
+	require.Equal(t, []string{"begin", "commit"}, transactions.flush())
+
+	_, err = conn.Exec(ctx, "FETCH BACKWARD 1 FROM held", pgx.QueryExecModeSimpleProtocol)
+	require.Error(t, err)
+
+	exec("CLOSE held")
+}
+
+// countingPortalCache counts the executions of the wrapped portal cache.
+type countingPortalCache struct {
+	PortalCache
+	executions atomic.Int32
+}
+
+func (cache *countingPortalCache) Execute(ctx context.Context, name string, limit Limit, reader *buffer.Reader, writer *buffer.Writer) error {
+	cache.executions.Add(1)
+	return cache.PortalCache.Execute(ctx, name, limit, reader, writer)
+}
+
+func TestScrollableCursors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	params := Parameters{"standard_conforming_strings": "on"}
+	config := ScrollableCursorsConfig{
+		Enabled:     true,
+		MemoryLimit: 32,
+		TempDir:     dir,
+	}
+
+	portals := &countingPortalCache{PortalCache: DefaultPortalCacheFn()}
+	server, err := NewServer(cursorHandler, Logger(slogt.New(t)), GlobalParameters(params), ScrollableCursors(config), Portals(func() PortalCache { return portals }))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	exec := func(query string) (string, error) {
+		tag, err := conn.Exec(ctx, query, pgx.QueryExecModeSimpleProtocol)
+		return tag.String(), err
+	}
+
+	fetch := func(query string) ([]int32, string) {
+		rows, err := conn.Query(ctx, query, pgx.QueryExecModeSimpleProtocol)
+		require.NoError(t, err)
+
+		result, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+		require.NoError(t, err)
+		return result, rows.CommandTag().String()
+	}
+
+	_, err = exec("BEGIN")
+	require.NoError(t, err)
+
+	_, err = exec("DECLARE c SCROLL CURSOR FOR SELECT n FROM numbers")
+	require.NoError(t, err)
+
+	rows, tag := fetch("FETCH 3 FROM c")
+	require.Equal(t, []int32{1, 2, 3}, rows)
+	require.Equal(t, "FETCH 3", tag)
+
+	// NOTE: the fetched rows are produced using a single execution.
+	require.Equal(t, int32(1), portals.executions.Load())
+
+	rows, tag = fetch("FETCH PRIOR FROM c")
+	require.Equal(t, []int32{2}, rows)
+	require.Equal(t, "FETCH 1", tag)
+
+	rows, _ = fetch("FETCH RELATIVE 0 FROM c")
+	require.Equal(t, []int32{2}, rows)
+
+	rows, _ = fetch("FETCH ABSOLUTE 7 FROM c")
+	require.Equal(t, []int32{7}, rows)
+
+	// NOTE: rows beyond the memory limit are spilled to a temporary file.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	rows, _ = fetch("FETCH RELATIVE -2 FROM c")
+	require.Equal(t, []int32{5}, rows)
+
+	rows, _ = fetch("FETCH LAST FROM c")
+	require.Equal(t, []int32{10}, rows)
+
+	rows, _ = fetch("FETCH ABSOLUTE -3 FROM c")
+	require.Equal(t, []int32{8}, rows)
+
+	tag, err = exec("MOVE BACKWARD 3 IN c")
+	require.NoError(t, err)
+	require.Equal(t, "MOVE 3", tag)
+
+	rows, _ = fetch("FETCH BACKWARD 10 FROM c")
+	require.Equal(t, []int32{4, 3, 2, 1}, rows)
+
+	rows, _ = fetch("FETCH NEXT FROM c")
+	require.Equal(t, []int32{1}, rows)
+
+	rows, tag = fetch("FETCH ABSOLUTE 11 FROM c")
+	require.Empty(t, rows)
+	require.Equal(t, "FETCH 0", tag)
+
+	rows, _ = fetch("FETCH BACKWARD ALL FROM c")
+	require.Equal(t, []int32{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, rows)
+
+	rows, _ = fetch("FETCH FIRST FROM c")
+	require.Equal(t, []int32{1}, rows)
+
+	_, err = exec("CLOSE c")
+	require.NoError(t, err)
+
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = exec("COMMIT")
+	require.NoError(t, err)
+}
+
+func TestParseCursorMove(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]cursorMove{
+		"FETCH c":                {direction: cursorForward, count: 1},
+		"FETCH NEXT FROM c":      {direction: cursorForward, count: 1},
+		"FETCH 5 c":              {direction: cursorForward, count: 5},
+		"FETCH -5 IN c":          {direction: cursorBackward, count: 5},
+		"FETCH ALL c":            {direction: cursorForward, all: true},
+		"FETCH FORWARD ALL c":    {direction: cursorForward, all: true},
+		"FETCH BACKWARD 2 c":     {direction: cursorBackward, count: 2},
+		"FETCH BACKWARD ALL c":   {direction: cursorBackward, all: true},
+		"FETCH PRIOR c":          {direction: cursorBackward, count: 1},
+		"FETCH FIRST c":          {direction: cursorAbsolute, count: 1},
+		"FETCH LAST c":           {direction: cursorAbsolute, count: -1},
+		"FETCH ABSOLUTE -2 c":    {direction: cursorAbsolute, count: -2},
+		"MOVE RELATIVE 0 FROM c": {direction: cursorRelative, count: 0},
+		`FETCH 2 FROM "c"`:       {direction: cursorForward, count: 2},
+	}
+
+	parse := func(query string) (string, cursorMove, error) {
+		tokens, err := tokenizeStatement(query)
+		require.NoError(t, err)
+		return parseCursorMove(tokens)
+	}
+
+	for query, expected := range tests {
+		t.Run(query, func(t *testing.T) {
+			name, movement, err := parse(query)
+			require.NoError(t, err)
+			require.Equal(t, "c", name)
+			require.Equal(t, expected, movement)
+		})
+	}
+
+	name, _, err := parse(`FETCH 1 FROM "my cur"`)
+	require.NoError(t, err)
+	require.Equal(t, "my cur", name)
+
+	invalid := []string{"FETCH", "FETCH ABSOLUTE c", "FETCH NEXT 5 c", "FETCH many c", `FETCH "next" c`, "FETCH 'c'"}
+	for _, query := range invalid {
+		_, _, err := parse(query)
+		require.Error(t, err, query)
+	}
+}
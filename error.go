@@ -1,6 +1,9 @@
 package wire
 
 import (
+	"fmt"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
 	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
 	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
@@ -32,9 +35,57 @@ func WriteUnterminatedError(writer *buffer.Writer, err error) error {
 		err = writer.ErrorSanitizer(err)
 	}
 
+	return writeErrorFields(writer, types.ServerErrorResponse, psqlerr.Flatten(err))
+}
+
+// WriteNotice writes a NoticeResponse message to the client containing the
+// fields of the given error. The notice severity defaults to NOTICE when no
+// severity has been defined. Notices could be written at any moment and do not
+// affect the state of the connection. Use [Notice] or [NoticeWriter.Notice] to
+// write notices honoring the client_min_messages setting of the session.
+func WriteNotice(writer *buffer.Writer, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	severity, serr := noticeSeverity(err)
+	if serr != nil {
+		return serr
+	}
+
 	desc := psqlerr.Flatten(err)
+	desc.Severity = severity
 
-	writer.Start(types.ServerErrorResponse)
+	if psqlerr.GetCode(err) == codes.Uncategorized {
+		desc.Code = codes.SuccessfulCompletion
+		if desc.Severity == psqlerr.LevelWarning {
+			desc.Code = codes.Warning
+		}
+	}
+
+	return writeErrorFields(writer, types.ServerNoticeResponse, desc)
+}
+
+// noticeSeverity returns the severity of the given notice, defaulting to
+// NOTICE. An error is returned when the severity could not be used by notices.
+func noticeSeverity(err error) (psqlerr.Severity, error) {
+	severity := psqlerr.GetSeverity(err)
+	if severity == "" {
+		severity = psqlerr.LevelNotice
+	}
+
+	switch severity {
+	case psqlerr.LevelError, psqlerr.LevelFatal, psqlerr.LevelPanic:
+		return severity, fmt.Errorf("unexpected notice severity %s", severity)
+	}
+
+	return severity, nil
+}
+
+// writeErrorFields writes the fields of the given error description inside a
+// message of the given type.
+func writeErrorFields(writer *buffer.Writer, t types.ServerMessage, desc psqlerr.Error) error {
+	writer.Start(t)
 
 	writer.AddByte(byte(errFieldSeverity))
 	writer.AddString(string(desc.Severity))
@@ -93,10 +144,12 @@ func (srv *Session) WriteError(writer *buffer.Writer, err error) error {
 		return err
 	}
 
+	srv.failTx()
+
 	if srv.inExtendedQuery {
 		srv.discardUntilSync = true
 		return nil
 	}
 
-	return readyForQuery(writer, types.ServerIdle)
+	return srv.readyForQuery(writer)
 }
@@ -9,3 +9,25 @@ const (
 	// BinaryFormat is an alternative, binary, encoding.
 	BinaryFormat FormatCode = 1
 )
+
+// CopyFormat represents the data format used during a COPY operation.
+type CopyFormat int
+
+const (
+	// CopyText is the default, tab-delimited, text format.
+	CopyText CopyFormat = iota
+	// CopyCSV is the comma separated values format.
+	CopyCSV
+	// CopyBinary is the binary format prefixed with the COPY signature.
+	CopyBinary
+)
+
+// FormatCode returns the format code used to encode the columns of the given
+// copy format.
+func (format CopyFormat) FormatCode() FormatCode {
+	if format == CopyBinary {
+		return BinaryFormat
+	}
+
+	return TextFormat
+}
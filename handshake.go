@@ -1,25 +1,55 @@
 package wire
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log/slog"
 	"maps"
 	"net"
+	"slices"
+	"strings"
 
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
 	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
 )
 
+// sessionCertificatePrefix prefixes the certificate presented to the client
+// stored inside the extra data of TLS session tickets.
+const sessionCertificatePrefix = "psql-wire-certificate:"
+
+// protocolExtensionPrefix is the prefix of startup options requesting a
+// protocol extension.
+const protocolExtensionPrefix = "_pq_."
+
+// Length constraints of the secret key used to cancel queries. Protocol
+// version 3.0 uses 4 byte secret keys, protocol version 3.2 allows secret keys
+// up to 256 bytes.
+const (
+	minSecretKeyLength = 4
+	maxSecretKeyLength = 256
+)
+
 // Handshake performs the connection handshake and returns the connection
 // version and a buffered reader to read incoming messages send by the client.
 func (srv *Server) Handshake(conn net.Conn) (_ net.Conn, version types.Version, reader *buffer.Reader, err error) {
+	_, conn, version, reader, err = srv.handshake(context.Background(), conn)
+	return conn, version, reader, err
+}
+
+// handshake performs the connection handshake. Connection metadata obtained
+// during the handshake, such as the TLS connection state, is set inside the
+// returned context.
+func (srv *Server) handshake(ctx context.Context, conn net.Conn) (_ context.Context, _ net.Conn, version types.Version, reader *buffer.Reader, err error) {
 	reader = buffer.NewReader(srv.logger, conn, srv.BufferedMsgSize)
 	version, err = srv.readVersion(reader)
 	if err != nil {
-		return conn, version, reader, err
+		return ctx, conn, version, reader, err
 	}
 
 	// TODO: support GSS encryption
@@ -32,27 +62,30 @@ func (srv *Server) Handshake(conn net.Conn) (_ net.Conn, version types.Version,
 	if version == types.VersionGSSENC {
 		_, err := conn.Write([]byte{'N'})
 		if err != nil {
-			return conn, version, reader, err
+			return ctx, conn, version, reader, err
 		}
 
-		return srv.Handshake(conn)
+		return srv.handshake(ctx, conn)
 	}
 
-	conn, reader, version, err = srv.potentialConnUpgrade(conn, reader, version)
+	ctx, conn, reader, version, err = srv.potentialConnUpgrade(ctx, conn, reader, version)
 	if err != nil {
-		return conn, version, reader, err
+		return ctx, conn, version, reader, err
 	}
 
 	if version == types.VersionCancel {
 		processID, secretKey, err := srv.readCancelRequest(reader)
 		if err != nil {
-			return conn, version, reader, err
+			return ctx, conn, version, reader, err
 		}
 
 		srv.logger.Debug("Received cancel request")
 
-		if srv.CancelRequest != nil {
-			ctx := context.Background()
+		if srv.QueryCancellation {
+			if !srv.cancelRegistry().cancel(processID, secretKey) {
+				srv.logger.Debug("Cancel request did not match any session")
+			}
+		} else if srv.CancelRequest != nil {
 			err = srv.CancelRequest(ctx, processID, secretKey)
 			if err != nil {
 				srv.logger.Error("Failed to handle cancel request", "err", err)
@@ -61,10 +94,10 @@ func (srv *Server) Handshake(conn net.Conn) (_ net.Conn, version types.Version,
 			srv.logger.Debug("Cancel request received but no handler configured")
 		}
 
-		return conn, version, reader, nil
+		return ctx, conn, version, reader, nil
 	}
 
-	return conn, version, reader, nil
+	return ctx, conn, version, reader, nil
 }
 
 // readVersion reads the start-up protocol version (uint32) and the
@@ -86,26 +119,62 @@ func (srv *Server) readVersion(reader *buffer.Reader) (_ types.Version, err erro
 
 // readCancelRequest reads the cancel request parameters (processID and secretKey)
 // from the client connection. The full cancel request format is:
-// Int32(16) - Length of message contents in bytes, including self
+// Int32 - Length of message contents in bytes, including self
 // Int32(80877102) - The cancel request code (already read as version)
 // Int32 - The process ID of the target backend
-// Int32 - The secret key for the target backend
+// Byten - The secret key for the target backend
 // The first two fields are already handled by readVersion, so this only needs
-// to read the last two fields.
-func (srv *Server) readCancelRequest(reader *buffer.Reader) (processID int32, secretKey int32, err error) {
+// to read the last two fields. The secret key is 4 bytes long for protocol
+// version 3.0 and could be up to 256 bytes long for protocol version 3.2.
+func (srv *Server) readCancelRequest(reader *buffer.Reader) (processID int32, secretKey []byte, err error) {
 	processID, err = reader.GetInt32()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read process ID from cancel request: %w", err)
+		return 0, nil, fmt.Errorf("failed to read process ID from cancel request: %w", err)
 	}
 
-	secretKey, err = reader.GetInt32()
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read secret key from cancel request: %w", err)
+	if len(reader.Msg) < minSecretKeyLength || len(reader.Msg) > maxSecretKeyLength {
+		return 0, nil, fmt.Errorf("invalid secret key length in cancel request: %d", len(reader.Msg))
 	}
 
+	secretKey = bytes.Clone(reader.Msg)
 	return processID, secretKey, nil
 }
 
+// negotiateVersion returns the protocol version used for the connection based
+// on the protocol version requested by the client. Clients requesting a newer
+// minor version than supported by the server continue using the newest
+// version supported by the server. Clients requesting a minor version in
+// between the supported versions (e.g. 3.1) continue using the newest
+// supported version below the requested version. An error is returned when
+// the major version is not supported.
+func negotiateVersion(version types.Version) (types.Version, error) {
+	if version.Major() != types.Version30.Major() {
+		err := fmt.Errorf("unsupported frontend protocol %d.%d: server supports %d.%d to %d.%d",
+			version.Major(), version.Minor(),
+			types.Version30.Major(), types.Version30.Minor(),
+			types.Version32.Major(), types.Version32.Minor(),
+		)
+
+		return version, psqlerr.WithSeverity(psqlerr.WithCode(err, codes.FeatureNotSupported), psqlerr.LevelFatal)
+	}
+
+	if version >= types.Version32 {
+		return types.Version32, nil
+	}
+
+	return types.Version30, nil
+}
+
+// validSecretKey checks whether the given secret key could be used as backend
+// key data for the given protocol version.
+func validSecretKey(version types.Version, secretKey []byte) bool {
+	if version < types.Version32 {
+		return len(secretKey) == minSecretKeyLength
+	}
+
+	return len(secretKey) >= minSecretKeyLength && len(secretKey) <= maxSecretKeyLength
+}
+
 // readyForQuery indicates that the server is ready to receive queries.
 // The given server status is included inside the message to indicate the server
 // status. This message should be written when a command cycle has been completed.
@@ -117,16 +186,19 @@ func readyForQuery(writer *buffer.Writer, status types.ServerStatus) error {
 
 // readParameters reads the key/value connection parameters send by the client and
 // The read parameters will be set inside the given context. A new context containing
-// the consumed parameters will be returned.
-func (srv *Server) readClientParameters(ctx context.Context, reader *buffer.Reader) (_ context.Context, err error) {
+// the consumed parameters will be returned. Startup options requesting protocol
+// extensions are not included inside the connection parameters but returned
+// separately, keyed by their name without the _pq_. prefix.
+func (srv *Server) readClientParameters(ctx context.Context, reader *buffer.Reader) (_ context.Context, options map[string]string, err error) {
 	meta := make(Parameters)
+	options = make(map[string]string)
 
 	srv.logger.Debug("reading client parameters")
 
 	for {
 		key, err := reader.GetString()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// an empty key indicates the end of the connection parameters
@@ -136,14 +208,64 @@ func (srv *Server) readClientParameters(ctx context.Context, reader *buffer.Read
 
 		value, err := reader.GetString()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		if name, ok := strings.CutPrefix(key, protocolExtensionPrefix); ok {
+			srv.logger.Debug("protocol extension", slog.String("key", key), slog.String("value", value))
+			options[name] = value
+			continue
 		}
 
 		srv.logger.Debug("client parameter", slog.String("key", key), slog.String("value", value))
 		meta[ParameterStatus(key)] = value
 	}
 
-	return setClientParameters(ctx, meta), nil
+	return setClientParameters(ctx, meta), options, nil
+}
+
+// negotiateProtocol sets the requested protocol extensions registered by the
+// server inside the given context. A NegotiateProtocolVersion message is
+// written to the client when the client requested a minor protocol version
+// which is not supported or when the client requested protocol extensions
+// which are not registered.
+func (srv *Server) negotiateProtocol(ctx context.Context, writer *buffer.Writer, requested, version types.Version, options map[string]string) (context.Context, error) {
+	extensions := make(map[string]string, len(options))
+	unrecognized := make([]string, 0, len(options))
+
+	for name, value := range options {
+		if !slices.Contains(srv.ProtocolExtensions, name) {
+			unrecognized = append(unrecognized, protocolExtensionPrefix+name)
+			continue
+		}
+
+		extensions[name] = value
+	}
+
+	ctx = setProtocolExtensions(ctx, extensions)
+	if requested == version && len(unrecognized) == 0 {
+		return ctx, nil
+	}
+
+	slices.Sort(unrecognized)
+
+	srv.logger.Debug("negotiating protocol version", slog.Any("version", version), slog.Any("unrecognized", unrecognized))
+	return ctx, writeNegotiateProtocolVersion(writer, version, unrecognized)
+}
+
+// writeNegotiateProtocolVersion writes the newest protocol version supported by
+// the server and the protocol extension options which are not recognized to
+// the client.
+func writeNegotiateProtocolVersion(writer *buffer.Writer, version types.Version, unrecognized []string) error {
+	writer.Start(types.ServerNegotiateProtocol)
+	writer.AddInt32(int32(version))
+	writer.AddInt32(int32(len(unrecognized)))
+	for _, option := range unrecognized {
+		writer.AddString(option)
+		writer.AddNullTerminate()
+	}
+
+	return writer.End()
 }
 
 // writeParameters writes the server parameters such as client encoding to the client.
@@ -171,12 +293,7 @@ func (srv *Server) writeParameters(ctx context.Context, writer *buffer.Writer, p
 	for key, value := range params {
 		srv.logger.Debug("server parameter", slog.String("key", string(key)), slog.String("value", value))
 
-		writer.Start(types.ServerParameterStatus)
-		writer.AddString(string(key))
-		writer.AddNullTerminate()
-		writer.AddString(value)
-		writer.AddNullTerminate()
-		err = writer.End()
+		err = writeParameterStatus(writer, key, value)
 		if err != nil {
 			return ctx, err
 		}
@@ -185,17 +302,29 @@ func (srv *Server) writeParameters(ctx context.Context, writer *buffer.Writer, p
 	return setServerParameters(ctx, params), nil
 }
 
+// writeParameterStatus writes a ParameterStatus message reporting the current
+// value of the given parameter to the client.
+func writeParameterStatus(writer *buffer.Writer, key ParameterStatus, value string) error {
+	writer.Start(types.ServerParameterStatus)
+	writer.AddString(string(key))
+	writer.AddNullTerminate()
+	writer.AddString(value)
+	writer.AddNullTerminate()
+	return writer.End()
+}
+
 // potentialConnUpgrade potentially upgrades the given connection using TLS
 // if the client requests for it. The connection upgrade is ignored if the
-// server does not support a secure connection.
-func (srv *Server) potentialConnUpgrade(conn net.Conn, reader *buffer.Reader, version types.Version) (_ net.Conn, _ *buffer.Reader, _ types.Version, err error) {
+// server does not support a secure connection. The state of the upgraded
+// connection is set inside the returned context.
+func (srv *Server) potentialConnUpgrade(ctx context.Context, conn net.Conn, reader *buffer.Reader, version types.Version) (_ context.Context, _ net.Conn, _ *buffer.Reader, _ types.Version, err error) {
 	if version != types.VersionSSLRequest {
 		if srv.ClientAuth == tls.RequireAndVerifyClientCert {
 			srv.logger.Warn("client is requesting nil TLS, but the server mandates TLS")
-			return conn, reader, version, fmt.Errorf("client is requesting nil TLS, but the server mandates TLS")
+			return ctx, conn, reader, version, fmt.Errorf("client is requesting nil TLS, but the server mandates TLS")
 		}
 
-		return conn, reader, version, nil
+		return ctx, conn, reader, version, nil
 	}
 
 	srv.logger.Debug("attempting to upgrade the client to a TLS connection")
@@ -203,30 +332,133 @@ func (srv *Server) potentialConnUpgrade(conn net.Conn, reader *buffer.Reader, ve
 	if srv.TLSConfig == nil || len(srv.TLSConfig.Certificates) == 0 {
 		if srv.ClientAuth == tls.RequireAndVerifyClientCert {
 			srv.logger.Warn("server mandates TLS, but does not possess the requisite certificates")
-			return conn, reader, version, fmt.Errorf("server mandates TLS, but does not possess the requisite certificates")
+			return ctx, conn, reader, version, fmt.Errorf("server mandates TLS, but does not possess the requisite certificates")
 		}
 
 		srv.logger.Debug("no TLS certificates available continuing with a insecure connection")
-		return srv.sslUnsupported(conn, reader, version)
+		conn, reader, version, err = srv.sslUnsupported(conn, reader, version)
+		return ctx, conn, reader, version, err
 	}
 
 	_, err = conn.Write(sslSupported)
 	if err != nil {
-		return conn, reader, version, err
+		return ctx, conn, reader, version, err
+	}
+
+	// NOTE: the certificate presented to the client is recorded in order to
+	// expose it to authentication strategies making use of channel binding.
+	// The certificates are removed from the cloned configuration to ensure that
+	// the certificate selection is always performed through GetCertificate.
+	var presented *tls.Certificate
+	config := srv.TLSConfig.Clone()
+	config.Certificates = nil
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		certificate, err := selectCertificate(srv.TLSConfig, hello)
+		presented = certificate
+		return certificate, err
+	}
+
+	// NOTE: GetCertificate is not called when a session is resumed. The
+	// presented certificate is therefore stored inside the session tickets
+	// and restored once a session is resumed.
+	config.WrapSession = func(state tls.ConnectionState, session *tls.SessionState) ([]byte, error) {
+		if presented != nil && len(presented.Certificate) > 0 {
+			session.Extra = append(session.Extra, append([]byte(sessionCertificatePrefix), presented.Certificate[0]...))
+		}
+
+		if srv.TLSConfig.WrapSession != nil {
+			return srv.TLSConfig.WrapSession(state, session)
+		}
+
+		return config.EncryptTicket(state, session)
+	}
+
+	config.UnwrapSession = func(identity []byte, state tls.ConnectionState) (session *tls.SessionState, err error) {
+		if srv.TLSConfig.UnwrapSession != nil {
+			session, err = srv.TLSConfig.UnwrapSession(identity, state)
+		} else {
+			session, err = config.DecryptTicket(identity, state)
+		}
+
+		if session == nil || err != nil {
+			return session, err
+		}
+
+		for index, extra := range session.Extra {
+			if !bytes.HasPrefix(extra, []byte(sessionCertificatePrefix)) {
+				continue
+			}
+
+			// NOTE: the certificate is removed to preserve the extra session
+			// data of the configured unwrap function during a round-trip.
+			presented = &tls.Certificate{Certificate: [][]byte{extra[len(sessionCertificatePrefix):]}}
+			session.Extra = slices.Delete(session.Extra, index, index+1)
+			break
+		}
+
+		return session, nil
+	}
+
+	// NOTE: the server client authentication type is applied unless the TLS
+	// configuration defines its own client authentication type.
+	if config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = srv.ClientAuth
 	}
 
 	// NOTE: initialize the TLS connection and construct a new buffered
 	// reader for the constructed TLS connection.
-	conn = tls.Server(conn, srv.TLSConfig)
+	secure := tls.Server(conn, config)
+	conn = secure
 	reader = buffer.NewReader(srv.logger, conn, srv.BufferedMsgSize)
 
 	version, err = srv.readVersion(reader)
 	if err != nil {
-		return conn, reader, version, err
+		return ctx, conn, reader, version, err
+	}
+
+	var leaf *x509.Certificate
+	if presented != nil && len(presented.Certificate) > 0 {
+		leaf = presented.Leaf
+		if leaf == nil {
+			leaf, err = x509.ParseCertificate(presented.Certificate[0])
+			if err != nil {
+				return ctx, conn, reader, version, err
+			}
+		}
 	}
 
+	ctx = setTLSConnectionState(ctx, secure.ConnectionState(), leaf)
+
 	srv.logger.Debug("connection has been upgraded successfully")
-	return conn, reader, version, err
+	return ctx, conn, reader, version, err
+}
+
+// selectCertificate selects the certificate presented to the client for the
+// given TLS configuration. The selection mirrors the behaviour of the
+// [crypto/tls] package when no GetCertificate function has been configured.
+func selectCertificate(config *tls.Config, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if config.GetCertificate != nil && (len(config.Certificates) == 0 || hello.ServerName != "") {
+		certificate, err := config.GetCertificate(hello)
+		if certificate != nil || err != nil {
+			return certificate, err
+		}
+	}
+
+	if len(config.Certificates) == 0 {
+		return nil, errors.New("tls: no certificates configured")
+	}
+
+	if len(config.Certificates) == 1 {
+		return &config.Certificates[0], nil
+	}
+
+	for index := range config.Certificates {
+		if hello.SupportsCertificate(&config.Certificates[index]) == nil {
+			return &config.Certificates[index], nil
+		}
+	}
+
+	return &config.Certificates[0], nil
 }
 
 // sslUnsupported announces to the PostgreSQL client that we are unable to
@@ -0,0 +1,148 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// HBAConnectionType represents the type of client connection matched by a
+// host-based authentication rule.
+type HBAConnectionType string
+
+// Connection types matched by host-based authentication rules. The types
+// mirror the connection types of the PostgreSQL pg_hba.conf file.
+// https://www.postgresql.org/docs/current/auth-pg-hba-conf.html
+const (
+	// HBALocal matches connections made over Unix domain sockets.
+	HBALocal HBAConnectionType = "local"
+	// HBAHost matches TCP/IP connections, both with and without TLS.
+	HBAHost HBAConnectionType = "host"
+	// HBAHostSSL matches TCP/IP connections upgraded to TLS.
+	HBAHostSSL HBAConnectionType = "hostssl"
+	// HBAHostNoSSL matches TCP/IP connections not using TLS.
+	HBAHostNoSSL HBAConnectionType = "hostnossl"
+)
+
+// hbaAll is the keyword used to match all databases or users.
+const hbaAll = "all"
+
+// HBARule represents a single host-based authentication rule. A rule decides
+// which authentication strategy applies to the connections it matches.
+type HBARule struct {
+	// Type is the type of connection matched by the rule.
+	Type HBAConnectionType
+	// Databases contains the database names matched by the rule. All
+	// databases are matched when empty or when containing "all".
+	Databases []string
+	// Users contains the user names matched by the rule. All users are
+	// matched when empty or when containing "all".
+	Users []string
+	// Address is the client address range matched by the rule. All addresses
+	// are matched when not set. The address is ignored for local connections.
+	Address netip.Prefix
+	// Auth is the authentication strategy used to authenticate matched
+	// connections.
+	Auth AuthStrategy
+	// Trust allows matched connections without authentication.
+	Trust bool
+	// Reject rejects matched connections unconditionally.
+	Reject bool
+}
+
+// validate checks whether the given rule defines a known connection type and
+// exactly one of an authentication strategy, trust or reject.
+func (rule HBARule) validate() error {
+	switch rule.Type {
+	case HBALocal, HBAHost, HBAHostSSL, HBAHostNoSSL:
+	default:
+		return fmt.Errorf("unknown host-based authentication connection type: %q", rule.Type)
+	}
+
+	methods := 0
+	for _, set := range []bool{rule.Auth != nil, rule.Trust, rule.Reject} {
+		if set {
+			methods++
+		}
+	}
+
+	if methods != 1 {
+		return errors.New("host-based authentication rules require exactly one of an authentication strategy, trust or reject")
+	}
+
+	return nil
+}
+
+// matches checks whether the given rule matches the given client connection.
+func (rule HBARule) matches(ctx context.Context, database, username string) bool {
+	addr := RemoteAddress(ctx)
+	_, local := addr.(*net.UnixAddr)
+	secure := TLSConnectionState(ctx) != nil
+
+	switch rule.Type {
+	case HBALocal:
+		if !local {
+			return false
+		}
+	case HBAHost:
+		if local {
+			return false
+		}
+	case HBAHostSSL:
+		if local || !secure {
+			return false
+		}
+	case HBAHostNoSSL:
+		if local || secure {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if !hbaMatchName(rule.Databases, database) || !hbaMatchName(rule.Users, username) {
+		return false
+	}
+
+	if local || !rule.Address.IsValid() {
+		return true
+	}
+
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	return ok && rule.Address.Contains(ip.Unmap())
+}
+
+func hbaMatchName(names []string, name string) bool {
+	return len(names) == 0 || slices.Contains(names, hbaAll) || slices.Contains(names, name)
+}
+
+// hostBasedAuth returns the authentication strategy of the first host-based
+// authentication rule matching the given connection. A nil strategy is
+// returned for trusted connections. An error is returned when the connection
+// is rejected, no rule matches the connection or the matching rule is invalid.
+func (srv *Server) hostBasedAuth(ctx context.Context) (AuthStrategy, error) {
+	params := ClientParameters(ctx)
+	database, username := params[ParamDatabase], params[ParamUsername]
+
+	for _, rule := range srv.HostBasedAuth {
+		if !rule.matches(ctx, database, username) {
+			continue
+		}
+
+		if rule.Reject || rule.validate() != nil {
+			return nil, newErrInvalidAuthorization("host-based authentication rejects connection for host %q, user %q, database %q", remoteHost(ctx), username, database)
+		}
+
+		return rule.Auth, nil
+	}
+
+	return nil, newErrInvalidAuthorization("no host-based authentication rule for host %q, user %q, database %q", remoteHost(ctx), username, database)
+}
@@ -0,0 +1,164 @@
+package wire
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHBARuleMatches(t *testing.T) {
+	t.Parallel()
+
+	tcp := setRemoteAddress(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5432})
+	secure := setTLSConnectionState(tcp, tls.ConnectionState{}, nil)
+	local := setRemoteAddress(context.Background(), &net.UnixAddr{Name: "/tmp/.s.PGSQL.5432", Net: "unix"})
+
+	type test struct {
+		rule     HBARule
+		ctx      context.Context
+		database string
+		user     string
+		expected bool
+	}
+
+	tests := map[string]test{
+		"host": {
+			rule:     HBARule{Type: HBAHost},
+			ctx:      tcp,
+			expected: true,
+		},
+		"host tls": {
+			rule:     HBARule{Type: HBAHost},
+			ctx:      secure,
+			expected: true,
+		},
+		"host local": {
+			rule:     HBARule{Type: HBAHost},
+			ctx:      local,
+			expected: false,
+		},
+		"hostssl": {
+			rule:     HBARule{Type: HBAHostSSL},
+			ctx:      tcp,
+			expected: false,
+		},
+		"hostnossl": {
+			rule:     HBARule{Type: HBAHostNoSSL},
+			ctx:      secure,
+			expected: false,
+		},
+		"local": {
+			rule:     HBARule{Type: HBALocal, Address: netip.MustParsePrefix("127.0.0.1/32")},
+			ctx:      local,
+			expected: true,
+		},
+		"address": {
+			rule:     HBARule{Type: HBAHost, Address: netip.MustParsePrefix("10.0.0.0/8")},
+			ctx:      tcp,
+			expected: true,
+		},
+		"address mismatch": {
+			rule:     HBARule{Type: HBAHost, Address: netip.MustParsePrefix("192.168.0.0/16")},
+			ctx:      tcp,
+			expected: false,
+		},
+		"database": {
+			rule:     HBARule{Type: HBAHost, Databases: []string{"app"}},
+			ctx:      tcp,
+			database: "app",
+			expected: true,
+		},
+		"database mismatch": {
+			rule:     HBARule{Type: HBAHost, Databases: []string{"app"}},
+			ctx:      tcp,
+			database: "postgres",
+			expected: false,
+		},
+		"all users": {
+			rule:     HBARule{Type: HBAHost, Users: []string{"all"}},
+			ctx:      tcp,
+			user:     "john",
+			expected: true,
+		},
+		"user mismatch": {
+			rule:     HBARule{Type: HBAHost, Users: []string{"admin"}},
+			ctx:      tcp,
+			user:     "john",
+			expected: false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.expected, test.rule.matches(test.ctx, test.database, test.user))
+		})
+	}
+}
+
+func TestHostBasedAuthentication(t *testing.T) {
+	t.Parallel()
+
+	password := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, password == "secret", nil
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler,
+		Logger(slogt.New(t)),
+		HostBasedAuthentication(
+			HBARule{Type: HBAHost, Users: []string{"blocked"}, Reject: true},
+			HBARule{Type: HBAHost, Users: []string{"admin"}, Auth: ClearTextPassword(password)},
+			HBARule{Type: HBAHost, Databases: []string{"app"}, Address: netip.MustParsePrefix("127.0.0.0/8"), Trust: true},
+		),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T, user, database string) error {
+		ctx := context.Background()
+		connstr := fmt.Sprintf("postgres://%s@%s:%d/%s?sslmode=disable", user, address.IP, address.Port, database)
+		conn, err := pgx.Connect(ctx, connstr)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close(ctx)
+	}
+
+	require.NoError(t, connect(t, "john", "app"))
+	require.NoError(t, connect(t, "admin:secret", "postgres"))
+	require.ErrorContains(t, connect(t, "admin:wrong", "postgres"), "invalid username/password")
+	require.ErrorContains(t, connect(t, "blocked", "app"), "rejects connection")
+	require.ErrorContains(t, connect(t, "john", "postgres"), "no host-based authentication rule")
+
+	_, err = NewServer(handler, HostBasedAuthentication(HBARule{Type: "unknown", Trust: true}))
+	require.Error(t, err)
+
+	_, err = NewServer(handler, HostBasedAuthentication(HBARule{Type: HBAHost}))
+	require.Error(t, err)
+
+	_, err = NewServer(handler, HostBasedAuthentication(HBARule{Type: HBAHost, Trust: true, Reject: true}))
+	require.Error(t, err)
+
+	// NOTE: rules set directly on the server without an authentication
+	// strategy, trust or reject reject the matched connections.
+	server, err = NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	server.HostBasedAuth = []HBARule{{Type: HBAHost}}
+	address = TListenAndServe(t, server)
+	require.ErrorContains(t, connect(t, "john", "app"), "rejects connection")
+}
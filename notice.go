@@ -0,0 +1,117 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// ParamClientMinMessages controls which notice severities are send to the
+// client. Notices below the configured level are discarded. Defaults to
+// NOTICE.
+const ParamClientMinMessages ParameterStatus = "client_min_messages"
+
+// noticeLevels contains the rank of the notice severities. Notices are send to
+// the client when their rank is equal or above the rank of the configured
+// client_min_messages level. INFO notices are always send to the client.
+var noticeLevels = map[psqlerr.Severity]int{
+	psqlerr.LevelDebug:   0,
+	psqlerr.LevelLog:     1,
+	psqlerr.LevelNotice:  2,
+	psqlerr.LevelWarning: 3,
+	psqlerr.LevelError:   4,
+}
+
+// clientMinMessages returns the client_min_messages level of the connection
+// inside the given context. The session settings are used when available,
+// otherwise the client parameters take precedence over the server parameters.
+func clientMinMessages(ctx context.Context) psqlerr.Severity {
+	value, has := GetSetting(ctx, string(ParamClientMinMessages))
+	if _, ok := GetSession(ctx); !ok {
+		value, has = ClientParameters(ctx)[ParamClientMinMessages]
+		if !has {
+			value, has = ServerParameters(ctx)[ParamClientMinMessages]
+		}
+	}
+
+	if !has {
+		return psqlerr.LevelNotice
+	}
+
+	level := psqlerr.Severity(strings.ToUpper(value))
+	if strings.HasPrefix(string(level), string(psqlerr.LevelDebug)) {
+		// NOTE: the debug1 to debug5 levels are treated as a single level.
+		return psqlerr.LevelDebug
+	}
+
+	if _, ok := noticeLevels[level]; !ok {
+		return psqlerr.LevelNotice
+	}
+
+	return level
+}
+
+// includeNotice returns whether the severity of the given notice is included
+// by the client_min_messages level of the connection inside the given context.
+func includeNotice(ctx context.Context, err error) bool {
+	severity := psqlerr.GetSeverity(err)
+	if severity == "" {
+		severity = psqlerr.LevelNotice
+	}
+
+	rank, has := noticeLevels[severity]
+	return !has || rank >= noticeLevels[clientMinMessages(ctx)]
+}
+
+// writeNotice writes the given notice to the client when its severity is
+// included by the client_min_messages level of the connection inside the given
+// context.
+func writeNotice(ctx context.Context, writer *buffer.Writer, err error) error {
+	if !includeNotice(ctx, err) {
+		return nil
+	}
+
+	return WriteNotice(writer, err)
+}
+
+// Notice sends a notice with the given severity and message to the client of
+// the session inside the given context, equivalent to RAISE NOTICE. Notices
+// are queued and written by the session in between the messages it writes,
+// notices send while a statement is being executed are written in between the
+// rows of the statement. Notices could safely be send from any goroutine,
+// notices send while the session is idle are written by the delivery routine
+// of the session. Notices are discarded when the severity is below the
+// client_min_messages level of the session. Use [NoticeWriter.Notice] to send
+// notices containing additional fields such as a detail or hint.
+func Notice(ctx context.Context, severity psqlerr.Severity, message string) error {
+	return notice(ctx, psqlerr.WithSeverity(errors.New(message), severity))
+}
+
+// notice queues the given error as a notice for the client of the session
+// inside the given context. Notices are never written directly, preventing
+// them from being interleaved with the messages written by the command being
+// handled.
+func notice(ctx context.Context, err error) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	if session.writer == nil {
+		return ErrClosedWriter
+	}
+
+	_, serr := noticeSeverity(err)
+	if serr != nil {
+		return serr
+	}
+
+	if includeNotice(ctx, err) {
+		session.enqueueNotice(err)
+	}
+
+	return nil
+}
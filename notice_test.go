@@ -0,0 +1,309 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noticeHandler returns a parse function writing notices in between the rows
+// of the returned statement. Queries prefixed with LEGACY write a deprecation
+// warning while being parsed.
+func noticeHandler(ctx context.Context, query string) (PreparedStatements, error) {
+	if strings.HasPrefix(query, "LEGACY") {
+		err := Notice(ctx, psqlerr.LevelWarning, "legacy syntax is deprecated")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	columns := Columns{
+		{
+			Table: 0,
+			Name:  "n",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+	}
+
+	handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+		err := writer.Row([]any{1})
+		if err != nil {
+			return err
+		}
+
+		err = writer.(NoticeWriter).Notice(psqlerr.WithHint(psqlerr.WithSeverity(errors.New("halfway there"), psqlerr.LevelWarning), "keep going"))
+		if err != nil {
+			return err
+		}
+
+		err = Notice(ctx, psqlerr.LevelNotice, "progress")
+		if err != nil {
+			return err
+		}
+
+		err = Notice(ctx, psqlerr.LevelDebug, "debugging")
+		if err != nil {
+			return err
+		}
+
+		err = writer.Row([]any{2})
+		if err != nil {
+			return err
+		}
+
+		return writer.Complete("SELECT 2")
+	}
+
+	return Prepared(NewStatement(handle, WithColumns(columns))), nil
+}
+
+func TestNotices(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(noticeHandler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T, params string) (*pgconn.PgConn, func() []*pgconn.Notice) {
+		config, err := pgconn.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=disable%s", address.IP, address.Port, params))
+		require.NoError(t, err)
+
+		var mu sync.Mutex
+		var notices []*pgconn.Notice
+		config.OnNotice = func(conn *pgconn.PgConn, notice *pgconn.Notice) {
+			mu.Lock()
+			defer mu.Unlock()
+			notices = append(notices, notice)
+		}
+
+		conn, err := pgconn.ConnectConfig(context.Background(), config)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close(context.Background()) //nolint:errcheck
+		})
+
+		return conn, func() []*pgconn.Notice {
+			mu.Lock()
+			defer mu.Unlock()
+			return notices
+		}
+	}
+
+	t.Run("simple query", func(t *testing.T) {
+		conn, notices := connect(t, "")
+
+		results, err := conn.Exec(context.Background(), "SELECT n").ReadAll()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Len(t, results[0].Rows, 2)
+
+		received := notices()
+		require.Len(t, received, 2)
+
+		assert.Equal(t, "WARNING", received[0].Severity)
+		assert.Equal(t, "01000", received[0].Code)
+		assert.Equal(t, "halfway there", received[0].Message)
+		assert.Equal(t, "keep going", received[0].Hint)
+
+		assert.Equal(t, "NOTICE", received[1].Severity)
+		assert.Equal(t, "00000", received[1].Code)
+		assert.Equal(t, "progress", received[1].Message)
+	})
+
+	t.Run("extended query", func(t *testing.T) {
+		conn, notices := connect(t, "")
+
+		result := conn.ExecParams(context.Background(), "SELECT n", nil, nil, nil, nil).Read()
+		require.NoError(t, result.Err)
+		assert.Len(t, result.Rows, 2)
+		assert.Len(t, notices(), 2)
+	})
+
+	t.Run("between statements", func(t *testing.T) {
+		conn, notices := connect(t, "")
+
+		_, err := conn.Exec(context.Background(), "LEGACY SELECT n").ReadAll()
+		require.NoError(t, err)
+
+		received := notices()
+		require.Len(t, received, 3)
+		assert.Equal(t, "legacy syntax is deprecated", received[0].Message)
+	})
+
+	t.Run("client min messages", func(t *testing.T) {
+		conn, notices := connect(t, "&client_min_messages=warning")
+
+		_, err := conn.Exec(context.Background(), "SELECT n").ReadAll()
+		require.NoError(t, err)
+
+		received := notices()
+		require.Len(t, received, 1)
+		assert.Equal(t, "WARNING", received[0].Severity)
+	})
+
+	t.Run("debug", func(t *testing.T) {
+		conn, notices := connect(t, "&client_min_messages=debug1")
+
+		_, err := conn.Exec(context.Background(), "SELECT n").ReadAll()
+		require.NoError(t, err)
+		assert.Len(t, notices(), 3)
+	})
+}
+
+func TestWriteNotice(t *testing.T) {
+	t.Parallel()
+
+	t.Run("error severity", func(t *testing.T) {
+		writer := buffer.NewWriter(slogt.New(t), &bytes.Buffer{})
+		err := WriteNotice(writer, psqlerr.WithSeverity(errors.New("unexpected"), psqlerr.LevelError))
+		require.Error(t, err)
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		err := Notice(context.Background(), psqlerr.LevelNotice, "message")
+		require.ErrorIs(t, err, ErrNoSession)
+	})
+}
+
+func TestBackgroundNotice(t *testing.T) {
+	t.Parallel()
+
+	parsed := make(chan context.Context, 1)
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		select {
+		case parsed <- ctx:
+		default:
+		}
+
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	config, err := pgconn.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+
+	notices := make(chan *pgconn.Notice, 1)
+	config.OnNotice = func(conn *pgconn.PgConn, notice *pgconn.Notice) {
+		notices <- notice
+	}
+
+	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	_, err = conn.Exec(context.Background(), "SELECT 1").ReadAll()
+	require.NoError(t, err)
+
+	// NOTE: notices send once the command has completed are queued and
+	// written by the delivery routine of the session.
+	ctx := <-parsed
+	require.NoError(t, Notice(ctx, psqlerr.LevelNotice, "finished in the background"))
+
+	// NOTE: the notice is received by the client while reading the results of
+	// the following queries.
+	for attempt := 0; ; attempt++ {
+		require.Less(t, attempt, 10, "notice not received")
+
+		_, err = conn.Exec(context.Background(), "SELECT 1").ReadAll()
+		require.NoError(t, err)
+
+		select {
+		case notice := <-notices:
+			assert.Equal(t, "finished in the background", notice.Message)
+			return
+		default:
+		}
+	}
+}
+
+func TestConcurrentNotices(t *testing.T) {
+	t.Parallel()
+
+	const count = 100
+
+	columns := Columns{
+		{
+			Table: 0,
+			Name:  "n",
+			Oid:   pgtype.Int4OID,
+			Width: 4,
+		},
+	}
+
+	handler := func(ctx context.Context, query string) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			// NOTE: notices send from a routine started by the handler are
+			// written in between the rows without interleaving the messages.
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for index := range count {
+					_ = Notice(ctx, psqlerr.LevelNotice, fmt.Sprintf("notice %d", index))
+				}
+			}()
+
+			for index := range count {
+				err := writer.Row([]any{index})
+				if err != nil {
+					return err
+				}
+			}
+
+			wg.Wait()
+			return writer.Complete(fmt.Sprintf("SELECT %d", count))
+		}, WithColumns(columns))), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	config, err := pgconn.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var notices []string
+	config.OnNotice = func(conn *pgconn.PgConn, notice *pgconn.Notice) {
+		mu.Lock()
+		defer mu.Unlock()
+		notices = append(notices, notice.Message)
+	}
+
+	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	results, err := conn.Exec(context.Background(), "SELECT n").ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Len(t, results[0].Rows, count)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, notices, count)
+	for index, notice := range notices {
+		assert.Equal(t, fmt.Sprintf("notice %d", index), notice)
+	}
+}
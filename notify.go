@@ -0,0 +1,320 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// maxNotificationPayload is the maximum length of a notification payload in
+// bytes, matching the default limit of Postgres.
+const maxNotificationPayload = 8000
+
+// ErrNotificationHubNotConfigured is returned whenever a session subscribes to
+// a notification hub which has not been configured on its server.
+var ErrNotificationHubNotConfigured = errors.New("the notification hub has not been configured on the server of the given session")
+
+// newErrInvalidChannel is returned whenever an empty channel name is used.
+func newErrInvalidChannel() error {
+	err := errors.New("channel name cannot be empty")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.InvalidParameterValue), psqlerr.LevelError)
+}
+
+// newErrPayloadTooLong is returned whenever a notification payload exceeds the
+// maximum payload length.
+func newErrPayloadTooLong() error {
+	err := errors.New("payload string too long")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.InvalidParameterValue), psqlerr.LevelError)
+}
+
+// Notification represents an asynchronous notification published on a
+// channel.
+type Notification struct {
+	// ProcessID is the process ID of the notifying session. The process ID is
+	// zero when the notification has not been published from within a session
+	// or when no backend key data has been send to the notifying client.
+	ProcessID int32
+	Channel   string
+	Payload   string
+}
+
+// NotificationHub delivers notifications published on a channel to all
+// sessions listening on the given channel. Handlers subscribe sessions on
+// LISTEN and unsubscribe them on UNLISTEN. Notifications are delivered as
+// asynchronous [NotificationResponse] messages whenever the session is idle
+// and not inside a transaction block. The hub has to be configured on the
+// server using the [Notifications] option.
+//
+// [NotificationResponse]: https://www.postgresql.org/docs/current/protocol-message-formats.html#PROTOCOL-MESSAGE-FORMATS-NOTIFICATIONRESPONSE
+type NotificationHub struct {
+	mu       sync.Mutex
+	channels map[string]map[*Session]struct{}
+}
+
+// NewNotificationHub constructs a new, empty, notification hub.
+func NewNotificationHub() *NotificationHub {
+	return &NotificationHub{
+		channels: make(map[string]map[*Session]struct{}),
+	}
+}
+
+// Listen subscribes the session inside the given context to the given
+// channel. Subscribing to a channel multiple times has no effect.
+func (hub *NotificationHub) Listen(ctx context.Context, channel string) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	if session.Notifications != hub {
+		return ErrNotificationHubNotConfigured
+	}
+
+	if channel == "" {
+		return newErrInvalidChannel()
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	sessions, has := hub.channels[channel]
+	if !has {
+		sessions = make(map[*Session]struct{})
+		hub.channels[channel] = sessions
+	}
+
+	sessions[session] = struct{}{}
+	return nil
+}
+
+// Unlisten unsubscribes the session inside the given context from the given
+// channel. Unsubscribing from a channel which the session is not listening on
+// has no effect.
+func (hub *NotificationHub) Unlisten(ctx context.Context, channel string) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.unlisten(session, channel)
+	return nil
+}
+
+// UnlistenAll unsubscribes the session inside the given context from all
+// channels.
+func (hub *NotificationHub) UnlistenAll(ctx context.Context) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	hub.remove(session)
+	return nil
+}
+
+// Channels returns the channels the session inside the given context is
+// listening on, sorted by name.
+func (hub *NotificationHub) Channels(ctx context.Context) []string {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return nil
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	var channels []string
+	for channel, sessions := range hub.channels {
+		if _, has := sessions[session]; has {
+			channels = append(channels, channel)
+		}
+	}
+
+	slices.Sort(channels)
+	return channels
+}
+
+// Notify publishes the given payload on the given channel. The notification is
+// queued for all sessions listening on the channel at the moment of
+// publishing. The process ID of the session inside the given context, if any,
+// is included inside the notification.
+func (hub *NotificationHub) Notify(ctx context.Context, channel string, payload string) error {
+	if channel == "" {
+		return newErrInvalidChannel()
+	}
+
+	if len(payload) >= maxNotificationPayload {
+		return newErrPayloadTooLong()
+	}
+
+	notification := Notification{
+		Channel: channel,
+		Payload: payload,
+	}
+
+	if session, ok := GetSession(ctx); ok {
+		notification.ProcessID = session.processID
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for session := range hub.channels[channel] {
+		session.enqueueNotification(notification)
+	}
+
+	return nil
+}
+
+// unlisten removes the given session from the given channel. The caller is
+// expected to hold the hub lock.
+func (hub *NotificationHub) unlisten(session *Session, channel string) {
+	sessions, has := hub.channels[channel]
+	if !has {
+		return
+	}
+
+	delete(sessions, session)
+	if len(sessions) == 0 {
+		delete(hub.channels, channel)
+	}
+}
+
+// remove unsubscribes the given session from all channels.
+func (hub *NotificationHub) remove(session *Session) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for channel := range hub.channels {
+		hub.unlisten(session, channel)
+	}
+}
+
+// notificationQueue contains the notifications and notices pending delivery to
+// a session. The signal channel is notified whenever a notification or notice
+// is queued.
+type notificationQueue struct {
+	mu      sync.Mutex
+	pending []Notification
+	notices []error
+	signal  chan struct{}
+}
+
+func newNotificationQueue() notificationQueue {
+	return notificationQueue{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// enqueueNotification queues the given notification for delivery and signals
+// the delivery routine of the session.
+func (srv *Session) enqueueNotification(notification Notification) {
+	srv.notifications.mu.Lock()
+	srv.notifications.pending = append(srv.notifications.pending, notification)
+	srv.notifications.mu.Unlock()
+
+	select {
+	case srv.notifications.signal <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueNotice queues the given notice for delivery and signals the delivery
+// routine of the session.
+func (srv *Session) enqueueNotice(err error) {
+	srv.notifications.mu.Lock()
+	srv.notifications.notices = append(srv.notifications.notices, err)
+	srv.notifications.mu.Unlock()
+
+	select {
+	case srv.notifications.signal <- struct{}{}:
+	default:
+	}
+}
+
+// writeNotices writes the queued notices to the client. The caller is expected
+// to be the routine writing the messages of the command being handled, or to
+// hold the command lock of the session.
+func (srv *Session) writeNotices(writer *buffer.Writer) error {
+	srv.notifications.mu.Lock()
+	notices := srv.notifications.notices
+	srv.notifications.notices = nil
+	srv.notifications.mu.Unlock()
+
+	for _, notice := range notices {
+		err := WriteNotice(writer, notice)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliverNotifications writes the pending notices to the client followed by
+// the pending notifications when the session is idle and not inside a
+// transaction block. Notifications are kept queued otherwise. The caller is
+// expected to hold the command lock of the session.
+func (srv *Session) deliverNotifications(writer *buffer.Writer) error {
+	deliver := srv.idle && srv.TxStatus() == types.ServerIdle
+
+	err := srv.writeNotices(writer)
+	if err != nil {
+		return err
+	}
+
+	srv.notifications.mu.Lock()
+	var pending []Notification
+	if deliver {
+		pending = srv.notifications.pending
+		srv.notifications.pending = nil
+	}
+	srv.notifications.mu.Unlock()
+
+	for _, notification := range pending {
+		writer.Start(types.ServerNotificationResponse)
+		writer.AddInt32(notification.ProcessID)
+		writer.AddString(notification.Channel)
+		writer.AddNullTerminate()
+		writer.AddString(notification.Payload)
+		writer.AddNullTerminate()
+		err := writer.End()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// consumeNotifications delivers the notifications and notices queued while the
+// session is idle and waiting for the next command. Notifications and notices
+// queued while a command is being handled are delivered once the command has
+// completed. This method returns once the given done channel has been closed.
+func (srv *Session) consumeNotifications(done <-chan struct{}, writer *buffer.Writer) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-srv.notifications.signal:
+		}
+
+		srv.commandMu.Lock()
+		err := srv.deliverNotifications(writer)
+		srv.commandMu.Unlock()
+
+		if err != nil {
+			srv.logger.Error("unexpected error while delivering notifications", "err", err)
+			return
+		}
+	}
+}
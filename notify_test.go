@@ -0,0 +1,194 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// notifyHandler returns a parse function handling LISTEN, UNLISTEN and NOTIFY
+// statements using the given hub. All other queries are handled by the
+// transaction handler.
+func notifyHandler(hub *NotificationHub) ParseFn {
+	return func(ctx context.Context, query string) (PreparedStatements, error) {
+		fields := strings.Fields(strings.ReplaceAll(query, ",", " "))
+		keyword := strings.ToUpper(fields[0])
+
+		switch keyword {
+		case "LISTEN", "UNLISTEN", "NOTIFY":
+		default:
+			return txHandler(ctx, query)
+		}
+
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			var err error
+			switch keyword {
+			case "LISTEN":
+				err = hub.Listen(ctx, fields[1])
+			case "UNLISTEN":
+				if fields[1] == "*" {
+					err = hub.UnlistenAll(ctx)
+					break
+				}
+
+				err = hub.Unlisten(ctx, fields[1])
+			case "NOTIFY":
+				var payload string
+				if len(fields) > 2 {
+					payload = strings.Trim(fields[2], "'")
+				}
+
+				err = hub.Notify(ctx, fields[1], payload)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return writer.Complete(keyword)
+		}
+
+		return Prepared(NewStatement(handle)), nil
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	t.Parallel()
+
+	hub := NewNotificationHub()
+	server, err := NewServer(notifyHandler(hub), Logger(slogt.New(t)), Notifications(hub), QueryCancellation())
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	url := fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port)
+
+	ctx := context.Background()
+	listener, err := pgx.Connect(ctx, url)
+	require.NoError(t, err)
+	defer listener.Close(ctx) //nolint:errcheck
+
+	notifier, err := pgx.Connect(ctx, url)
+	require.NoError(t, err)
+	defer notifier.Close(ctx) //nolint:errcheck
+
+	wait := func(conn *pgx.Conn, timeout time.Duration) (*pgconn.Notification, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return conn.WaitForNotification(ctx)
+	}
+
+	_, err = listener.Exec(ctx, "LISTEN events")
+	require.NoError(t, err)
+
+	t.Run("publish", func(t *testing.T) {
+		err := hub.Notify(context.Background(), "events", "hello")
+		require.NoError(t, err)
+
+		notification, err := wait(listener, 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "events", notification.Channel)
+		assert.Equal(t, "hello", notification.Payload)
+		assert.Equal(t, uint32(0), notification.PID)
+	})
+
+	t.Run("notify", func(t *testing.T) {
+		_, err := notifier.Exec(ctx, "NOTIFY events, 'world'")
+		require.NoError(t, err)
+
+		notification, err := wait(listener, 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "events", notification.Channel)
+		assert.Equal(t, "world", notification.Payload)
+		assert.Equal(t, notifier.PgConn().PID(), notification.PID)
+		assert.NotZero(t, notification.PID)
+	})
+
+	t.Run("self", func(t *testing.T) {
+		_, err := listener.Exec(ctx, "NOTIFY events, 'self'")
+		require.NoError(t, err)
+
+		notification, err := wait(listener, 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "self", notification.Payload)
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		_, err := listener.Exec(ctx, "BEGIN")
+		require.NoError(t, err)
+
+		err = hub.Notify(context.Background(), "events", "deferred")
+		require.NoError(t, err)
+
+		_, err = wait(listener, 100*time.Millisecond)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		_, err = listener.Exec(ctx, "COMMIT")
+		require.NoError(t, err)
+
+		notification, err := wait(listener, 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "deferred", notification.Payload)
+	})
+
+	t.Run("unlisten", func(t *testing.T) {
+		_, err := listener.Exec(ctx, "UNLISTEN events")
+		require.NoError(t, err)
+
+		err = hub.Notify(context.Background(), "events", "ignored")
+		require.NoError(t, err)
+
+		_, err = wait(listener, 100*time.Millisecond)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestNotificationHub(t *testing.T) {
+	t.Parallel()
+
+	t.Run("no session", func(t *testing.T) {
+		hub := NewNotificationHub()
+		err := hub.Listen(context.Background(), "events")
+		require.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		hub := NewNotificationHub()
+		ctx := context.WithValue(context.Background(), sessionKey, &Session{Server: &Server{}})
+		err := hub.Listen(ctx, "events")
+		require.ErrorIs(t, err, ErrNotificationHubNotConfigured)
+	})
+
+	t.Run("payload too long", func(t *testing.T) {
+		hub := NewNotificationHub()
+		err := hub.Notify(context.Background(), "events", strings.Repeat("x", maxNotificationPayload))
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidParameterValue, psqlerr.GetCode(err))
+	})
+
+	t.Run("channels", func(t *testing.T) {
+		hub := NewNotificationHub()
+		session := &Session{Server: &Server{Notifications: hub}, notifications: newNotificationQueue()}
+		ctx := context.WithValue(context.Background(), sessionKey, session)
+
+		require.NoError(t, hub.Listen(ctx, "b"))
+		require.NoError(t, hub.Listen(ctx, "a"))
+		require.NoError(t, hub.Listen(ctx, "a"))
+		assert.Equal(t, []string{"a", "b"}, hub.Channels(ctx))
+
+		require.NoError(t, hub.Unlisten(ctx, "a"))
+		assert.Equal(t, []string{"b"}, hub.Channels(ctx))
+
+		require.NoError(t, hub.UnlistenAll(ctx))
+		assert.Empty(t, hub.Channels(ctx))
+	})
+}
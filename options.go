@@ -4,8 +4,10 @@ import (
 	"context"
 	"crypto/tls"
 	"log/slog"
+	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
@@ -69,6 +71,15 @@ type PreparedStatement struct {
 	fn         PreparedStatementFn
 	parameters []uint32
 	columns    Columns
+	query      string
+}
+
+// withQuery returns a copy of the prepared statement holding the query from
+// which the statement has been prepared.
+func (stmt *PreparedStatement) withQuery(query string) *PreparedStatement {
+	prepared := *stmt
+	prepared.query = query
+	return &prepared
 }
 
 // SessionHandler represents a wrapper function defining the state of a single
@@ -124,6 +135,18 @@ type ParallelPipelineConfig struct {
 	Enabled bool // when true, allows concurrent execution of pipelined Execute messages
 }
 
+// ScrollableCursorsConfig controls whether cursors could be declared using the
+// SCROLL option. Rows produced by a scrollable cursor are buffered to allow the
+// cursor to be moved backwards or to an absolute position. Rows are kept in
+// memory until MemoryLimit (in bytes) is exceeded after which they are spilled
+// to a temporary file inside TempDir. A MemoryLimit of zero keeps all rows in
+// memory. The default temporary directory is used whenever TempDir is empty.
+type ScrollableCursorsConfig struct {
+	Enabled     bool   // when true, allows cursors to be declared using SCROLL
+	MemoryLimit int64  // maximum amount of bytes buffered in memory per cursor
+	TempDir     string // directory used to store spilled rows
+}
+
 type FlushFn func(ctx context.Context) error
 
 type CloseFn func(ctx context.Context) error
@@ -131,7 +154,7 @@ type CloseFn func(ctx context.Context) error
 // CancelRequestFn function called when a cancel request is received.
 // The function receives the process ID and secret key from the cancel request.
 // It should return an error if the cancel request cannot be processed.
-type CancelRequestFn func(ctx context.Context, processID int32, secretKey int32) error
+type CancelRequestFn func(ctx context.Context, processID int32, secretKey []byte) error
 
 // OptionFn options pattern used to define and set options for the given
 // PostgreSQL server.
@@ -155,6 +178,15 @@ func Portals(handler func() PortalCache) OptionFn {
 	}
 }
 
+// Transactions sets the transaction handler which is notified about the
+// transaction boundaries of each session, see [TransactionHandler].
+func Transactions(handler TransactionHandler) OptionFn {
+	return func(srv *Server) error {
+		srv.Transactions = handler
+		return nil
+	}
+}
+
 // CloseConn sets the close connection handle inside the given server instance.
 func CloseConn(fn CloseFn) OptionFn {
 	return func(srv *Server) error {
@@ -226,6 +258,17 @@ func ClientAuth(auth tls.ClientAuthType) OptionFn {
 	}
 }
 
+// UnixSocketPermissions sets the file permissions of the Unix domain socket
+// created by [Server.ListenAndServeUnix]. The default permissions (0777) allow
+// all local users to connect, similar to the PostgreSQL
+// unix_socket_permissions setting.
+func UnixSocketPermissions(mode os.FileMode) OptionFn {
+	return func(srv *Server) error {
+		srv.UnixSocketPermissions = mode
+		return nil
+	}
+}
+
 // TLSConfig sets the given TLS config to be used to initialize a
 // secure connection between the front-end (client) and back-end (server).
 func TLSConfig(config *tls.Config) OptionFn {
@@ -244,6 +287,30 @@ func SessionAuthStrategy(fn AuthStrategy) OptionFn {
 	}
 }
 
+// HostBasedAuthentication sets the host-based authentication rules of the
+// server, similar to the PostgreSQL pg_hba.conf file. The rules are evaluated
+// in the given order before authenticating a new connection, the first rule
+// matching the connection type, database, user and client address decides
+// which authentication strategy is used. Each rule has to define exactly one
+// of an authentication strategy, trust or reject. Connections rejected by a
+// rule, or not matching any rule, are closed with a FATAL error. The
+// authentication strategy set through [SessionAuthStrategy] is ignored when
+// rules are defined.
+// https://www.postgresql.org/docs/current/auth-pg-hba-conf.html
+func HostBasedAuthentication(rules ...HBARule) OptionFn {
+	return func(srv *Server) error {
+		for _, rule := range rules {
+			err := rule.validate()
+			if err != nil {
+				return err
+			}
+		}
+
+		srv.HostBasedAuth = rules
+		return nil
+	}
+}
+
 // BackendKeyData sets the function that generates backend key data for query cancellation.
 // The provided function should return a process ID and secret key that can be used by
 // clients to cancel queries. If not set, no BackendKeyData message will be sent.
@@ -264,6 +331,104 @@ func CancelRequest(fn CancelRequestFn) OptionFn {
 	}
 }
 
+// QueryCancellation enables the built-in query cancellation registry. Each
+// session is registered using a random process ID and secret key which are
+// send to the client as backend key data. When a matching cancel request is
+// received, the context passed to the statements executed by the session is
+// canceled and the client receives a query_canceled (57014) error. The
+// [BackendKeyData] and [CancelRequest] functions are ignored when the
+// registry is enabled.
+func QueryCancellation() OptionFn {
+	return func(srv *Server) error {
+		srv.QueryCancellation = true
+		return nil
+	}
+}
+
+// ProtocolExtensions registers the protocol extensions understood by the
+// server. Clients request protocol extensions using startup options prefixed
+// with _pq_. The names are given without the prefix. Requested extensions
+// which are not registered are reported back to the client inside a
+// NegotiateProtocolVersion message. The values of the negotiated extensions
+// could be read using [ProtocolExtension].
+func ProtocolExtensions(names ...string) OptionFn {
+	return func(srv *Server) error {
+		for _, name := range names {
+			srv.ProtocolExtensions = append(srv.ProtocolExtensions, strings.TrimPrefix(name, protocolExtensionPrefix))
+		}
+
+		return nil
+	}
+}
+
+// Cursors enables the built-in handling of DECLARE, FETCH, MOVE and CLOSE
+// statements inside the simple query protocol. The query of a declared cursor
+// is parsed using the configured parse function and bound to a portal named
+// after the cursor. Rows are fetched from the portal using the same suspension
+// machinery as used by the extended query protocol. Cursors declared WITHOUT
+// HOLD are closed at the end of the transaction, cursors declared WITH HOLD
+// remain open once the transaction is committed. The remaining rows of held
+// cursors are materialized when the transaction is committed, using the
+// memory limit and temporary directory of the [ScrollableCursorsConfig].
+// Cursor statements could not be combined with other statements inside a
+// single query.
+func Cursors() OptionFn {
+	return func(srv *Server) error {
+		srv.Cursors = true
+		return nil
+	}
+}
+
+// ScrollableCursors sets the scrollable cursor configuration for the server.
+// Cursors are enabled whenever scrollable cursors are enabled.
+func ScrollableCursors(config ScrollableCursorsConfig) OptionFn {
+	return func(srv *Server) error {
+		srv.ScrollableCursors = config
+		if config.Enabled {
+			srv.Cursors = true
+		}
+
+		return nil
+	}
+}
+
+// SessionSettings enables the built-in handling of SET, SHOW and RESET
+// statements, and of the set_config and current_setting functions, using the
+// configuration parameters of the session. Queries containing a single
+// settings statement are handled by the session and are not passed to the
+// parse function. Changes made inside a transaction are reverted once the
+// transaction, or the savepoint they were made after, is rolled back. This
+// includes the implicit transaction of a multi-statement query or pipeline.
+// Configuration parameters could also be accessed by handlers using
+// [GetSetting] and [SetSetting].
+func SessionSettings() OptionFn {
+	return func(srv *Server) error {
+		srv.SessionSettings = true
+		return nil
+	}
+}
+
+// Replication sets the handler used to serve logical replication connections.
+// Clients request a replication connection using the replication=database
+// startup parameter. Replication connections are refused when no handler has
+// been configured.
+func Replication(handler ReplicationHandler) OptionFn {
+	return func(srv *Server) error {
+		srv.Replication = handler
+		return nil
+	}
+}
+
+// Notifications sets the hub used to deliver asynchronous notifications to
+// the sessions of the given server. Handlers subscribe sessions to channels
+// using [NotificationHub.Listen] and publish using [NotificationHub.Notify].
+func Notifications(hub *NotificationHub) OptionFn {
+	return func(srv *Server) error {
+		srv.Notifications = hub
+		return nil
+	}
+}
+
 // GlobalParameters sets the server parameters which are send back to the
 // front-end (client) once a handshake has been established.
 func GlobalParameters(params Parameters) OptionFn {
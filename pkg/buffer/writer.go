@@ -75,6 +75,20 @@ func (writer *Writer) AddInt32(i int32) (size int) {
 	return size
 }
 
+// AddInt64 writes the given unsigned int64 to the writer frame. Bytes written to the
+// frame could be read at any stage to interact with a Postgres client. Errors
+// thrown while writing to the writer could be read by calling writer.Error()
+func (writer *Writer) AddInt64(i int64) (size int) {
+	if writer.err != nil {
+		return size
+	}
+
+	x := make([]byte, 8)
+	binary.BigEndian.PutUint64(x, uint64(i))
+	size, writer.err = writer.frame.Write(x)
+	return size
+}
+
 // AddBytes writes the given bytes to the writer frame. Bytes written to the
 // frame could be read at any stage to interact with a Postgres client. Errors
 // thrown while writing to the writer could be read by calling writer.Error()
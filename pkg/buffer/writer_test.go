@@ -99,6 +99,13 @@ func TestWriteTypes(t *testing.T) {
 			t.Error(writer.Error())
 		}
 	})
+
+	t.Run("int64", func(t *testing.T) {
+		writer.AddInt64(math.MaxInt64)
+		if writer.Error() != nil {
+			t.Error(writer.Error())
+		}
+	})
 }
 
 func TestWriteTypesErr(t *testing.T) {
@@ -159,6 +166,16 @@ func TestWriteTypesErr(t *testing.T) {
 			t.Errorf("unexpected err %s, expected %s", writer.Error(), expected)
 		}
 
+		if len(writer.Bytes()) != 0 {
+			t.Fatalf("unexpected bytes, no bytes should have been written")
+		}
+	})
+	t.Run("int64", func(t *testing.T) {
+		writer.AddInt64(math.MaxInt64)
+		if writer.Error() != expected {
+			t.Errorf("unexpected err %s, expected %s", writer.Error(), expected)
+		}
+
 		if len(writer.Bytes()) != 0 {
 			t.Fatalf("unexpected bytes, no bytes should have been written")
 		}
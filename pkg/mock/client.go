@@ -28,20 +28,35 @@ type Client struct {
 // connection preferences and the writing of (metadata) parameters identifying
 // the given client.
 func (client *Client) Handshake(t *testing.T) {
+	client.HandshakeVersion(t, types.Version30)
+}
+
+// HandshakeVersion performs a simple handshake requesting the given protocol
+// version.
+func (client *Client) HandshakeVersion(t *testing.T, protocol types.Version) {
+	client.HandshakeParameters(t, protocol, nil)
+}
+
+// HandshakeParameters performs a simple handshake requesting the given
+// protocol version and including the given (metadata) parameters.
+func (client *Client) HandshakeParameters(t *testing.T, protocol types.Version, params map[string]string) {
 	t.Log("performing simple handshake")
 	defer t.Log("simple handshake completed")
 
 	version := make([]byte, 4)
-	binary.BigEndian.PutUint32(version, uint32(types.Version30))
+	binary.BigEndian.PutUint32(version, uint32(protocol))
 
 	// NOTE: the parameters consist out of keys and values. Each key and
 	// value is terminated using a nul byte and the end of all parameters is
 	// identified using a empty key value.
 	nul := byte(0)
-	key := append([]byte("client"), nul)
-	value := append([]byte("mock"), nul)
-	end := append([]byte(""), nul)
-	parameters := append(append(key, value...), end...)
+	parameters := append(append([]byte("client"), nul), append([]byte("mock"), nul)...)
+	for key, value := range params {
+		parameters = append(parameters, append([]byte(key), nul)...)
+		parameters = append(parameters, append([]byte(value), nul)...)
+	}
+
+	parameters = append(parameters, nul)
 
 	// NOTE: we have to define the total message length inside the
 	// header by prefixing a unsigned 32 big-endian int.
@@ -33,12 +33,18 @@ const (
 	ServerBindComplete         ServerMessage = '2'
 	ServerCommandComplete      ServerMessage = 'C'
 	ServerCloseComplete        ServerMessage = '3'
+	ServerCopyBothResponse     ServerMessage = 'W'
+	ServerCopyData             ServerMessage = 'd'
+	ServerCopyDone             ServerMessage = 'c'
 	ServerCopyInResponse       ServerMessage = 'G'
+	ServerCopyOutResponse      ServerMessage = 'H'
 	ServerDataRow              ServerMessage = 'D'
 	ServerEmptyQuery           ServerMessage = 'I'
 	ServerErrorResponse        ServerMessage = 'E'
 	ServerNoticeResponse       ServerMessage = 'N'
+	ServerNotificationResponse ServerMessage = 'A'
 	ServerNoData               ServerMessage = 'n'
+	ServerNegotiateProtocol    ServerMessage = 'v'
 	ServerParameterDescription ServerMessage = 't'
 	ServerParameterStatus      ServerMessage = 'S'
 	ServerParseComplete        ServerMessage = '1'
@@ -98,8 +104,16 @@ func (m ServerMessage) String() string {
 		return "CommandComplete"
 	case ServerCloseComplete:
 		return "CloseComplete"
+	case ServerCopyBothResponse:
+		return "CopyBothResponse"
+	case ServerCopyData:
+		return "CopyData"
+	case ServerCopyDone:
+		return "CopyDone"
 	case ServerCopyInResponse:
 		return "CopyInResponse"
+	case ServerCopyOutResponse:
+		return "CopyOutResponse"
 	case ServerDataRow:
 		return "DataRow"
 	case ServerEmptyQuery:
@@ -108,8 +122,12 @@ func (m ServerMessage) String() string {
 		return "ErrorResponse"
 	case ServerNoticeResponse:
 		return "NoticeResponse"
+	case ServerNotificationResponse:
+		return "NotificationResponse"
 	case ServerNoData:
 		return "NoData"
+	case ServerNegotiateProtocol:
+		return "NegotiateProtocolVersion"
 	case ServerParameterDescription:
 		return "ParameterDescription"
 	case ServerParameterStatus:
@@ -14,7 +14,18 @@ type Version uint32
 // See: https://www.postgresql.org/docs/current/protocol-message-formats.html
 const (
 	Version30         Version = 196608   // (3 << 16) + 0
+	Version32         Version = 196610   // (3 << 16) + 2
 	VersionCancel     Version = 80877102 // (1234 << 16) + 5678
 	VersionSSLRequest Version = 80877103 // (1234 << 16) + 5679
 	VersionGSSENC     Version = 80877104 // (1234 << 16) + 5680
 )
+
+// Major returns the major protocol version number.
+func (version Version) Major() uint16 {
+	return uint16(version >> 16)
+}
+
+// Minor returns the minor protocol version number.
+func (version Version) Minor() uint16 {
+	return uint16(version & 0xffff)
+}
@@ -0,0 +1,818 @@
+package wire
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// replicationParameter is the startup parameter used by clients to request a
+// replication connection.
+const replicationParameter ParameterStatus = "replication"
+
+// Message types send inside CopyData messages during streaming replication.
+// https://www.postgresql.org/docs/current/protocol-replication.html
+const (
+	replicationXLogData       byte = 'w'
+	replicationKeepalive      byte = 'k'
+	replicationStatusUpdate   byte = 'r'
+	replicationHotStandbyInfo byte = 'h'
+)
+
+// Message types of the pgoutput logical replication protocol.
+// https://www.postgresql.org/docs/current/protocol-logicalrep-message-formats.html
+const (
+	pgoutputBegin    byte = 'B'
+	pgoutputCommit   byte = 'C'
+	pgoutputRelation byte = 'R'
+	pgoutputInsert   byte = 'I'
+	pgoutputUpdate   byte = 'U'
+	pgoutputDelete   byte = 'D'
+)
+
+// postgresEpoch is the epoch used by Postgres to encode timestamps.
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// newErrReplicationUnsupported is returned whenever a replication connection
+// or command is requested which is not supported.
+func newErrReplicationUnsupported(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.FeatureNotSupported), psqlerr.LevelError)
+}
+
+// newErrReplicationSyntax is returned whenever a replication command could not
+// be parsed.
+func newErrReplicationSyntax(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.Syntax), psqlerr.LevelError)
+}
+
+// LSN represents a position inside the write-ahead log.
+type LSN uint64
+
+// String returns the textual representation of the LSN, two hexadecimal
+// numbers separated by a slash.
+func (lsn LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
+
+// ParseLSN parses the given textual representation of a LSN.
+func ParseLSN(value string) (LSN, error) {
+	high, low, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", value)
+	}
+
+	upper, err := strconv.ParseUint(high, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %w", value, err)
+	}
+
+	lower, err := strconv.ParseUint(low, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %w", value, err)
+	}
+
+	return LSN(upper<<32 | lower), nil
+}
+
+// ReplicationHandler handles the commands of logical replication connections.
+// Replication connections are requested by clients using the
+// replication=database startup parameter.
+type ReplicationHandler interface {
+	// IdentifySystem returns the identity of the replication source.
+	IdentifySystem(ctx context.Context) (SystemIdentity, error)
+	// CreateReplicationSlot creates a new logical replication slot.
+	CreateReplicationSlot(ctx context.Context, options CreateReplicationSlotOptions) (ReplicationSlot, error)
+	// StartReplication streams the changes of the given replication slot to
+	// the client using the given stream. The given context is cancelled once
+	// the client ends the stream. Returning ends the stream from the server.
+	StartReplication(ctx context.Context, options StartReplicationOptions, stream *ReplicationStream) error
+}
+
+// ReplicationValidator could be implemented by a [ReplicationHandler] to
+// validate a START_REPLICATION command, such as the existence of the slot and
+// the start position, before the stream is started. Errors returned by the
+// validator are written to the client as a regular error response, before
+// the connection is switched into CopyBoth mode.
+type ReplicationValidator interface {
+	ValidateReplication(ctx context.Context, options StartReplicationOptions) error
+}
+
+// SystemIdentity represents the result of the IDENTIFY_SYSTEM command.
+type SystemIdentity struct {
+	SystemID string
+	Timeline int32
+	XLogPos  LSN
+	Database string
+}
+
+// CreateReplicationSlotOptions represents the options of the
+// CREATE_REPLICATION_SLOT command.
+type CreateReplicationSlotOptions struct {
+	Name      string
+	Temporary bool
+	Plugin    string
+	Options   map[string]string
+}
+
+// ReplicationSlot represents a created replication slot.
+type ReplicationSlot struct {
+	Name            string
+	ConsistentPoint LSN
+	SnapshotName    string
+	OutputPlugin    string
+}
+
+// StartReplicationOptions represents the options of the START_REPLICATION
+// command. The options contain the output plugin arguments such as
+// proto_version and publication_names.
+type StartReplicationOptions struct {
+	Slot     string
+	StartLSN LSN
+	Options  map[string]string
+}
+
+// StandbyStatus represents the last Standby Status Update received from the
+// client.
+type StandbyStatus struct {
+	WriteLSN       LSN
+	FlushLSN       LSN
+	ApplyLSN       LSN
+	ClientTime     time.Time
+	ReplyRequested bool
+}
+
+// IsReplicationConnection returns whether the session inside the given context
+// is a logical replication connection.
+func IsReplicationConnection(ctx context.Context) bool {
+	session, ok := GetSession(ctx)
+	return ok && session.replication
+}
+
+// replicationMode returns whether the client requested a logical replication
+// connection. Physical replication connections are not supported.
+func (srv *Server) replicationMode(ctx context.Context) (bool, error) {
+	value := strings.ToLower(ClientParameters(ctx)[replicationParameter])
+	switch value {
+	case "", "false", "off", "no", "0":
+		return false, nil
+	case "database":
+		if srv.Replication == nil {
+			err := newErrReplicationUnsupported("replication connections are not supported")
+			return false, psqlerr.WithSeverity(err, psqlerr.LevelFatal)
+		}
+
+		return true, nil
+	case "true", "on", "yes", "1":
+		err := newErrReplicationUnsupported("physical replication connections are not supported")
+		return false, psqlerr.WithSeverity(err, psqlerr.LevelFatal)
+	}
+
+	err := newErrReplicationUnsupported("invalid value for parameter \"replication\": %q", value)
+	return false, psqlerr.WithSeverity(err, psqlerr.LevelFatal)
+}
+
+// handleReplicationQuery handles the given query if it contains a replication
+// command and the session is a replication connection. All other queries are
+// handled as regular queries.
+func (srv *Session) handleReplicationQuery(ctx context.Context, query string, reader *buffer.Reader, writer *buffer.Writer) (bool, error) {
+	if !srv.replication {
+		return false, nil
+	}
+
+	tokens, err := tokenizeStatement(query)
+	if err != nil || len(tokens) == 0 || tokens[0].literal || tokens[0].punct {
+		return false, nil
+	}
+
+	switch tokens[0].value {
+	case "identify_system":
+		err = srv.identifySystem(ctx, writer)
+	case "create_replication_slot":
+		err = srv.createReplicationSlot(ctx, tokens[1:], writer)
+	case "start_replication":
+		options, err := parseStartReplication(tokens[1:])
+		if err != nil {
+			return true, srv.WriteError(writer, err)
+		}
+
+		return true, srv.startReplication(ctx, options, reader, writer)
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		return true, srv.WriteError(writer, err)
+	}
+
+	return true, srv.readyForQuery(writer)
+}
+
+// identifySystem writes the identity of the replication source.
+func (srv *Session) identifySystem(ctx context.Context, writer *buffer.Writer) error {
+	identity, err := srv.Replication.IdentifySystem(ctx)
+	if err != nil {
+		return err
+	}
+
+	columns := Columns{
+		{Name: "systemid", Oid: pgtype.TextOID, Width: -1},
+		{Name: "timeline", Oid: pgtype.Int4OID, Width: 4},
+		{Name: "xlogpos", Oid: pgtype.TextOID, Width: -1},
+		{Name: "dbname", Oid: pgtype.TextOID, Width: -1},
+	}
+
+	var database any
+	if identity.Database != "" {
+		database = identity.Database
+	}
+
+	row := []any{identity.SystemID, identity.Timeline, identity.XLogPos.String(), database}
+	return writeReplicationResult(ctx, writer, columns, row, "IDENTIFY_SYSTEM")
+}
+
+// createReplicationSlot creates a logical replication slot defined inside the
+// given tokens: slot_name [ TEMPORARY ] LOGICAL output_plugin [ options ].
+func (srv *Session) createReplicationSlot(ctx context.Context, tokens []statementToken, writer *buffer.Writer) (err error) {
+	if len(tokens) == 0 || tokens[0].literal || tokens[0].punct {
+		return newErrReplicationSyntax("replication slot name expected")
+	}
+
+	options := CreateReplicationSlotOptions{
+		Name: tokens[0].value,
+	}
+
+	tokens = tokens[1:]
+	if len(tokens) > 0 && tokens[0].value == "temporary" {
+		options.Temporary = true
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) > 0 && tokens[0].value == "physical" {
+		return newErrReplicationUnsupported("physical replication slots are not supported")
+	}
+
+	if len(tokens) < 2 || tokens[0].value != "logical" {
+		return newErrReplicationSyntax("LOGICAL output plugin expected")
+	}
+
+	options.Plugin = tokens[1].value
+	tokens = tokens[2:]
+
+	switch {
+	case len(tokens) > 0 && tokens[0].punct:
+		options.Options, err = replicationOptions(tokens)
+		if err != nil {
+			return err
+		}
+	case len(tokens) == 1:
+		// NOTE: the legacy snapshot options are translated to the SNAPSHOT
+		// option used within the parenthesized option list.
+		snapshot, ok := strings.CutSuffix(tokens[0].value, "_snapshot")
+		if !ok {
+			return newErrReplicationSyntax("syntax error at or near %q", tokens[0].value)
+		}
+
+		options.Options = map[string]string{"snapshot": strings.ReplaceAll(snapshot, "noexport", "nothing")}
+	case len(tokens) > 1:
+		return newErrReplicationSyntax("syntax error at or near %q", tokens[1].value)
+	}
+
+	slot, err := srv.Replication.CreateReplicationSlot(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	columns := Columns{
+		{Name: "slot_name", Oid: pgtype.TextOID, Width: -1},
+		{Name: "consistent_point", Oid: pgtype.TextOID, Width: -1},
+		{Name: "snapshot_name", Oid: pgtype.TextOID, Width: -1},
+		{Name: "output_plugin", Oid: pgtype.TextOID, Width: -1},
+	}
+
+	if slot.Name == "" {
+		slot.Name = options.Name
+	}
+
+	if slot.OutputPlugin == "" {
+		slot.OutputPlugin = options.Plugin
+	}
+
+	var snapshot any
+	if slot.SnapshotName != "" {
+		snapshot = slot.SnapshotName
+	}
+
+	row := []any{slot.Name, slot.ConsistentPoint.String(), snapshot, slot.OutputPlugin}
+	return writeReplicationResult(ctx, writer, columns, row, "CREATE_REPLICATION_SLOT")
+}
+
+// writeReplicationResult writes a result set containing a single row followed
+// by the given command tag.
+func writeReplicationResult(ctx context.Context, writer *buffer.Writer, columns Columns, row []any, tag string) error {
+	err := columns.Define(ctx, writer, nil)
+	if err != nil {
+		return err
+	}
+
+	err = columns.Write(ctx, nil, writer, row)
+	if err != nil {
+		return err
+	}
+
+	return commandComplete(writer, tag)
+}
+
+// replicationOptions parses the given parenthesized list of options. Each
+// option consists of a name and an optional value.
+func replicationOptions(tokens []statementToken) (map[string]string, error) {
+	if len(tokens) < 2 || tokens[0].value != "(" || tokens[len(tokens)-1].value != ")" {
+		return nil, newErrReplicationSyntax("options list expected")
+	}
+
+	options := make(map[string]string)
+	tokens = tokens[1 : len(tokens)-1]
+	for len(tokens) > 0 {
+		if tokens[0].punct {
+			return nil, newErrReplicationSyntax("syntax error at or near %q", tokens[0].value)
+		}
+
+		name := tokens[0].value
+		tokens = tokens[1:]
+
+		var value string
+		if len(tokens) > 0 && !tokens[0].punct {
+			value = tokens[0].value
+			tokens = tokens[1:]
+		}
+
+		options[name] = value
+
+		if len(tokens) > 0 {
+			if tokens[0].value != "," {
+				return nil, newErrReplicationSyntax("syntax error at or near %q", tokens[0].value)
+			}
+
+			tokens = tokens[1:]
+		}
+	}
+
+	return options, nil
+}
+
+// parseStartReplication parses the options of the START_REPLICATION command
+// defined inside the given tokens: SLOT slot_name LOGICAL XXX/XXX [ options ].
+func parseStartReplication(tokens []statementToken) (options StartReplicationOptions, err error) {
+	if len(tokens) > 1 && tokens[0].value == "slot" {
+		options.Slot = tokens[1].value
+		tokens = tokens[2:]
+	}
+
+	if len(tokens) == 0 || tokens[0].value != "logical" {
+		return options, newErrReplicationUnsupported("physical replication is not supported")
+	}
+
+	if len(tokens) < 2 {
+		return options, newErrReplicationSyntax("start position expected")
+	}
+
+	options.StartLSN, err = ParseLSN(tokens[1].value)
+	if err != nil {
+		return options, newErrReplicationSyntax("%s", err)
+	}
+
+	if len(tokens) > 2 {
+		options.Options, err = replicationOptions(tokens[2:])
+		if err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
+}
+
+// startReplication sends a CopyBothResponse to the client after which the
+// replication handler is started. The command is validated beforehand when
+// the handler implements [ReplicationValidator]. Feedback messages send by the
+// client are processed until the client ends the stream. Errors returned by
+// the handler are written to the client once the stream has ended.
+func (srv *Session) startReplication(ctx context.Context, options StartReplicationOptions, reader *buffer.Reader, writer *buffer.Writer) error {
+	if validator, ok := srv.Replication.(ReplicationValidator); ok {
+		err := validator.ValidateReplication(ctx, options)
+		if err != nil {
+			return srv.WriteError(writer, err)
+		}
+	}
+
+	writer.Start(types.ServerCopyBothResponse)
+	writer.AddByte(byte(TextFormat))
+	writer.AddInt16(0)
+	err := writer.End()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream := &ReplicationStream{
+		ctx:       ctx,
+		writer:    writer,
+		typeMap:   TypeMap(ctx),
+		relations: make(map[uint32]ReplicationRelation),
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		err := srv.Replication.StartReplication(ctx, options, stream)
+		result <- errors.Join(err, stream.close())
+	}()
+
+	for {
+		typed, _, err := reader.ReadTypedMsg()
+		if err != nil {
+			cancel()
+			<-result
+			return err
+		}
+
+		switch typed {
+		case types.ClientCopyData:
+			err = stream.feedback(reader.Msg)
+			if err != nil {
+				srv.logger.Warn("unable to process replication feedback", "err", err)
+			}
+		case types.ClientCopyDone:
+			cancel()
+			err = <-result
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return srv.WriteError(writer, err)
+			}
+
+			err = commandComplete(writer, "START_REPLICATION")
+			if err != nil {
+				return err
+			}
+
+			return srv.readyForQuery(writer)
+		case types.ClientFlush, types.ClientSync:
+			// NOTE: flush and sync messages are ignored while streaming.
+		case types.ClientTerminate:
+			cancel()
+			<-result
+			return io.EOF
+		default:
+			cancel()
+			<-result
+			return NewErrUnimplementedMessageType(typed)
+		}
+	}
+}
+
+// ReplicationStream streams logical replication messages to the client using
+// XLogData messages. Messages could be written concurrently.
+type ReplicationStream struct {
+	ctx       context.Context
+	writer    *buffer.Writer
+	typeMap   *pgtype.Map
+	mu        sync.Mutex
+	relations map[uint32]ReplicationRelation
+	position  LSN
+	status    StandbyStatus
+	done      bool
+}
+
+// Send encodes the given message using the pgoutput protocol and writes it to
+// the client inside a XLogData message starting at the given position.
+// Relation messages have to be send before the changes of the given relation.
+func (stream *ReplicationStream) Send(start LSN, message ReplicationMessage) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	data, err := message.encode(stream, nil)
+	if err != nil {
+		return err
+	}
+
+	if relation, ok := message.(ReplicationRelation); ok {
+		stream.relations[relation.ID] = relation
+	}
+
+	return stream.xlogData(start, data)
+}
+
+// SendData writes the given raw data, encoded by the output plugin of the
+// replication slot, to the client inside a XLogData message starting at the
+// given position.
+func (stream *ReplicationStream) SendData(start LSN, data []byte) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	return stream.xlogData(start, data)
+}
+
+// Keepalive writes a primary keepalive message containing the current end of
+// the stream to the client. The client is asked to reply with a standby status
+// update whenever a reply is requested.
+func (stream *ReplicationStream) Keepalive(reply bool) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	return stream.keepalive(reply)
+}
+
+// Status returns the last standby status update received from the client.
+func (stream *ReplicationStream) Status() StandbyStatus {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	return stream.status
+}
+
+func (stream *ReplicationStream) xlogData(start LSN, data []byte) error {
+	if stream.done {
+		return ErrClosedWriter
+	}
+
+	if stream.ctx.Err() != nil {
+		return stream.ctx.Err()
+	}
+
+	stream.position = max(stream.position, start)
+
+	stream.writer.Start(types.ServerCopyData)
+	stream.writer.AddByte(replicationXLogData)
+	stream.writer.AddInt64(int64(start))
+	stream.writer.AddInt64(int64(stream.position))
+	stream.writer.AddInt64(postgresTimestamp(time.Now()))
+	stream.writer.AddBytes(data)
+	return stream.writer.End()
+}
+
+func (stream *ReplicationStream) keepalive(reply bool) error {
+	if stream.done {
+		return ErrClosedWriter
+	}
+
+	var requested byte
+	if reply {
+		requested = 1
+	}
+
+	stream.writer.Start(types.ServerCopyData)
+	stream.writer.AddByte(replicationKeepalive)
+	stream.writer.AddInt64(int64(stream.position))
+	stream.writer.AddInt64(postgresTimestamp(time.Now()))
+	stream.writer.AddByte(requested)
+	return stream.writer.End()
+}
+
+// feedback processes the given CopyData message send by the client. Standby
+// status updates are stored, hot standby feedback is ignored.
+func (stream *ReplicationStream) feedback(msg []byte) error {
+	if len(msg) == 0 {
+		return errors.New("empty replication feedback message")
+	}
+
+	switch msg[0] {
+	case replicationStatusUpdate:
+		if len(msg) < 34 {
+			return fmt.Errorf("unexpected standby status update length: %d", len(msg))
+		}
+
+		status := StandbyStatus{
+			WriteLSN:       LSN(binary.BigEndian.Uint64(msg[1:])),
+			FlushLSN:       LSN(binary.BigEndian.Uint64(msg[9:])),
+			ApplyLSN:       LSN(binary.BigEndian.Uint64(msg[17:])),
+			ClientTime:     postgresEpoch.Add(time.Duration(binary.BigEndian.Uint64(msg[25:])) * time.Microsecond),
+			ReplyRequested: msg[33] == 1,
+		}
+
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+
+		stream.status = status
+		if status.ReplyRequested {
+			return stream.keepalive(false)
+		}
+
+		return nil
+	case replicationHotStandbyInfo:
+		return nil
+	}
+
+	return fmt.Errorf("unexpected replication feedback message type: %q", msg[0])
+}
+
+// close ends the stream by sending a CopyDone message to the client.
+func (stream *ReplicationStream) close() error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if stream.done {
+		return nil
+	}
+
+	stream.done = true
+	stream.writer.Start(types.ServerCopyDone)
+	return stream.writer.End()
+}
+
+// postgresTimestamp returns the given time as the number of microseconds since
+// the Postgres epoch.
+func postgresTimestamp(t time.Time) int64 {
+	return t.Sub(postgresEpoch).Microseconds()
+}
+
+// ReplicationMessage represents a logical replication message encoded using
+// the pgoutput protocol.
+// https://www.postgresql.org/docs/current/protocol-logicalrep-message-formats.html
+type ReplicationMessage interface {
+	encode(stream *ReplicationStream, buf []byte) ([]byte, error)
+}
+
+// ReplicationBegin marks the start of a transaction.
+type ReplicationBegin struct {
+	FinalLSN   LSN
+	CommitTime time.Time
+	Xid        uint32
+}
+
+func (message ReplicationBegin) encode(_ *ReplicationStream, buf []byte) ([]byte, error) {
+	buf = append(buf, pgoutputBegin)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(message.FinalLSN))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(postgresTimestamp(message.CommitTime)))
+	return binary.BigEndian.AppendUint32(buf, message.Xid), nil
+}
+
+// ReplicationCommit marks the end of a transaction.
+type ReplicationCommit struct {
+	CommitLSN  LSN
+	EndLSN     LSN
+	CommitTime time.Time
+}
+
+func (message ReplicationCommit) encode(_ *ReplicationStream, buf []byte) ([]byte, error) {
+	buf = append(buf, pgoutputCommit, 0)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(message.CommitLSN))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(message.EndLSN))
+	return binary.BigEndian.AppendUint64(buf, uint64(postgresTimestamp(message.CommitTime))), nil
+}
+
+// ReplicationRelation describes a relation whose changes are replicated. The
+// relation has to be send before any of its changes. The columns are used to
+// encode the tuples of the replicated changes.
+type ReplicationRelation struct {
+	ID        uint32
+	Namespace string
+	Name      string
+	// ReplicaIdentity is the replica identity setting of the relation, 'd'
+	// (default) is used when not set.
+	ReplicaIdentity byte
+	Columns         []ReplicationColumn
+}
+
+// ReplicationColumn describes a single column of a replicated relation.
+type ReplicationColumn struct {
+	Name         string
+	Oid          uint32
+	TypeModifier int32
+	Key          bool
+}
+
+func (message ReplicationRelation) encode(_ *ReplicationStream, buf []byte) ([]byte, error) {
+	identity := message.ReplicaIdentity
+	if identity == 0 {
+		identity = 'd'
+	}
+
+	buf = append(buf, pgoutputRelation)
+	buf = binary.BigEndian.AppendUint32(buf, message.ID)
+	buf = append(append(buf, message.Namespace...), 0)
+	buf = append(append(buf, message.Name...), 0)
+	buf = append(buf, identity)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(message.Columns)))
+
+	for _, column := range message.Columns {
+		var flags byte
+		if column.Key {
+			flags = 1
+		}
+
+		buf = append(buf, flags)
+		buf = append(append(buf, column.Name...), 0)
+		buf = binary.BigEndian.AppendUint32(buf, column.Oid)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(column.TypeModifier))
+	}
+
+	return buf, nil
+}
+
+// ReplicationInsert represents an inserted row of the given relation.
+type ReplicationInsert struct {
+	RelationID uint32
+	New        []any
+}
+
+func (message ReplicationInsert) encode(stream *ReplicationStream, buf []byte) ([]byte, error) {
+	buf = append(buf, pgoutputInsert)
+	buf = binary.BigEndian.AppendUint32(buf, message.RelationID)
+	buf = append(buf, 'N')
+	return stream.tuple(buf, message.RelationID, message.New)
+}
+
+// ReplicationUpdate represents an updated row of the given relation. The old
+// row is optional and only contains the key columns when KeyOnly is set.
+type ReplicationUpdate struct {
+	RelationID uint32
+	Old        []any
+	KeyOnly    bool
+	New        []any
+}
+
+func (message ReplicationUpdate) encode(stream *ReplicationStream, buf []byte) (_ []byte, err error) {
+	buf = append(buf, pgoutputUpdate)
+	buf = binary.BigEndian.AppendUint32(buf, message.RelationID)
+
+	if message.Old != nil {
+		buf = append(buf, oldTupleType(message.KeyOnly))
+		buf, err = stream.tuple(buf, message.RelationID, message.Old)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf = append(buf, 'N')
+	return stream.tuple(buf, message.RelationID, message.New)
+}
+
+// ReplicationDelete represents a deleted row of the given relation. The old
+// row only contains the key columns when KeyOnly is set.
+type ReplicationDelete struct {
+	RelationID uint32
+	Old        []any
+	KeyOnly    bool
+}
+
+func (message ReplicationDelete) encode(stream *ReplicationStream, buf []byte) ([]byte, error) {
+	buf = append(buf, pgoutputDelete)
+	buf = binary.BigEndian.AppendUint32(buf, message.RelationID)
+	buf = append(buf, oldTupleType(message.KeyOnly))
+	return stream.tuple(buf, message.RelationID, message.Old)
+}
+
+// oldTupleType returns the type of the old tuple inside update and delete
+// messages.
+func oldTupleType(key bool) byte {
+	if key {
+		return 'K'
+	}
+
+	return 'O'
+}
+
+// tuple appends the given values encoded as TupleData to the given buffer. The
+// values are encoded in text format using the columns of the given relation.
+// Nil values are encoded as NULL values.
+func (stream *ReplicationStream) tuple(buf []byte, id uint32, values []any) ([]byte, error) {
+	relation, ok := stream.relations[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown relation %d, relations have to be send before their changes", id)
+	}
+
+	if len(values) != len(relation.Columns) {
+		return nil, fmt.Errorf("unexpected columns, %d columns are defined inside relation %q but %d were given", len(relation.Columns), relation.Name, len(values))
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(values)))
+	for index, column := range relation.Columns {
+		value, err := stream.typeMap.Encode(column.Oid, int16(TextFormat), values[index], make([]byte, 0))
+		if err != nil {
+			return nil, err
+		}
+
+		if value == nil {
+			buf = append(buf, 'n')
+			continue
+		}
+
+		buf = append(buf, 't')
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+		buf = append(buf, value...)
+	}
+
+	return buf, nil
+}
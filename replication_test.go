@@ -0,0 +1,280 @@
+package wire
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type replicationHandler struct {
+	slot  CreateReplicationSlotOptions
+	start StartReplicationOptions
+}
+
+func (handler *replicationHandler) IdentifySystem(ctx context.Context) (SystemIdentity, error) {
+	return SystemIdentity{
+		SystemID: "7000000000000000000",
+		Timeline: 1,
+		XLogPos:  0x16B3748,
+		Database: "postgres",
+	}, nil
+}
+
+func (handler *replicationHandler) CreateReplicationSlot(ctx context.Context, options CreateReplicationSlotOptions) (ReplicationSlot, error) {
+	handler.slot = options
+	return ReplicationSlot{ConsistentPoint: 0x16B3748}, nil
+}
+
+func (handler *replicationHandler) ValidateReplication(ctx context.Context, options StartReplicationOptions) error {
+	if options.Slot != handler.slot.Name {
+		err := fmt.Errorf("replication slot %q does not exist", options.Slot)
+		return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.UndefinedObject), psqlerr.LevelError)
+	}
+
+	return nil
+}
+
+func (handler *replicationHandler) StartReplication(ctx context.Context, options StartReplicationOptions, stream *ReplicationStream) error {
+	handler.start = options
+
+	messages := []ReplicationMessage{
+		ReplicationBegin{FinalLSN: 0x200, CommitTime: time.Now(), Xid: 42},
+		ReplicationRelation{
+			ID:        1,
+			Namespace: "public",
+			Name:      "users",
+			Columns: []ReplicationColumn{
+				{Name: "id", Oid: pgtype.Int4OID, TypeModifier: -1, Key: true},
+				{Name: "name", Oid: pgtype.TextOID, TypeModifier: -1},
+			},
+		},
+		ReplicationInsert{RelationID: 1, New: []any{1, "John"}},
+		ReplicationUpdate{RelationID: 1, Old: []any{1, nil}, KeyOnly: true, New: []any{1, "Jane"}},
+		ReplicationDelete{RelationID: 1, Old: []any{1, nil}, KeyOnly: true},
+		ReplicationCommit{CommitLSN: 0x200, EndLSN: 0x300, CommitTime: time.Now()},
+	}
+
+	for _, message := range messages {
+		err := stream.Send(0x100, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReplication(t *testing.T) {
+	t.Parallel()
+
+	handler := &replicationHandler{}
+	server, err := NewServer(txHandler, Logger(slogt.New(t)), Replication(handler))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgconn.Connect(ctx, fmt.Sprintf("postgres://%s:%d?replication=database&sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	t.Run("identify system", func(t *testing.T) {
+		results, err := conn.Exec(ctx, "IDENTIFY_SYSTEM").ReadAll()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Len(t, results[0].Rows, 1)
+
+		row := results[0].Rows[0]
+		assert.Equal(t, "7000000000000000000", string(row[0]))
+		assert.Equal(t, "1", string(row[1]))
+		assert.Equal(t, "0/16B3748", string(row[2]))
+		assert.Equal(t, "postgres", string(row[3]))
+		assert.Equal(t, "IDENTIFY_SYSTEM", results[0].CommandTag.String())
+	})
+
+	t.Run("create replication slot", func(t *testing.T) {
+		results, err := conn.Exec(ctx, "CREATE_REPLICATION_SLOT sub TEMPORARY LOGICAL pgoutput NOEXPORT_SNAPSHOT").ReadAll()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Len(t, results[0].Rows, 1)
+
+		row := results[0].Rows[0]
+		assert.Equal(t, "sub", string(row[0]))
+		assert.Equal(t, "0/16B3748", string(row[1]))
+		assert.Empty(t, row[2])
+		assert.Equal(t, "pgoutput", string(row[3]))
+
+		assert.Equal(t, CreateReplicationSlotOptions{
+			Name:      "sub",
+			Temporary: true,
+			Plugin:    "pgoutput",
+			Options:   map[string]string{"snapshot": "nothing"},
+		}, handler.slot)
+	})
+
+	t.Run("physical replication slot", func(t *testing.T) {
+		_, err := conn.Exec(ctx, "CREATE_REPLICATION_SLOT sub PHYSICAL").ReadAll()
+		require.Error(t, err)
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, string(codes.FeatureNotSupported), pgErr.Code)
+	})
+
+	t.Run("start replication unknown slot", func(t *testing.T) {
+		_, err := conn.Exec(ctx, "START_REPLICATION SLOT unknown LOGICAL 0/0").ReadAll()
+		require.Error(t, err)
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, string(codes.UndefinedObject), pgErr.Code)
+	})
+
+	t.Run("start replication", func(t *testing.T) {
+		conn.Frontend().SendQuery(&pgproto3.Query{String: "START_REPLICATION SLOT sub LOGICAL 0/16B3748 (proto_version '1', publication_names 'pub')"})
+		require.NoError(t, conn.Frontend().Flush())
+
+		msg, err := conn.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.IsType(t, &pgproto3.CopyBothResponse{}, msg)
+
+		expected := []byte{pgoutputBegin, pgoutputRelation, pgoutputInsert, pgoutputUpdate, pgoutputDelete, pgoutputCommit}
+		var data [][]byte
+		for range expected {
+			msg, err := conn.ReceiveMessage(ctx)
+			require.NoError(t, err)
+			require.IsType(t, &pgproto3.CopyData{}, msg)
+
+			copy := msg.(*pgproto3.CopyData)
+			require.Equal(t, replicationXLogData, copy.Data[0])
+			assert.Equal(t, uint64(0x100), binary.BigEndian.Uint64(copy.Data[1:]))
+			data = append(data, append([]byte{}, copy.Data[25:]...))
+		}
+
+		for index, message := range data {
+			assert.Equal(t, expected[index], message[0])
+		}
+
+		insert := data[2]
+		assert.Equal(t, uint32(1), binary.BigEndian.Uint32(insert[1:]))
+		assert.Equal(t, []byte{'N', 0, 2, 't', 0, 0, 0, 1, '1', 't', 0, 0, 0, 4, 'J', 'o', 'h', 'n'}, insert[5:])
+
+		update := data[3]
+		assert.Equal(t, []byte{'K', 0, 2, 't', 0, 0, 0, 1, '1', 'n', 'N'}, update[5:16])
+
+		assert.Equal(t, StartReplicationOptions{
+			Slot:     "sub",
+			StartLSN: 0x16B3748,
+			Options:  map[string]string{"proto_version": "1", "publication_names": "pub"},
+		}, handler.start)
+
+		status := []byte{replicationStatusUpdate}
+		status = binary.BigEndian.AppendUint64(status, 0x300)
+		status = binary.BigEndian.AppendUint64(status, 0x300)
+		status = binary.BigEndian.AppendUint64(status, 0x300)
+		status = binary.BigEndian.AppendUint64(status, uint64(postgresTimestamp(time.Now())))
+		status = append(status, 1)
+
+		conn.Frontend().Send(&pgproto3.CopyData{Data: status})
+		require.NoError(t, conn.Frontend().Flush())
+
+		msg, err = conn.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.IsType(t, &pgproto3.CopyData{}, msg)
+
+		keepalive := msg.(*pgproto3.CopyData).Data
+		assert.Equal(t, replicationKeepalive, keepalive[0])
+		assert.Equal(t, uint64(0x100), binary.BigEndian.Uint64(keepalive[1:]))
+
+		conn.Frontend().Send(&pgproto3.CopyDone{})
+		require.NoError(t, conn.Frontend().Flush())
+
+		msg, err = conn.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.IsType(t, &pgproto3.CopyDone{}, msg)
+
+		msg, err = conn.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.IsType(t, &pgproto3.CommandComplete{}, msg)
+		assert.Equal(t, "START_REPLICATION", string(msg.(*pgproto3.CommandComplete).CommandTag))
+
+		msg, err = conn.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+	})
+
+	t.Run("identify system after stream", func(t *testing.T) {
+		results, err := conn.Exec(ctx, "IDENTIFY_SYSTEM").ReadAll()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+	})
+}
+
+func TestReplicationUnsupported(t *testing.T) {
+	t.Parallel()
+
+	t.Run("without handler", func(t *testing.T) {
+		server, err := NewServer(txHandler, Logger(slogt.New(t)))
+		require.NoError(t, err)
+
+		address := TListenAndServe(t, server)
+
+		ctx := context.Background()
+		_, err = pgconn.Connect(ctx, fmt.Sprintf("postgres://%s:%d?replication=database&sslmode=disable", address.IP, address.Port))
+		require.Error(t, err)
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, string(codes.FeatureNotSupported), pgErr.Code)
+	})
+
+	t.Run("physical", func(t *testing.T) {
+		server, err := NewServer(txHandler, Logger(slogt.New(t)), Replication(&replicationHandler{}))
+		require.NoError(t, err)
+
+		address := TListenAndServe(t, server)
+
+		ctx := context.Background()
+		_, err = pgconn.Connect(ctx, fmt.Sprintf("postgres://%s:%d?replication=true&sslmode=disable", address.IP, address.Port))
+		require.Error(t, err)
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, string(codes.FeatureNotSupported), pgErr.Code)
+	})
+}
+
+func TestParseLSN(t *testing.T) {
+	t.Parallel()
+
+	lsn, err := ParseLSN("16/B374D848")
+	require.NoError(t, err)
+	assert.Equal(t, LSN(0x16B374D848), lsn)
+	assert.Equal(t, "16/B374D848", lsn.String())
+
+	_, err = ParseLSN("16B374D848")
+	require.Error(t, err)
+}
+
+func TestParseStartReplication(t *testing.T) {
+	t.Parallel()
+
+	tokens, err := tokenizeStatement("SLOT sub PHYSICAL 0/0")
+	require.NoError(t, err)
+
+	_, err = parseStartReplication(tokens)
+	require.Error(t, err)
+	assert.Equal(t, codes.FeatureNotSupported, psqlerr.GetCode(err))
+}
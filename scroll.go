@@ -0,0 +1,237 @@
+package wire
+
+import (
+	"context"
+	"math"
+	"os"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// rowEntry represents a single buffered row. The row data is kept in memory
+// unless the row has been spilled to disk, in which case the offset and length
+// locate the row inside the temporary file.
+type rowEntry struct {
+	data   []byte
+	offset int64
+	length int
+}
+
+// rowBuffer buffers the data row messages produced by a scrollable cursor.
+// Rows are kept in memory until the configured memory limit is exceeded after
+// which all following rows are written to a temporary file.
+type rowBuffer struct {
+	limit  int64
+	dir    string
+	memory int64
+	rows   []rowEntry
+	file   *os.File
+	size   int64
+}
+
+// newRowBuffer constructs a new row buffer using the given configuration.
+func newRowBuffer(config ScrollableCursorsConfig) *rowBuffer {
+	return &rowBuffer{
+		limit: config.MemoryLimit,
+		dir:   config.TempDir,
+	}
+}
+
+// Len returns the amount of buffered rows.
+func (rows *rowBuffer) Len() int64 {
+	return int64(len(rows.rows))
+}
+
+// Append appends a copy of the given row to the buffer.
+func (rows *rowBuffer) Append(row []byte) error {
+	if rows.file == nil && (rows.limit <= 0 || rows.memory+int64(len(row)) <= rows.limit) {
+		rows.memory += int64(len(row))
+		rows.rows = append(rows.rows, rowEntry{data: append([]byte(nil), row...)})
+		return nil
+	}
+
+	if rows.file == nil {
+		file, err := os.CreateTemp(rows.dir, "psql-wire-cursor-*")
+		if err != nil {
+			return err
+		}
+
+		rows.file = file
+	}
+
+	_, err := rows.file.WriteAt(row, rows.size)
+	if err != nil {
+		return err
+	}
+
+	rows.rows = append(rows.rows, rowEntry{offset: rows.size, length: len(row)})
+	rows.size += int64(len(row))
+	return nil
+}
+
+// Get returns the row at the given index starting at 0.
+func (rows *rowBuffer) Get(index int64) ([]byte, error) {
+	entry := rows.rows[index]
+	if entry.data != nil {
+		return entry.data, nil
+	}
+
+	row := make([]byte, entry.length)
+	_, err := rows.file.ReadAt(row, entry.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return row, nil
+}
+
+// Close releases all buffered rows and removes the temporary file if rows
+// have been spilled to disk.
+func (rows *rowBuffer) Close() error {
+	rows.rows = nil
+	if rows.file == nil {
+		return nil
+	}
+
+	name := rows.file.Name()
+	err := rows.file.Close()
+	rows.file = nil
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// scrollCursor moves the given scrollable cursor. Rows are read from the
+// portal backing the cursor only once and buffered, allowing the cursor to
+// be moved in any direction. The rows located at the new positions of the
+// cursor are passed to the given function. The number of rows is returned.
+func (srv *Session) scrollCursor(ctx context.Context, cur *cursor, movement cursorMove, reader *buffer.Reader, writer *buffer.Writer, emit func(row []byte) error) (int64, error) {
+	// fill buffers rows until the given amount of rows has been buffered or
+	// the portal has been exhausted. All rows are buffered if count is
+	// negative.
+	fill := func(count int64) error {
+		if cur.exhausted || (count >= 0 && cur.rows.Len() >= count) {
+			return nil
+		}
+
+		limit := NoLimit
+		if count >= 0 {
+			limit = Limit(min(count-cur.rows.Len(), math.MaxUint32))
+		}
+
+		_, done, err := srv.executeCursor(ctx, cur, limit, reader, writer, cur.rows.Append)
+		if err != nil {
+			return err
+		}
+
+		cur.exhausted = done || limit == NoLimit
+		return nil
+	}
+
+	// current emits the row at the current position if the cursor is located
+	// on a row.
+	current := func() (int64, error) {
+		if cur.position < 1 || cur.position > cur.rows.Len() {
+			return 0, nil
+		}
+
+		row, err := cur.rows.Get(cur.position - 1)
+		if err != nil {
+			return 0, err
+		}
+
+		return 1, emit(row)
+	}
+
+	// seek positions the cursor on the given row, a position beyond the last
+	// row places the cursor after the last row.
+	seek := func(position int64) (int64, error) {
+		if position <= 0 {
+			cur.position = 0
+			return 0, nil
+		}
+
+		err := fill(position)
+		if err != nil {
+			return 0, err
+		}
+
+		cur.position = min(position, cur.rows.Len()+1)
+		return current()
+	}
+
+	count := movement.count
+	if movement.all {
+		count = math.MaxInt64
+	}
+
+	switch movement.direction {
+	case cursorForward:
+		// NOTE: the rows passed are buffered using a single execution of the
+		// portal before they are emitted.
+		target := int64(-1)
+		if !movement.all && count <= math.MaxInt64-cur.position {
+			target = cur.position + count
+		}
+
+		var rows int64
+		for ; rows < count; rows++ {
+			if cur.position >= cur.rows.Len() {
+				err := fill(target)
+				if err != nil {
+					return rows, err
+				}
+			}
+
+			if cur.position >= cur.rows.Len() {
+				cur.position = cur.rows.Len() + 1
+				break
+			}
+
+			cur.position++
+			_, err := current()
+			if err != nil {
+				return rows, err
+			}
+		}
+
+		return rows, nil
+	case cursorBackward:
+		var rows int64
+		for ; rows < count; rows++ {
+			if cur.position <= 1 {
+				cur.position = 0
+				break
+			}
+
+			cur.position--
+			_, err := current()
+			if err != nil {
+				return rows, err
+			}
+		}
+
+		return rows, nil
+	case cursorAbsolute:
+		if count >= 0 {
+			return seek(count)
+		}
+
+		err := fill(-1)
+		if err != nil {
+			return 0, err
+		}
+
+		return seek(cur.rows.Len() + 1 + count)
+	case cursorRelative:
+		if count == 0 {
+			return current()
+		}
+
+		return seek(cur.position + count)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,866 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// newErrUnknownSetting is returned whenever a configuration parameter is
+// requested which has not been set.
+func newErrUnknownSetting(name ParameterStatus) error {
+	err := fmt.Errorf("unrecognized configuration parameter %q", name)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.UndefinedObject), psqlerr.LevelError)
+}
+
+// newErrReadOnlySetting is returned whenever a read-only configuration
+// parameter is attempted to be changed.
+func newErrReadOnlySetting(name ParameterStatus) error {
+	err := fmt.Errorf("parameter %q cannot be changed", name)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.CantChangeRuntimeParam), psqlerr.LevelError)
+}
+
+// newErrUnsupportedEncoding is returned whenever a client encoding other than
+// UTF8 is requested.
+func newErrUnsupportedEncoding(encoding string) error {
+	err := fmt.Errorf("conversion between %s and UTF8 is not supported", encoding)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.FeatureNotSupported), psqlerr.LevelError)
+}
+
+// newErrLocalOutsideTx is the warning send whenever SET LOCAL is used outside
+// a transaction block.
+func newErrLocalOutsideTx() error {
+	err := errors.New("SET LOCAL can only be used in transaction blocks")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.NoActiveSQLTransaction), psqlerr.LevelWarning)
+}
+
+// reportedSettings contains the configuration parameters which are reported to
+// the client using a ParameterStatus message whenever their value changes
+// (GUC_REPORT). The parameters are keyed by their lowercased name.
+// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-ASYNC
+var reportedSettings = map[string]ParameterStatus{
+	"application_name":              ParamApplicationName,
+	"client_encoding":               ParamClientEncoding,
+	"datestyle":                     "DateStyle",
+	"default_transaction_read_only": "default_transaction_read_only",
+	"in_hot_standby":                "in_hot_standby",
+	"integer_datetimes":             "integer_datetimes",
+	"intervalstyle":                 "IntervalStyle",
+	"is_superuser":                  ParamIsSuperuser,
+	"server_encoding":               ParamServerEncoding,
+	"server_version":                ParamServerVersion,
+	"session_authorization":         ParamSessionAuthorization,
+	"standard_conforming_strings":   "standard_conforming_strings",
+	"timezone":                      "TimeZone",
+}
+
+// readOnlySettings contains the configuration parameters which could not be
+// changed during a session.
+var readOnlySettings = []ParameterStatus{
+	ParamServerEncoding,
+	ParamServerVersion,
+	ParamIsSuperuser,
+	ParamSessionAuthorization,
+	"integer_datetimes",
+	"in_hot_standby",
+}
+
+// startupParameters contains the client startup parameters which are not used
+// as configuration parameters.
+var startupParameters = []ParameterStatus{
+	ParamUsername,
+	ParamDatabase,
+	replicationParameter,
+	"options",
+}
+
+// canonicalSetting returns the canonical name of the given configuration
+// parameter. Parameter names are case-insensitive.
+func canonicalSetting(name string) ParameterStatus {
+	lower := strings.ToLower(name)
+	if canonical, has := reportedSettings[lower]; has {
+		return canonical
+	}
+
+	return ParameterStatus(lower)
+}
+
+// reported returns whether changes of the given parameter are reported to the
+// client.
+func reported(name ParameterStatus) bool {
+	_, has := reportedSettings[strings.ToLower(string(name))]
+	return has
+}
+
+// validateSetting checks whether the given parameter could be set to the given
+// value.
+func validateSetting(name ParameterStatus, value string) error {
+	if slices.Contains(readOnlySettings, name) {
+		return newErrReadOnlySetting(name)
+	}
+
+	if name == ParamClientEncoding {
+		switch strings.ToUpper(strings.ReplaceAll(value, "-", "")) {
+		case "UTF8", "UNICODE":
+		default:
+			return newErrUnsupportedEncoding(value)
+		}
+	}
+
+	return nil
+}
+
+// settingScope represents the scope in which a configuration parameter is
+// changed.
+type settingScope int
+
+const (
+	// settingSession represents changes made outside of a command, such as
+	// changes made by the session handler.
+	settingSession settingScope = iota
+	// settingImplicit represents changes made inside an implicit transaction.
+	settingImplicit
+	// settingTx represents changes made inside a transaction block.
+	settingTx
+)
+
+// settingStore contains the configuration parameters of a session. Changes
+// made inside a transaction block are reverted once the transaction is rolled
+// back, changes made using SET LOCAL are reverted once the transaction ends.
+// Changes made after a savepoint are reverted once rolled back to the
+// savepoint. Changes made inside an implicit transaction are reverted once the
+// implicit transaction is rolled back.
+type settingStore struct {
+	mu       sync.Mutex
+	values   Parameters
+	defaults Parameters
+	// pending contains the reported parameters which have changed since the
+	// last ParameterStatus messages were written.
+	pending []ParameterStatus
+	// tx and local contain the values of the parameters before they were
+	// changed inside the current transaction. Nil values represent unset
+	// parameters.
+	tx    map[ParameterStatus]*string
+	local map[ParameterStatus]*string
+	// implicit contains the values of the parameters before they were
+	// changed inside the current implicit transaction.
+	implicit map[ParameterStatus]*string
+	// savepoints contains the state of the parameters before they were first
+	// changed after each of the established savepoints.
+	savepoints []map[ParameterStatus]settingState
+}
+
+// settingState contains the value of a parameter and the value it reverts to
+// once the transaction ends, as recorded by a savepoint.
+type settingState struct {
+	value    *string
+	local    *string
+	hasLocal bool
+}
+
+// seed sets the defaults of the session using the given server and client
+// startup parameters. Client startup parameters take precedence and are
+// reported to the client when they differ from the server parameters.
+func (store *settingStore) seed(server Parameters, client Parameters) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.values = make(Parameters, len(server)+len(client))
+	for key, value := range server {
+		store.values[canonicalSetting(string(key))] = value
+	}
+
+	for key, value := range client {
+		name := canonicalSetting(string(key))
+		if slices.Contains(startupParameters, name) || validateSetting(name, value) != nil {
+			continue
+		}
+
+		store.assign(name, &value)
+	}
+
+	store.defaults = maps.Clone(store.values)
+}
+
+// get returns the value of the given parameter.
+func (store *settingStore) get(name ParameterStatus) (string, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	value, has := store.values[name]
+	return value, has
+}
+
+// all returns a copy of all parameters.
+func (store *settingStore) all() Parameters {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return maps.Clone(store.values)
+}
+
+// set sets the given parameter to the given value. Nil values reset the
+// parameter to its default. The previous value is recorded when the
+// parameter is changed inside an implicit transaction or a transaction block.
+func (store *settingStore) set(name ParameterStatus, value *string, local bool, scope settingScope) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if value == nil {
+		if def, has := store.defaults[name]; has {
+			value = &def
+		}
+	}
+
+	if scope == settingImplicit {
+		if store.implicit == nil {
+			store.implicit = make(map[ParameterStatus]*string)
+		}
+
+		if _, has := store.implicit[name]; !has {
+			store.implicit[name] = store.current(name)
+		}
+	}
+
+	if scope == settingTx {
+		if store.tx == nil {
+			store.tx = make(map[ParameterStatus]*string)
+			store.local = make(map[ParameterStatus]*string)
+		}
+
+		current := store.current(name)
+		if len(store.savepoints) > 0 {
+			changes := store.savepoints[len(store.savepoints)-1]
+			if _, has := changes[name]; !has {
+				local, hasLocal := store.local[name]
+				changes[name] = settingState{value: current, local: local, hasLocal: hasLocal}
+			}
+		}
+
+		if _, has := store.tx[name]; !has {
+			store.tx[name] = current
+		}
+
+		if _, has := store.local[name]; local && !has {
+			store.local[name] = current
+		}
+
+		if !local {
+			delete(store.local, name)
+		}
+	}
+
+	store.assign(name, value)
+}
+
+// end reverts the parameters changed inside the ended transaction. All
+// changes are reverted when the transaction has been rolled back, otherwise
+// only the changes made using SET LOCAL are reverted.
+func (store *settingStore) end(commit bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	revert := store.tx
+	if commit {
+		revert = store.local
+	}
+
+	for name, value := range revert {
+		store.assign(name, value)
+	}
+
+	store.tx = nil
+	store.local = nil
+	store.implicit = nil
+	store.savepoints = nil
+}
+
+// begin marks the start of a transaction block. Parameters changed inside the
+// preceding implicit transaction become part of the transaction block.
+func (store *settingStore) begin() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(store.implicit) == 0 {
+		return
+	}
+
+	if store.tx == nil {
+		store.tx = make(map[ParameterStatus]*string)
+		store.local = make(map[ParameterStatus]*string)
+	}
+
+	for name, value := range store.implicit {
+		if _, has := store.tx[name]; !has {
+			store.tx[name] = value
+		}
+	}
+
+	store.implicit = nil
+}
+
+// endImplicit reverts the parameters changed inside the ended implicit
+// transaction when it has been rolled back.
+func (store *settingStore) endImplicit(commit bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if !commit {
+		for name, value := range store.implicit {
+			store.assign(name, value)
+		}
+	}
+
+	store.implicit = nil
+}
+
+// savepoint records the establishment of a new savepoint inside the current
+// transaction.
+func (store *settingStore) savepoint() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.savepoints = append(store.savepoints, make(map[ParameterStatus]settingState))
+}
+
+// release releases the savepoint at the given index and all savepoints
+// established after it. The recorded changes are kept by the preceding
+// savepoint.
+func (store *settingStore) release(index int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if index < 0 || index >= len(store.savepoints) {
+		return
+	}
+
+	if index > 0 {
+		previous := store.savepoints[index-1]
+		for _, changes := range store.savepoints[index:] {
+			for name, state := range changes {
+				if _, has := previous[name]; !has {
+					previous[name] = state
+				}
+			}
+		}
+	}
+
+	store.savepoints = store.savepoints[:index]
+}
+
+// rollback reverts the parameters changed after the savepoint at the given
+// index was established. The savepoint itself remains established.
+func (store *settingStore) rollback(index int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if index < 0 || index >= len(store.savepoints) {
+		return
+	}
+
+	for i := len(store.savepoints) - 1; i >= index; i-- {
+		for name, state := range store.savepoints[i] {
+			store.assign(name, state.value)
+			if state.hasLocal {
+				store.local[name] = state.local
+			} else {
+				delete(store.local, name)
+			}
+		}
+	}
+
+	store.savepoints = store.savepoints[:index+1]
+	store.savepoints[index] = make(map[ParameterStatus]settingState)
+}
+
+// current returns a pointer to a copy of the current value of the given
+// parameter. The caller is expected to hold the store lock.
+func (store *settingStore) current(name ParameterStatus) *string {
+	value, has := store.values[name]
+	if !has {
+		return nil
+	}
+
+	return &value
+}
+
+// assign assigns the given value to the given parameter and marks reported
+// parameters as pending when the value has changed. The caller is expected
+// to hold the store lock.
+func (store *settingStore) assign(name ParameterStatus, value *string) {
+	if store.values == nil {
+		store.values = make(Parameters)
+	}
+
+	previous, had := store.values[name]
+	if value == nil {
+		delete(store.values, name)
+	} else {
+		store.values[name] = *value
+	}
+
+	changed := had != (value != nil) || (value != nil && previous != *value)
+	if changed && reported(name) && !slices.Contains(store.pending, name) {
+		store.pending = append(store.pending, name)
+	}
+}
+
+// report writes a ParameterStatus message for all reported parameters which
+// have changed since the last report.
+func (store *settingStore) report(writer *buffer.Writer) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, name := range store.pending {
+		err := writeParameterStatus(writer, name, store.values[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	store.pending = nil
+	return nil
+}
+
+// GetSetting returns the value of the given configuration parameter of the
+// session inside the given context. The parameters of a session are seeded
+// using the server parameters and the client startup parameters. The second
+// return value indicates whether the parameter has been set.
+func GetSetting(ctx context.Context, name string) (string, bool) {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return "", false
+	}
+
+	return session.settings.get(canonicalSetting(name))
+}
+
+// SetSetting sets the given configuration parameter of the session inside the
+// given context. Changes of parameters such as TimeZone and application_name
+// are reported to the client using a ParameterStatus message before the next
+// ReadyForQuery message. Changes made inside a transaction block are reverted
+// when the transaction is rolled back.
+func SetSetting(ctx context.Context, name string, value string) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	return session.setSetting(canonicalSetting(name), &value, false)
+}
+
+// ResetSetting resets the given configuration parameter of the session inside
+// the given context to its default value.
+func ResetSetting(ctx context.Context, name string) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	return session.setSetting(canonicalSetting(name), nil, false)
+}
+
+// Settings returns a copy of all configuration parameters of the session
+// inside the given context.
+func Settings(ctx context.Context) Parameters {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return nil
+	}
+
+	return session.settings.all()
+}
+
+// setSetting validates and sets the given parameter. Nil values reset the
+// parameter to its default.
+func (srv *Session) setSetting(name ParameterStatus, value *string, local bool) error {
+	if value != nil {
+		err := validateSetting(name, *value)
+		if err != nil {
+			return err
+		}
+	} else if slices.Contains(readOnlySettings, name) {
+		return newErrReadOnlySetting(name)
+	}
+
+	srv.settings.set(name, value, local, srv.settingScope())
+	return nil
+}
+
+// resetSettings resets all parameters which could be changed to their
+// defaults.
+func (srv *Session) resetSettings() {
+	for name := range srv.settings.all() {
+		if slices.Contains(readOnlySettings, name) {
+			continue
+		}
+
+		srv.settings.set(name, nil, false, srv.settingScope())
+	}
+}
+
+// settingScope returns the scope in which configuration parameters are
+// currently changed. Changes made while a command is handled outside a
+// transaction block are part of the implicit transaction of the command.
+func (srv *Session) settingScope() settingScope {
+	switch {
+	case inTxBlock(srv.TxStatus()):
+		return settingTx
+	case srv.command.Load() != 0:
+		return settingImplicit
+	default:
+		return settingSession
+	}
+}
+
+// parseQuery parses the given query into prepared statements. SET, SHOW and
+// RESET statements, and the set_config and current_setting functions, are
+// handled by the session when session settings are enabled. All other queries
+// are parsed using the configured parse function.
+func (srv *Session) parseQuery(ctx context.Context, query string) (PreparedStatements, error) {
+	if srv.SessionSettings {
+		statement, err := srv.parseSettingStatement(query)
+		if err != nil {
+			return nil, err
+		}
+
+		if statement != nil {
+			return Prepared(statement), nil
+		}
+	}
+
+	return srv.parse(ctx, query)
+}
+
+// parseSettingStatement returns the prepared statement handling the given
+// query when it contains a single settings statement. Nil is returned when the
+// query is not a settings statement.
+func (srv *Session) parseSettingStatement(query string) (*PreparedStatement, error) {
+	if multipleStatements(query) {
+		return nil, nil
+	}
+
+	tokens, err := tokenizeStatement(query)
+	if err != nil || len(tokens) == 0 || tokens[0].literal {
+		return nil, nil
+	}
+
+	tokens = splitAssignments(tokens)
+	switch tokens[0].value {
+	case "set":
+		return srv.parseSet(tokens[1:]), nil
+	case "reset":
+		return srv.parseReset(tokens[1:]), nil
+	case "show":
+		return srv.parseShow(tokens[1:]), nil
+	case "select":
+		return srv.parseSettingFunction(tokens[1:]), nil
+	}
+
+	return nil, nil
+}
+
+// parseSet parses the SET statement defined inside the given tokens:
+// SET [ SESSION | LOCAL ] name { TO | = } { value [, ...] | DEFAULT } or
+// SET [ SESSION | LOCAL ] TIME ZONE { value | LOCAL | DEFAULT }.
+func (srv *Session) parseSet(tokens []statementToken) *PreparedStatement {
+	var local bool
+	if len(tokens) > 0 && keywordToken(tokens[0], "local", "session") {
+		local = tokens[0].value == "local"
+		tokens = tokens[1:]
+	}
+
+	var name ParameterStatus
+	switch {
+	case len(tokens) > 2 && keywordToken(tokens[0], "time") && keywordToken(tokens[1], "zone"):
+		name = canonicalSetting("timezone")
+		tokens = tokens[2:]
+		if len(tokens) == 1 && keywordToken(tokens[0], "local") {
+			tokens[0].value = "default"
+		}
+	case len(tokens) > 2 && !tokens[0].literal && !tokens[0].punct && keywordToken(tokens[1], "to", "="):
+		name = canonicalSetting(tokens[0].value)
+		tokens = tokens[2:]
+	default:
+		return nil
+	}
+
+	value, ok := settingValue(tokens)
+	if !ok {
+		return nil
+	}
+
+	handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+		if local && !inTxBlock(srv.TxStatus()) {
+			err := notice(ctx, newErrLocalOutsideTx())
+			if err != nil {
+				return err
+			}
+
+			return writer.Complete("SET")
+		}
+
+		err := srv.setSetting(name, value, local)
+		if err != nil {
+			return err
+		}
+
+		return writer.Complete("SET")
+	}
+
+	return NewStatement(handle)
+}
+
+// parseReset parses the RESET statement defined inside the given tokens:
+// RESET name, RESET TIME ZONE or RESET ALL.
+func (srv *Session) parseReset(tokens []statementToken) *PreparedStatement {
+	var name ParameterStatus
+	switch {
+	case len(tokens) == 2 && keywordToken(tokens[0], "time") && keywordToken(tokens[1], "zone"):
+		name = canonicalSetting("timezone")
+	case len(tokens) == 1 && keywordToken(tokens[0], "all"):
+	case len(tokens) == 1 && !tokens[0].literal && !tokens[0].punct:
+		name = canonicalSetting(tokens[0].value)
+	default:
+		return nil
+	}
+
+	handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+		if name == "" {
+			srv.resetSettings()
+			return writer.Complete("RESET")
+		}
+
+		err := srv.setSetting(name, nil, false)
+		if err != nil {
+			return err
+		}
+
+		return writer.Complete("RESET")
+	}
+
+	return NewStatement(handle)
+}
+
+// parseShow parses the SHOW statement defined inside the given tokens:
+// SHOW name, SHOW TIME ZONE or SHOW ALL.
+func (srv *Session) parseShow(tokens []statementToken) *PreparedStatement {
+	switch {
+	case len(tokens) == 2 && keywordToken(tokens[0], "time") && keywordToken(tokens[1], "zone"):
+		return srv.showSetting(canonicalSetting("timezone"))
+	case len(tokens) == 1 && keywordToken(tokens[0], "all"):
+	case len(tokens) == 1 && !tokens[0].literal && !tokens[0].punct:
+		return srv.showSetting(canonicalSetting(tokens[0].value))
+	default:
+		return nil
+	}
+
+	columns := Columns{
+		{Name: "name", Oid: pgtype.TextOID, Width: -1},
+		{Name: "setting", Oid: pgtype.TextOID, Width: -1},
+		{Name: "description", Oid: pgtype.TextOID, Width: -1},
+	}
+
+	handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+		settings := srv.settings.all()
+		for _, name := range slices.Sorted(maps.Keys(settings)) {
+			err := writer.Row([]any{string(name), settings[name], ""})
+			if err != nil {
+				return err
+			}
+		}
+
+		return writer.Complete("SHOW")
+	}
+
+	return NewStatement(handle, WithColumns(columns))
+}
+
+// showSetting returns a statement writing the value of the given parameter.
+func (srv *Session) showSetting(name ParameterStatus) *PreparedStatement {
+	columns := Columns{
+		{Name: string(name), Oid: pgtype.TextOID, Width: -1},
+	}
+
+	handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+		value, has := srv.settings.get(name)
+		if !has {
+			return newErrUnknownSetting(name)
+		}
+
+		err := writer.Row([]any{value})
+		if err != nil {
+			return err
+		}
+
+		return writer.Complete("SHOW")
+	}
+
+	return NewStatement(handle, WithColumns(columns))
+}
+
+// parseSettingFunction parses the SELECT statement defined inside the given
+// tokens when it only calls set_config(name, value, is_local) or
+// current_setting(name [, missing_ok]).
+func (srv *Session) parseSettingFunction(tokens []statementToken) *PreparedStatement {
+	if len(tokens) < 3 || !keywordToken(tokens[1], "(") || !keywordToken(tokens[len(tokens)-1], ")") {
+		return nil
+	}
+
+	if tokens[0].literal || tokens[0].punct {
+		return nil
+	}
+
+	function := tokens[0].value
+	var args []string
+	for index, token := range tokens[2 : len(tokens)-1] {
+		if index%2 == 1 {
+			if !keywordToken(token, ",") {
+				return nil
+			}
+
+			continue
+		}
+
+		if token.punct {
+			return nil
+		}
+
+		args = append(args, token.value)
+	}
+
+	columns := Columns{
+		{Name: function, Oid: pgtype.TextOID, Width: -1},
+	}
+
+	var handle PreparedStatementFn
+	switch {
+	case function == "set_config" && len(args) == 3:
+		local, err := copyBool(function, []statementToken{{value: args[2]}})
+		if err != nil {
+			return nil
+		}
+
+		name := canonicalSetting(args[0])
+		value := args[1]
+		handle = func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			if !local || inTxBlock(srv.TxStatus()) {
+				err := srv.setSetting(name, &value, local)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := writer.Row([]any{value})
+			if err != nil {
+				return err
+			}
+
+			return writer.Complete("SELECT 1")
+		}
+	case function == "current_setting" && (len(args) == 1 || len(args) == 2):
+		var missing bool
+		if len(args) == 2 {
+			var err error
+			missing, err = copyBool(function, []statementToken{{value: args[1]}})
+			if err != nil {
+				return nil
+			}
+		}
+
+		name := canonicalSetting(args[0])
+		handle = func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			var result any
+			value, has := srv.settings.get(name)
+			switch {
+			case has:
+				result = value
+			case !missing:
+				return newErrUnknownSetting(name)
+			}
+
+			err := writer.Row([]any{result})
+			if err != nil {
+				return err
+			}
+
+			return writer.Complete("SELECT 1")
+		}
+	default:
+		return nil
+	}
+
+	return NewStatement(handle, WithColumns(columns))
+}
+
+// settingValue returns the value defined inside the given tokens. Multiple
+// values are joined using a comma. Nil is returned for DEFAULT values. False
+// is returned when the tokens do not contain a valid value.
+func settingValue(tokens []statementToken) (*string, bool) {
+	if len(tokens) == 1 && keywordToken(tokens[0], "default") {
+		return nil, true
+	}
+
+	var values []string
+	for index, token := range tokens {
+		if index%2 == 1 {
+			if !keywordToken(token, ",") {
+				return nil, false
+			}
+
+			continue
+		}
+
+		if token.punct {
+			return nil, false
+		}
+
+		values = append(values, token.value)
+	}
+
+	if len(values) == 0 || len(tokens)%2 == 0 {
+		return nil, false
+	}
+
+	value := strings.Join(values, ", ")
+	return &value, true
+}
+
+// keywordToken returns whether the given token is not a literal and equal to
+// one of the given keywords or punctuations.
+func keywordToken(token statementToken, keywords ...string) bool {
+	return !token.literal && slices.Contains(keywords, token.value)
+}
+
+// splitAssignments splits the unquoted tokens containing an equals sign, such
+// as name=value, into separate tokens.
+func splitAssignments(tokens []statementToken) []statementToken {
+	result := make([]statementToken, 0, len(tokens))
+	for _, token := range tokens {
+		if token.literal || token.punct || token.value == "=" || !strings.Contains(token.value, "=") {
+			result = append(result, token)
+			continue
+		}
+
+		for index, part := range strings.Split(token.value, "=") {
+			if index > 0 {
+				result = append(result, statementToken{value: "="})
+			}
+
+			if part != "" {
+				result = append(result, statementToken{value: part})
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,276 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionSettings(t *testing.T) {
+	t.Parallel()
+
+	params := Parameters{"timezone": "UTC"}
+	server, err := NewServer(txHandler, Logger(slogt.New(t)), GlobalParameters(params), SessionSettings())
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=disable&application_name=tests", address.IP, address.Port))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var notices []*pgconn.Notice
+	config.OnNotice = func(conn *pgconn.PgConn, notice *pgconn.Notice) {
+		mu.Lock()
+		defer mu.Unlock()
+		notices = append(notices, notice)
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	pg := conn.PgConn()
+
+	exec := func(t *testing.T, query string) {
+		_, err := pg.Exec(ctx, query).ReadAll()
+		require.NoError(t, err)
+	}
+
+	show := func(t *testing.T, name string) string {
+		results, err := pg.Exec(ctx, "SHOW "+name).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Len(t, results[0].Rows, 1)
+		return string(results[0].Rows[0][0])
+	}
+
+	code := func(t *testing.T, err error) string {
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		return pgErr.Code
+	}
+
+	t.Run("startup", func(t *testing.T) {
+		assert.Equal(t, "tests", pg.ParameterStatus("application_name"))
+		assert.Equal(t, "UTC", show(t, "TimeZone"))
+		assert.Equal(t, "tests", show(t, "application_name"))
+	})
+
+	t.Run("set", func(t *testing.T) {
+		exec(t, "SET TimeZone TO 'Europe/Amsterdam'")
+		assert.Equal(t, "Europe/Amsterdam", pg.ParameterStatus("TimeZone"))
+		assert.Equal(t, "Europe/Amsterdam", show(t, "timezone"))
+
+		exec(t, "SET application_name='other'")
+		assert.Equal(t, "other", pg.ParameterStatus("application_name"))
+
+		exec(t, "SET search_path TO public, other")
+		assert.Equal(t, "public, other", show(t, "search_path"))
+
+		exec(t, "SET search_path TO $$a;b$$ -- comment")
+		assert.Equal(t, "a;b", show(t, "search_path"))
+
+		exec(t, "SET SESSION TIME ZONE 'Asia/Tokyo'")
+		assert.Equal(t, "Asia/Tokyo", pg.ParameterStatus("TimeZone"))
+	})
+
+	t.Run("show column", func(t *testing.T) {
+		results, err := pg.Exec(ctx, "SHOW timezone").ReadAll()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "TimeZone", results[0].FieldDescriptions[0].Name)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		exec(t, "RESET timezone")
+		assert.Equal(t, "UTC", pg.ParameterStatus("TimeZone"))
+
+		exec(t, "SET my.setting = 42")
+		assert.Equal(t, "42", show(t, "my.setting"))
+
+		exec(t, "RESET ALL")
+		assert.Equal(t, "tests", pg.ParameterStatus("application_name"))
+
+		_, err := pg.Exec(ctx, "SHOW my.setting").ReadAll()
+		require.Error(t, err)
+		assert.Equal(t, string(codes.UndefinedObject), code(t, err))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		exec(t, "BEGIN")
+		exec(t, "SET TimeZone TO 'Europe/Amsterdam'")
+		assert.Equal(t, "Europe/Amsterdam", pg.ParameterStatus("TimeZone"))
+		exec(t, "ROLLBACK")
+
+		assert.Equal(t, "UTC", pg.ParameterStatus("TimeZone"))
+		assert.Equal(t, "UTC", show(t, "TimeZone"))
+	})
+
+	t.Run("implicit rollback", func(t *testing.T) {
+		batch := &pgconn.Batch{}
+		batch.ExecParams("SET TimeZone TO 'Europe/Amsterdam'", nil, nil, nil, nil)
+		batch.ExecParams("FAIL", nil, nil, nil, nil)
+		_, err := pg.ExecBatch(ctx, batch).ReadAll()
+		require.Error(t, err)
+
+		assert.Equal(t, "UTC", pg.ParameterStatus("TimeZone"))
+		assert.Equal(t, "UTC", show(t, "TimeZone"))
+
+		batch = &pgconn.Batch{}
+		batch.ExecParams("SET TimeZone TO 'Europe/Amsterdam'", nil, nil, nil, nil)
+		batch.ExecParams("SELECT 1", nil, nil, nil, nil)
+		_, err = pg.ExecBatch(ctx, batch).ReadAll()
+		require.NoError(t, err)
+
+		assert.Equal(t, "Europe/Amsterdam", show(t, "TimeZone"))
+		exec(t, "RESET TimeZone")
+	})
+
+	t.Run("savepoint", func(t *testing.T) {
+		exec(t, "BEGIN")
+		exec(t, "SET TimeZone TO 'Europe/Amsterdam'")
+		exec(t, "SAVEPOINT a")
+		exec(t, "SET TimeZone TO 'Asia/Tokyo'")
+		exec(t, "SET LOCAL application_name TO 'local'")
+		exec(t, "SAVEPOINT b")
+		exec(t, "SET TimeZone TO 'America/New_York'")
+		exec(t, "RELEASE SAVEPOINT b")
+		exec(t, "ROLLBACK TO SAVEPOINT a")
+
+		assert.Equal(t, "Europe/Amsterdam", pg.ParameterStatus("TimeZone"))
+		assert.Equal(t, "tests", show(t, "application_name"))
+
+		exec(t, "SET TimeZone TO 'Asia/Tokyo'")
+		exec(t, "ROLLBACK TO SAVEPOINT a")
+		assert.Equal(t, "Europe/Amsterdam", show(t, "TimeZone"))
+
+		exec(t, "ROLLBACK")
+		assert.Equal(t, "UTC", pg.ParameterStatus("TimeZone"))
+	})
+
+	t.Run("local", func(t *testing.T) {
+		exec(t, "BEGIN")
+		exec(t, "SET LOCAL application_name TO 'local'")
+		exec(t, "SET TimeZone TO 'Europe/Amsterdam'")
+		assert.Equal(t, "local", show(t, "application_name"))
+		exec(t, "COMMIT")
+
+		assert.Equal(t, "tests", pg.ParameterStatus("application_name"))
+		assert.Equal(t, "Europe/Amsterdam", pg.ParameterStatus("TimeZone"))
+		exec(t, "RESET TIME ZONE")
+	})
+
+	t.Run("local outside transaction", func(t *testing.T) {
+		exec(t, "SET LOCAL application_name TO 'local'")
+		assert.Equal(t, "tests", show(t, "application_name"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotEmpty(t, notices)
+		assert.Equal(t, "WARNING", notices[len(notices)-1].Severity)
+		assert.Equal(t, string(codes.NoActiveSQLTransaction), notices[len(notices)-1].Code)
+	})
+
+	t.Run("functions", func(t *testing.T) {
+		var value string
+		err := conn.QueryRow(ctx, "SELECT set_config('application_name', 'config', false)").Scan(&value)
+		require.NoError(t, err)
+		assert.Equal(t, "config", value)
+		assert.Equal(t, "config", pg.ParameterStatus("application_name"))
+
+		err = conn.QueryRow(ctx, "SELECT current_setting('TimeZone')").Scan(&value)
+		require.NoError(t, err)
+		assert.Equal(t, "UTC", value)
+
+		var missing *string
+		err = conn.QueryRow(ctx, "SELECT current_setting('unknown.setting', true)").Scan(&missing)
+		require.NoError(t, err)
+		assert.Nil(t, missing)
+
+		err = conn.QueryRow(ctx, "SELECT current_setting('unknown.setting')").Scan(&value)
+		require.Error(t, err)
+		assert.Equal(t, string(codes.UndefinedObject), code(t, err))
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		_, err := pg.Exec(ctx, "SET server_version TO '1'").ReadAll()
+		require.Error(t, err)
+		assert.Equal(t, string(codes.CantChangeRuntimeParam), code(t, err))
+
+		_, err = pg.Exec(ctx, "SET client_encoding TO 'LATIN1'").ReadAll()
+		require.Error(t, err)
+		assert.Equal(t, string(codes.FeatureNotSupported), code(t, err))
+	})
+
+	t.Run("show all", func(t *testing.T) {
+		results, err := pg.Exec(ctx, "SHOW ALL").ReadAll()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+
+		settings := make(map[string]string)
+		for _, row := range results[0].Rows {
+			settings[string(row[0])] = string(row[1])
+		}
+
+		assert.Equal(t, "UTC", settings["TimeZone"])
+		assert.Equal(t, "UTF8", settings["client_encoding"])
+	})
+
+	t.Run("multiple statements", func(t *testing.T) {
+		exec(t, "SET other.setting TO 1; SELECT 1")
+
+		_, err := pg.Exec(ctx, "SHOW other.setting").ReadAll()
+		require.Error(t, err)
+	})
+}
+
+func TestSettingStatements(t *testing.T) {
+	t.Parallel()
+
+	session := &Session{Server: &Server{SessionSettings: true}}
+
+	tests := map[string]bool{
+		"SET a TO 1":                         true,
+		"SET a=1;":                           true,
+		"SET LOCAL a = 'b'":                  true,
+		"SET TIME ZONE LOCAL":                true,
+		"SET TRANSACTION ISOLATION LEVEL x":  false,
+		"SET SESSION AUTHORIZATION DEFAULT":  false,
+		"SHOW ALL":                           true,
+		"SHOW TRANSACTION ISOLATION LEVEL":   false,
+		"RESET a":                            true,
+		"SELECT set_config('a', 'b', false)": true,
+		"SELECT current_setting('a') AS c":   false,
+		"SELECT 1":                           false,
+		"SET a TO 1; SET b TO 2":             false,
+		"SET a TO ';'":                       true,
+		"SET a TO $$;$$":                     true,
+		"SET a TO $tag$;$$;$tag$":            true,
+		"SET a TO E'\\';'":                   true,
+		"SET a TO 1 -- ; SET b TO 2":         true,
+		"SET a TO 1 /* ; /* ; */ ; */":       true,
+		"SET a TO 1; -- trailing":            true,
+		"-- SET a TO 1\nSELECT 1":            false,
+		"/* SET a TO 1 */ SELECT 1":          false,
+		"SELECT $$SET a TO 1$$":              false,
+		"SELECT 1 /* ; */; SET a TO 1":       false,
+	}
+
+	for query, expected := range tests {
+		t.Run(query, func(t *testing.T) {
+			statement, err := session.parseSettingStatement(query)
+			require.NoError(t, err)
+			assert.Equal(t, expected, statement != nil)
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package wire
+
+import "strings"
+
+// statementToken represents a single token inside a statement. Keywords and
+// unquoted identifiers are lowercased. Literal is set for string literals and
+// quoted is set for quoted identifiers. The offset is the position of the
+// first byte of the token inside the statement.
+type statementToken struct {
+	value   string
+	literal bool
+	quoted  bool
+	punct   bool
+	offset  int
+}
+
+// keyword returns whether the token is the given (lowercase) keyword.
+func (token statementToken) keyword(keyword string) bool {
+	return !token.literal && !token.quoted && !token.punct && token.value == keyword
+}
+
+// identifier returns whether the token is a quoted or unquoted identifier.
+func (token statementToken) identifier() bool {
+	return !token.literal && !token.punct && token.value != ""
+}
+
+// tokenizeStatement splits the given statement into tokens. Comments are
+// skipped and dollar-quoted strings are returned as literals.
+func tokenizeStatement(statement string) ([]statementToken, error) {
+	var tokens []statementToken
+	for index := 0; index < len(statement); {
+		char, start := statement[index], index
+		if length, ok, err := statementComment(statement[index:]); ok {
+			if err != nil {
+				return nil, err
+			}
+
+			index += length
+			continue
+		}
+
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == ';':
+			index++
+		case char == '(' || char == ')' || char == ',' || char == '*':
+			tokens = append(tokens, statementToken{value: string(char), punct: true, offset: start})
+			index++
+		case char == '\'' || ((char == 'E' || char == 'e') && index+1 < len(statement) && statement[index+1] == '\''):
+			escaped := char != '\''
+			if escaped {
+				index++
+			}
+
+			value, length, err := statementLiteral(statement[index:], '\'', escaped)
+			if err != nil {
+				return nil, err
+			}
+
+			if escaped {
+				value = string(unescapeCopyText([]byte(value)))
+			}
+
+			tokens = append(tokens, statementToken{value: value, literal: true, offset: start})
+			index += length
+		case char == '"':
+			value, length, err := statementLiteral(statement[index:], '"', false)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, statementToken{value: value, quoted: true, offset: start})
+			index += length
+		case char == '$' && dollarTag(statement[index:]) != "":
+			value, length, err := dollarLiteral(statement[index:])
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, statementToken{value: value, literal: true, offset: start})
+			index += length
+		default:
+			end := index
+			for end < len(statement) && !strings.ContainsRune(" \t\n\r;(),*'\"", rune(statement[end])) {
+				if _, ok, _ := statementComment(statement[end:]); ok && end > index {
+					break
+				}
+
+				end++
+			}
+
+			tokens = append(tokens, statementToken{value: strings.ToLower(statement[index:end]), offset: start})
+			index = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// statementLiteral reads the literal enclosed by the given quote character at the
+// start of the given input. Quote characters inside the literal are escaped
+// by doubling them, or using a backslash inside escape string literals. The
+// literal value and the consumed length are returned.
+func statementLiteral(input string, quote byte, escaped bool) (string, int, error) {
+	var value strings.Builder
+	for index := 1; index < len(input); index++ {
+		if escaped && input[index] == '\\' && index+1 < len(input) {
+			// NOTE: backslashes are preserved and only prevent the next
+			// character from closing the literal. Escape string literals
+			// decode the backslash sequences afterwards.
+			value.WriteByte(input[index])
+			index++
+			value.WriteByte(input[index])
+			continue
+		}
+
+		if input[index] != quote {
+			value.WriteByte(input[index])
+			continue
+		}
+
+		if index+1 < len(input) && input[index+1] == quote {
+			value.WriteByte(quote)
+			index++
+			continue
+		}
+
+		return value.String(), index + 1, nil
+	}
+
+	return "", 0, newErrCopySyntax("unterminated quoted string")
+}
+
+// statementComment returns the length of the comment at the start of the
+// given input. Line comments run until the end of the line, block comments
+// could be nested. The second return value indicates whether the input starts
+// with a comment, an error is returned when a block comment is unterminated.
+func statementComment(input string) (int, bool, error) {
+	if strings.HasPrefix(input, "--") {
+		end := strings.IndexByte(input, '\n')
+		if end < 0 {
+			return len(input), true, nil
+		}
+
+		return end + 1, true, nil
+	}
+
+	if !strings.HasPrefix(input, "/*") {
+		return 0, false, nil
+	}
+
+	depth := 0
+	for index := 0; index+1 < len(input); index++ {
+		switch input[index : index+2] {
+		case "/*":
+			depth++
+			index++
+		case "*/":
+			depth--
+			index++
+			if depth == 0 {
+				return index + 1, true, nil
+			}
+		}
+	}
+
+	return len(input), true, newErrCopySyntax("unterminated /* comment")
+}
+
+// dollarTag returns the opening tag of the dollar-quoted string at the start
+// of the given input, including both dollar signs. An empty string is returned
+// when the input does not start with a dollar quote. Tags follow the rules of
+// unquoted identifiers and could not start with a digit, which distinguishes
+// them from positional parameters such as $1.
+func dollarTag(input string) string {
+	if len(input) < 2 || input[0] != '$' {
+		return ""
+	}
+
+	for index := 1; index < len(input); index++ {
+		char := input[index]
+		switch {
+		case char == '$':
+			return input[:index+1]
+		case char >= '0' && char <= '9':
+			if index == 1 {
+				return ""
+			}
+		case char != '_' && char < 0x80 && !(char >= 'a' && char <= 'z') && !(char >= 'A' && char <= 'Z'):
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// dollarLiteral reads the dollar-quoted string at the start of the given input.
+// The literal value and the consumed length are returned.
+func dollarLiteral(input string) (string, int, error) {
+	tag := dollarTag(input)
+	end := strings.Index(input[len(tag):], tag)
+	if end < 0 {
+		return "", 0, newErrCopySyntax("unterminated dollar-quoted string")
+	}
+
+	return input[len(tag) : len(tag)+end], len(tag)*2 + end, nil
+}
+
+// identifierChar returns whether the given character could be part of an
+// unquoted identifier.
+func identifierChar(char byte) bool {
+	return char == '_' || char == '$' || char >= 0x80 ||
+		(char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
+
+// multipleStatements returns whether the given query contains multiple
+// statements separated by semicolons. Semicolons inside quoted strings,
+// dollar-quoted strings, quoted identifiers and comments are ignored, as are
+// trailing semicolons and comments.
+func multipleStatements(query string) bool {
+	var separated bool
+	for index := 0; index < len(query); {
+		if length, ok, _ := statementComment(query[index:]); ok {
+			index += length
+			continue
+		}
+
+		char := query[index]
+		switch char {
+		case ';':
+			separated = true
+			index++
+			continue
+		case ' ', '\t', '\n', '\r':
+			index++
+			continue
+		}
+
+		if separated {
+			return true
+		}
+
+		boundary := index == 0 || !identifierChar(query[index-1])
+		switch {
+		case char == '\'' || char == '"':
+			index += quotedLength(query[index:], char, false)
+		case (char == 'E' || char == 'e') && boundary && strings.HasPrefix(query[index+1:], "'"):
+			index += 1 + quotedLength(query[index+1:], '\'', true)
+		case char == '$' && boundary && dollarTag(query[index:]) != "":
+			_, length, err := dollarLiteral(query[index:])
+			if err != nil {
+				return false
+			}
+
+			index += length
+		default:
+			index++
+		}
+	}
+
+	return false
+}
+
+// quotedLength returns the length of the literal enclosed by the given quote
+// character at the start of the given input. The remaining length of the input
+// is returned when the literal is unterminated.
+func quotedLength(input string, quote byte, escaped bool) int {
+	_, length, err := statementLiteral(input, quote, escaped)
+	if err != nil {
+		return len(input)
+	}
+
+	return length
+}
@@ -0,0 +1,463 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// ErrNoSession is returned whenever the transaction state is altered using a
+// context which does not contain a session.
+var ErrNoSession = errors.New("no session has been found inside the given context")
+
+// newErrActiveTransaction is send as a warning whenever a transaction block is
+// started while a transaction is already in progress.
+func newErrActiveTransaction() error {
+	err := errors.New("there is already a transaction in progress")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.ActiveSQLTransaction), psqlerr.LevelWarning)
+}
+
+// newErrNoActiveTransaction is send as a warning whenever a transaction block
+// is ended while no transaction is in progress.
+func newErrNoActiveTransaction() error {
+	err := errors.New("there is no transaction in progress")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.NoActiveSQLTransaction), psqlerr.LevelWarning)
+}
+
+// newErrInFailedTransaction is returned whenever a statement is issued while
+// the current transaction has failed.
+func newErrInFailedTransaction() error {
+	err := errors.New("current transaction is aborted, commands ignored until end of transaction block")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.InFailedSQLTransaction), psqlerr.LevelError)
+}
+
+// newErrSavepointOutsideTx is returned whenever a savepoint command is issued
+// outside a transaction block.
+func newErrSavepointOutsideTx(command string) error {
+	err := fmt.Errorf("%s can only be used in transaction blocks", command)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.NoActiveSQLTransaction), psqlerr.LevelError)
+}
+
+// newErrUnknownSavepoint is returned whenever a savepoint is referenced which
+// does not exist.
+func newErrUnknownSavepoint(name string) error {
+	err := fmt.Errorf("savepoint %q does not exist", name)
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.InvalidSavepointSpecification), psqlerr.LevelError)
+}
+
+// TransactionHandler is notified about the transaction boundaries of a
+// session. Statements are executed inside an implicit transaction when no
+// transaction block has been started. Implicit transactions are started before
+// the first statement is executed and are ended at Sync in the extended query
+// protocol or at the end of a (multi-statement) simple query. Implicit
+// transactions are rolled back when an error occurred. An implicit transaction
+// is turned into a transaction block when [BeginTx] is called, the transaction
+// is ended once [EndTx] is called.
+type TransactionHandler interface {
+	// Begin is called when a new transaction is started.
+	Begin(ctx context.Context) error
+	// Commit is called when the current transaction is committed.
+	Commit(ctx context.Context) error
+	// Rollback is called when the current transaction is rolled back.
+	Rollback(ctx context.Context) error
+}
+
+// SavepointHandler could be implemented by a [TransactionHandler] to be
+// notified about the savepoint transitions inside a transaction block.
+type SavepointHandler interface {
+	// Savepoint is called when a new savepoint is established.
+	Savepoint(ctx context.Context, name string) error
+	// ReleaseSavepoint is called when the given savepoint, and all savepoints
+	// established after it, are released.
+	ReleaseSavepoint(ctx context.Context, name string) error
+	// RollbackToSavepoint is called when the transaction is rolled back to the
+	// given savepoint. The savepoint itself remains established.
+	RollbackToSavepoint(ctx context.Context, name string) error
+}
+
+// BeginTx marks the start of a transaction block for the session inside the
+// given context. The status reported to the client inside ReadyForQuery
+// messages is [types.ServerTransactionBlock] until the transaction block is
+// ended using [EndTx]. A warning (25001) is send to the client as a notice when
+// a transaction is already in progress, the transaction state is left
+// untouched and the statement is expected to complete successfully.
+func BeginTx(ctx context.Context) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	if inTxBlock(session.TxStatus()) {
+		return notice(ctx, newErrActiveTransaction())
+	}
+
+	err := session.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+
+	session.settings.begin()
+
+	session.txMu.Lock()
+	defer session.txMu.Unlock()
+
+	session.txStatus = types.ServerTransactionBlock
+	return nil
+}
+
+// EndTx marks the end of the transaction block of the session inside the
+// given context. The transaction is committed or rolled back using the
+// configured [TransactionHandler]. A failed transaction is always rolled back,
+// even when commit is requested. Handlers could use [TxStatus] beforehand to
+// determine whether the transaction is committed or rolled back. A warning
+// (25P01) is send to the client as a notice when no transaction is in
+// progress, the statement is expected to complete successfully.
+func EndTx(ctx context.Context, commit bool) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	status := session.TxStatus()
+	if !inTxBlock(status) {
+		return notice(ctx, newErrNoActiveTransaction())
+	}
+
+	commit = commit && status != types.ServerTransactionFailed
+
+	session.txMu.Lock()
+	session.txStatus = types.ServerIdle
+	session.savepoints = nil
+	session.txMu.Unlock()
+
+	err := session.endCursors(ctx, commit)
+	if err != nil {
+		commit = false
+	}
+
+	session.settings.end(commit)
+	terr := session.endTx(ctx, commit)
+	if err != nil {
+		return err
+	}
+
+	return terr
+}
+
+// Savepoint establishes a new savepoint with the given name inside the
+// transaction block of the session inside the given context. Savepoints could
+// be established multiple times using the same name, the most recent savepoint
+// is used when it is released or rolled back to. An error (25P01) is returned
+// when no transaction block is in progress.
+func Savepoint(ctx context.Context, name string) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	if session.TxStatus() != types.ServerTransactionBlock {
+		return newErrSavepointOutsideTx("SAVEPOINT")
+	}
+
+	if handler, ok := session.Transactions.(SavepointHandler); ok {
+		err := handler.Savepoint(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	session.settings.savepoint()
+
+	session.txMu.Lock()
+	defer session.txMu.Unlock()
+
+	session.savepoints = append(session.savepoints, name)
+	return nil
+}
+
+// ReleaseSavepoint releases the most recent savepoint with the given name and
+// all savepoints established after it. An error (3B001) is returned when the
+// savepoint does not exist.
+func ReleaseSavepoint(ctx context.Context, name string) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	if session.TxStatus() != types.ServerTransactionBlock {
+		return newErrSavepointOutsideTx("RELEASE SAVEPOINT")
+	}
+
+	index := session.savepointIndex(name)
+	if index < 0 {
+		return newErrUnknownSavepoint(name)
+	}
+
+	if handler, ok := session.Transactions.(SavepointHandler); ok {
+		err := handler.ReleaseSavepoint(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	session.settings.release(index)
+
+	session.txMu.Lock()
+	defer session.txMu.Unlock()
+
+	session.savepoints = session.savepoints[:index]
+	return nil
+}
+
+// RollbackToSavepoint rolls back the transaction block to the most recent
+// savepoint with the given name. All savepoints established after it are
+// released, the savepoint itself remains established. A failed transaction
+// block is no longer marked as failed once rolled back to a savepoint. An
+// error (3B001) is returned when the savepoint does not exist.
+func RollbackToSavepoint(ctx context.Context, name string) error {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+
+	if !inTxBlock(session.TxStatus()) {
+		return newErrSavepointOutsideTx("ROLLBACK TO SAVEPOINT")
+	}
+
+	index := session.savepointIndex(name)
+	if index < 0 {
+		return newErrUnknownSavepoint(name)
+	}
+
+	if handler, ok := session.Transactions.(SavepointHandler); ok {
+		err := handler.RollbackToSavepoint(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	session.settings.rollback(index)
+
+	session.txMu.Lock()
+	defer session.txMu.Unlock()
+
+	session.savepoints = session.savepoints[:index+1]
+	session.txStatus = types.ServerTransactionBlock
+	return nil
+}
+
+// Savepoints returns the names of the savepoints established inside the
+// current transaction block of the session inside the given context. The
+// savepoints are ordered from the oldest to the most recent.
+func Savepoints(ctx context.Context) []string {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return nil
+	}
+
+	session.txMu.Lock()
+	defer session.txMu.Unlock()
+	return slices.Clone(session.savepoints)
+}
+
+// TxStatus returns the transaction status of the session inside the given
+// context. [types.ServerIdle] is returned when no session has been found.
+func TxStatus(ctx context.Context) types.ServerStatus {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return types.ServerIdle
+	}
+
+	return session.TxStatus()
+}
+
+// TxStatus returns the current transaction status of the session.
+func (srv *Session) TxStatus() types.ServerStatus {
+	srv.txMu.Lock()
+	defer srv.txMu.Unlock()
+
+	if !inTxBlock(srv.txStatus) {
+		return types.ServerIdle
+	}
+
+	return srv.txStatus
+}
+
+// beginTx starts a new transaction using the configured transaction handler
+// when no transaction has been started yet.
+func (srv *Session) beginTx(ctx context.Context) error {
+	if srv.Transactions == nil {
+		return nil
+	}
+
+	srv.txMu.Lock()
+	open := srv.txOpen
+	srv.txOpen = true
+	srv.txMu.Unlock()
+
+	if open {
+		return nil
+	}
+
+	err := srv.Transactions.Begin(ctx)
+	if err != nil {
+		srv.txMu.Lock()
+		srv.txOpen = false
+		srv.txMu.Unlock()
+	}
+
+	return err
+}
+
+// endTx commits or rolls back the current transaction using the configured
+// transaction handler. Nothing happens when no transaction has been started.
+func (srv *Session) endTx(ctx context.Context, commit bool) error {
+	srv.txMu.Lock()
+	open := srv.txOpen
+	srv.txOpen = false
+	srv.txMu.Unlock()
+
+	if !open {
+		return nil
+	}
+
+	if commit {
+		return srv.Transactions.Commit(ctx)
+	}
+
+	return srv.Transactions.Rollback(ctx)
+}
+
+// endImplicitTx commits or rolls back the current implicit transaction.
+// Nothing happens when a transaction block is in progress.
+func (srv *Session) endImplicitTx(ctx context.Context, commit bool) error {
+	if inTxBlock(srv.TxStatus()) {
+		return nil
+	}
+
+	err := srv.endCursors(ctx, commit)
+	if err != nil {
+		commit = false
+	}
+
+	srv.settings.endImplicit(commit)
+	terr := srv.endTx(ctx, commit)
+	if err != nil {
+		return err
+	}
+
+	return terr
+}
+
+// rollbackAndWriteError rolls back the current implicit transaction and writes
+// the given error to the client.
+func (srv *Session) rollbackAndWriteError(ctx context.Context, writer *buffer.Writer, err error) error {
+	rerr := srv.endImplicitTx(ctx, false)
+	if rerr != nil {
+		srv.logger.Error("unable to rollback implicit transaction", slog.String("err", rerr.Error()))
+	}
+
+	return srv.WriteError(writer, err)
+}
+
+// closeTx rolls back any transaction which is still in progress once the
+// session is closed.
+func (srv *Session) closeTx(ctx context.Context) {
+	srv.txMu.Lock()
+	srv.txStatus = types.ServerIdle
+	srv.savepoints = nil
+	srv.txMu.Unlock()
+
+	srv.closeCursors(ctx, func(*cursor) bool { return true })
+
+	err := srv.endTx(ctx, false)
+	if err != nil {
+		srv.logger.Error("unable to rollback transaction", slog.String("err", err.Error()))
+	}
+}
+
+// savepointIndex returns the index of the most recent savepoint with the given
+// name. -1 is returned when no savepoint with the given name exists.
+func (srv *Session) savepointIndex(name string) int {
+	srv.txMu.Lock()
+	defer srv.txMu.Unlock()
+
+	for index := len(srv.savepoints) - 1; index >= 0; index-- {
+		if srv.savepoints[index] == name {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// failTx marks the current transaction block as failed. Statements are
+// rejected until the transaction block is ended. Nothing happens when no
+// transaction is in progress.
+func (srv *Session) failTx() {
+	srv.txMu.Lock()
+	defer srv.txMu.Unlock()
+
+	if srv.txStatus == types.ServerTransactionBlock {
+		srv.txStatus = types.ServerTransactionFailed
+	}
+}
+
+// checkTxFailed returns an error when the current transaction has failed and
+// the given query does not end the transaction block.
+func (srv *Session) checkTxFailed(query string) error {
+	if srv.TxStatus() != types.ServerTransactionFailed || isTxExitQuery(query) {
+		return nil
+	}
+
+	return newErrInFailedTransaction()
+}
+
+// readyForQuery writes a ReadyForQuery message including the current
+// transaction status of the session. Changed configuration parameters are
+// reported to the client beforehand.
+func (srv *Session) readyForQuery(writer *buffer.Writer) error {
+	err := srv.writeNotices(writer)
+	if err != nil {
+		return err
+	}
+
+	err = srv.settings.report(writer)
+	if err != nil {
+		return err
+	}
+
+	return readyForQuery(writer, srv.TxStatus())
+}
+
+// inTxBlock checks whether the given status indicates that a transaction block
+// is in progress.
+func inTxBlock(status types.ServerStatus) bool {
+	return status == types.ServerTransactionBlock || status == types.ServerTransactionFailed
+}
+
+// isTxExitQuery checks whether the given query ends a (failed) transaction
+// block. These statements are allowed to be executed inside a failed
+// transaction. Queries containing multiple statements are rejected, preventing
+// the remaining statements from being executed inside the failed transaction.
+func isTxExitQuery(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 || multipleStatements(query) {
+		return false
+	}
+
+	switch strings.ToUpper(strings.TrimSuffix(fields[0], ";")) {
+	case "ROLLBACK", "ABORT", "COMMIT", "END":
+		return true
+	default:
+		return false
+	}
+}
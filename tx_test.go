@@ -0,0 +1,434 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+// txHandler returns a parse function handling BEGIN, COMMIT, ROLLBACK and
+// savepoint statements. Queries starting with FAIL return an error, all other queries
+// complete successfully. Multiple statements are separated by a semicolon.
+func txHandler(ctx context.Context, query string) (PreparedStatements, error) {
+	var statements PreparedStatements
+	for _, statement := range strings.Split(query, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+
+		statements = append(statements, txStatement(statement))
+	}
+
+	return statements, nil
+}
+
+func txStatement(query string) *PreparedStatement {
+	fields := strings.Fields(query)
+	keyword := strings.ToUpper(fields[0])
+	savepoint := fields[len(fields)-1]
+
+	handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+		switch keyword {
+		case "SAVEPOINT":
+			err := Savepoint(ctx, savepoint)
+			if err != nil {
+				return err
+			}
+		case "RELEASE":
+			err := ReleaseSavepoint(ctx, savepoint)
+			if err != nil {
+				return err
+			}
+		case "ROLLBACK":
+			if len(fields) > 1 && strings.EqualFold(fields[1], "TO") {
+				err := RollbackToSavepoint(ctx, savepoint)
+				if err != nil {
+					return err
+				}
+
+				break
+			}
+
+			err := EndTx(ctx, false)
+			if err != nil {
+				return err
+			}
+		case "BEGIN":
+			err := BeginTx(ctx)
+			if err != nil {
+				return err
+			}
+		case "COMMIT":
+			if TxStatus(ctx) == types.ServerTransactionFailed {
+				keyword = "ROLLBACK"
+			}
+
+			err := EndTx(ctx, true)
+			if err != nil {
+				return err
+			}
+		case "FAIL":
+			return errors.New("unexpected failure")
+		}
+
+		return writer.Complete(keyword)
+	}
+
+	return NewStatement(handle)
+}
+
+// recordingTxHandler records the transaction boundaries it is notified about.
+type recordingTxHandler struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (handler *recordingTxHandler) record(event string) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	handler.events = append(handler.events, event)
+	return nil
+}
+
+func (handler *recordingTxHandler) Begin(ctx context.Context) error {
+	return handler.record("begin")
+}
+
+func (handler *recordingTxHandler) Commit(ctx context.Context) error {
+	return handler.record("commit")
+}
+
+func (handler *recordingTxHandler) Rollback(ctx context.Context) error {
+	return handler.record("rollback")
+}
+
+func (handler *recordingTxHandler) Savepoint(ctx context.Context, name string) error {
+	return handler.record("savepoint " + name)
+}
+
+func (handler *recordingTxHandler) ReleaseSavepoint(ctx context.Context, name string) error {
+	return handler.record("release " + name)
+}
+
+func (handler *recordingTxHandler) RollbackToSavepoint(ctx context.Context, name string) error {
+	return handler.record("rollback to " + name)
+}
+
+// flush returns and clears the recorded events.
+func (handler *recordingTxHandler) flush() []string {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	events := handler.events
+	handler.events = nil
+	return events
+}
+
+func TestTransactionStatus(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(txHandler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	connstr := fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port)
+
+	modes := map[string]pgx.QueryExecMode{
+		"simple":   pgx.QueryExecModeSimpleProtocol,
+		"extended": pgx.QueryExecModeExec,
+	}
+
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			config, err := pgx.ParseConfig(connstr)
+			require.NoError(t, err)
+
+			var notices []string
+			config.OnNotice = func(_ *pgconn.PgConn, notice *pgconn.Notice) {
+				notices = append(notices, notice.Severity+" "+notice.Code)
+			}
+
+			conn, err := pgx.ConnectConfig(ctx, config)
+			require.NoError(t, err)
+			defer conn.Close(ctx) //nolint:errcheck
+
+			exec := func(query string) (pgconn.CommandTag, error) {
+				return conn.Exec(ctx, query, mode)
+			}
+
+			require.Equal(t, byte(types.ServerIdle), conn.PgConn().TxStatus())
+
+			_, err = exec("BEGIN")
+			require.NoError(t, err)
+			require.Equal(t, byte(types.ServerTransactionBlock), conn.PgConn().TxStatus())
+
+			// NOTE: nested transaction blocks complete with a warning notice.
+			_, err = exec("BEGIN")
+			require.NoError(t, err)
+			require.Equal(t, byte(types.ServerTransactionBlock), conn.PgConn().TxStatus())
+
+			_, err = exec("SELECT 1")
+			require.NoError(t, err)
+			require.Equal(t, byte(types.ServerTransactionBlock), conn.PgConn().TxStatus())
+
+			_, err = exec("COMMIT")
+			require.NoError(t, err)
+			require.Equal(t, byte(types.ServerIdle), conn.PgConn().TxStatus())
+
+			_, err = exec("COMMIT")
+			require.NoError(t, err)
+			require.Equal(t, byte(types.ServerIdle), conn.PgConn().TxStatus())
+
+			expected := []string{
+				"WARNING " + string(codes.ActiveSQLTransaction),
+				"WARNING " + string(codes.NoActiveSQLTransaction),
+			}
+
+			require.Equal(t, expected, notices)
+
+			_, err = exec("FAIL")
+			require.Error(t, err)
+			require.Equal(t, byte(types.ServerIdle), conn.PgConn().TxStatus())
+
+			_, err = exec("BEGIN")
+			require.NoError(t, err)
+
+			_, err = exec("FAIL")
+			require.Error(t, err)
+			require.Equal(t, byte(types.ServerTransactionFailed), conn.PgConn().TxStatus())
+
+			_, err = exec("SELECT 1")
+			var pgErr *pgconn.PgError
+			require.ErrorAs(t, err, &pgErr)
+			require.Equal(t, string(codes.InFailedSQLTransaction), pgErr.Code)
+			require.Equal(t, byte(types.ServerTransactionFailed), conn.PgConn().TxStatus())
+
+			tag, err := exec("COMMIT")
+			require.NoError(t, err)
+			require.Equal(t, "ROLLBACK", tag.String())
+			require.Equal(t, byte(types.ServerIdle), conn.PgConn().TxStatus())
+
+			_, err = exec("SELECT 1")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestTransactionHandler(t *testing.T) {
+	t.Parallel()
+
+	modes := map[string]pgx.QueryExecMode{
+		"simple":   pgx.QueryExecModeSimpleProtocol,
+		"extended": pgx.QueryExecModeExec,
+	}
+
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &recordingTxHandler{}
+			server, err := NewServer(txHandler, Logger(slogt.New(t)), Transactions(handler))
+			require.NoError(t, err)
+
+			address := TListenAndServe(t, server)
+
+			ctx := context.Background()
+			conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+			require.NoError(t, err)
+
+			handler.flush()
+
+			exec := func(query string) error {
+				_, err := conn.Exec(ctx, query, mode)
+				return err
+			}
+
+			require.NoError(t, exec("SELECT 1"))
+			require.Equal(t, []string{"begin", "commit"}, handler.flush())
+
+			require.Error(t, exec("FAIL"))
+			require.Equal(t, []string{"begin", "rollback"}, handler.flush())
+
+			require.NoError(t, exec("BEGIN"))
+			require.NoError(t, exec("SELECT 1"))
+			require.NoError(t, exec("COMMIT"))
+			require.Equal(t, []string{"begin", "commit"}, handler.flush())
+
+			require.NoError(t, exec("BEGIN"))
+			require.Error(t, exec("FAIL"))
+			require.NoError(t, exec("COMMIT"))
+			require.Equal(t, []string{"begin", "rollback"}, handler.flush())
+
+			require.NoError(t, exec("BEGIN"))
+			require.NoError(t, conn.Close(ctx))
+			require.Eventually(t, func() bool {
+				handler.mu.Lock()
+				defer handler.mu.Unlock()
+				return len(handler.events) == 2
+			}, time.Second, 10*time.Millisecond)
+			require.Equal(t, []string{"begin", "rollback"}, handler.flush())
+		})
+	}
+
+	t.Run("multiple statements", func(t *testing.T) {
+		t.Parallel()
+
+		handler := &recordingTxHandler{}
+		server, err := NewServer(txHandler, Logger(slogt.New(t)), Transactions(handler))
+		require.NoError(t, err)
+
+		address := TListenAndServe(t, server)
+
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+		require.NoError(t, err)
+		defer conn.Close(ctx) //nolint:errcheck
+
+		handler.flush()
+
+		_, err = conn.Exec(ctx, "SELECT 1; SELECT 2", pgx.QueryExecModeSimpleProtocol)
+		require.NoError(t, err)
+		require.Equal(t, []string{"begin", "commit"}, handler.flush())
+
+		_, err = conn.Exec(ctx, "SELECT 1; FAIL; SELECT 2", pgx.QueryExecModeSimpleProtocol)
+		require.Error(t, err)
+		require.Equal(t, []string{"begin", "rollback"}, handler.flush())
+
+		_, err = conn.Exec(ctx, "BEGIN; SELECT 1; COMMIT; SELECT 2", pgx.QueryExecModeSimpleProtocol)
+		require.NoError(t, err)
+		require.Equal(t, []string{"begin", "commit", "begin", "commit"}, handler.flush())
+
+		batch := &pgx.Batch{}
+		batch.Queue("SELECT 1")
+		batch.Queue("SELECT 2")
+		require.NoError(t, conn.SendBatch(ctx, batch).Close())
+		require.Equal(t, []string{"begin", "commit"}, handler.flush())
+
+		batch = &pgx.Batch{}
+		batch.Queue("SELECT 1")
+		batch.Queue("FAIL")
+		batch.Queue("SELECT 2")
+		require.Error(t, conn.SendBatch(ctx, batch).Close())
+		require.Equal(t, []string{"begin", "rollback"}, handler.flush())
+	})
+}
+
+func TestSavepoints(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordingTxHandler{}
+	server, err := NewServer(txHandler, Logger(slogt.New(t)), Transactions(handler))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	handler.flush()
+
+	exec := func(query string) error {
+		_, err := conn.Exec(ctx, query)
+		return err
+	}
+
+	code := func(err error) string {
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		return pgErr.Code
+	}
+
+	require.Equal(t, string(codes.NoActiveSQLTransaction), code(exec("SAVEPOINT a")))
+	require.Equal(t, []string{"begin", "rollback"}, handler.flush())
+
+	require.NoError(t, exec("BEGIN"))
+	require.NoError(t, exec("SAVEPOINT a"))
+	require.NoError(t, exec("SAVEPOINT b"))
+	require.NoError(t, exec("SAVEPOINT a"))
+
+	require.Error(t, exec("FAIL"))
+	require.Equal(t, byte(types.ServerTransactionFailed), conn.PgConn().TxStatus())
+	require.Equal(t, string(codes.InFailedSQLTransaction), code(exec("RELEASE SAVEPOINT a")))
+
+	require.NoError(t, exec("ROLLBACK TO SAVEPOINT b"))
+	require.Equal(t, byte(types.ServerTransactionBlock), conn.PgConn().TxStatus())
+
+	require.Equal(t, string(codes.InvalidSavepointSpecification), code(exec("ROLLBACK TO unknown")))
+	require.Equal(t, byte(types.ServerTransactionFailed), conn.PgConn().TxStatus())
+	require.NoError(t, exec("ROLLBACK TO b"))
+
+	require.NoError(t, exec("RELEASE b"))
+	require.NoError(t, exec("SELECT 1"))
+	require.NoError(t, exec("COMMIT"))
+	require.Equal(t, byte(types.ServerIdle), conn.PgConn().TxStatus())
+
+	require.Equal(t, []string{
+		"begin",
+		"savepoint a",
+		"savepoint b",
+		"savepoint a",
+		"rollback to b",
+		"rollback to b",
+		"release b",
+		"commit",
+	}, handler.flush())
+}
+
+func TestSavepointStack(t *testing.T) {
+	t.Parallel()
+
+	session := &Session{Server: &Server{}}
+	ctx := context.WithValue(context.Background(), sessionKey, session)
+
+	require.NoError(t, BeginTx(ctx))
+	require.NoError(t, Savepoint(ctx, "a"))
+	require.NoError(t, Savepoint(ctx, "b"))
+	require.NoError(t, Savepoint(ctx, "c"))
+	require.Equal(t, []string{"a", "b", "c"}, Savepoints(ctx))
+
+	require.NoError(t, RollbackToSavepoint(ctx, "b"))
+	require.Equal(t, []string{"a", "b"}, Savepoints(ctx))
+
+	require.NoError(t, ReleaseSavepoint(ctx, "a"))
+	require.Empty(t, Savepoints(ctx))
+
+	require.NoError(t, Savepoint(ctx, "a"))
+	require.NoError(t, EndTx(ctx, true))
+	require.Empty(t, Savepoints(ctx))
+}
+
+func TestTransactionWithoutSession(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	require.ErrorIs(t, BeginTx(ctx), ErrNoSession)
+	require.ErrorIs(t, EndTx(ctx, true), ErrNoSession)
+	require.Equal(t, types.ServerStatus(types.ServerIdle), TxStatus(ctx))
+}
+
+func TestIsTxExitQuery(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, isTxExitQuery("ROLLBACK"))
+	require.True(t, isTxExitQuery("  rollback to savepoint a"))
+	require.True(t, isTxExitQuery("commit;"))
+	require.True(t, isTxExitQuery("END"))
+	require.True(t, isTxExitQuery("abort"))
+	require.False(t, isTxExitQuery("SELECT 1"))
+	require.False(t, isTxExitQuery(""))
+	require.False(t, isTxExitQuery("ROLLBACK; SELECT 1"))
+	require.False(t, isTxExitQuery("COMMIT; DROP TABLE jedis"))
+}
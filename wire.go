@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -88,14 +90,15 @@ func ListenAndServe(address string, handler ParseFn) error {
 // NewServer constructs a new Postgres server using the given address and server options.
 func NewServer(parse ParseFn, options ...OptionFn) (*Server, error) {
 	srv := &Server{
-		parse:           parse,
-		logger:          slog.Default(),
-		closer:          make(chan struct{}),
-		ClientAuth:      tls.NoClientCert,
-		Statements:      DefaultStatementCacheFn,
-		Portals:         DefaultPortalCacheFn,
-		Session:         func(ctx context.Context) (context.Context, error) { return ctx, nil },
-		ShutdownTimeout: 1 * time.Second,
+		parse:                 parse,
+		logger:                slog.Default(),
+		closer:                make(chan struct{}),
+		ClientAuth:            tls.NoClientCert,
+		Statements:            DefaultStatementCacheFn,
+		Portals:               DefaultPortalCacheFn,
+		Session:               func(ctx context.Context) (context.Context, error) { return ctx, nil },
+		ShutdownTimeout:       1 * time.Second,
+		UnixSocketPermissions: 0o777,
 	}
 
 	for _, option := range options {
@@ -124,28 +127,40 @@ type Server struct {
 	// for them to exit before the test's `t` becomes invalid.
 	//
 	// Additionally it also waits for the Go routine
-	connWg           sync.WaitGroup
-	logger           *slog.Logger
-	Auth             AuthStrategy
-	BackendKeyData   BackendKeyDataFunc
-	CancelRequest    CancelRequestFn
-	BufferedMsgSize  int
-	Parameters       Parameters
-	TLSConfig        *tls.Config
-	ClientAuth       tls.ClientAuthType
-	parse            ParseFn
-	Session          SessionHandler
-	Statements       func() StatementCache
-	Portals          func() PortalCache
-	CloseConn        CloseFn
-	TerminateConn    CloseFn
-	FlushConn        FlushFn
-	ParallelPipeline ParallelPipelineConfig
-	ErrorSanitizer   func(error) error
-	Version          string
-	ShutdownTimeout  time.Duration
-	typeExtension    func(*pgtype.Map)
-	closer           chan struct{}
+	connWg                sync.WaitGroup
+	logger                *slog.Logger
+	Auth                  AuthStrategy
+	HostBasedAuth         []HBARule
+	BackendKeyData        BackendKeyDataFunc
+	CancelRequest         CancelRequestFn
+	BufferedMsgSize       int
+	Parameters            Parameters
+	TLSConfig             *tls.Config
+	ClientAuth            tls.ClientAuthType
+	ProtocolExtensions    []string
+	parse                 ParseFn
+	Session               SessionHandler
+	Statements            func() StatementCache
+	Portals               func() PortalCache
+	Transactions          TransactionHandler
+	CloseConn             CloseFn
+	TerminateConn         CloseFn
+	FlushConn             FlushFn
+	ParallelPipeline      ParallelPipelineConfig
+	QueryCancellation     bool
+	Cursors               bool
+	ScrollableCursors     ScrollableCursorsConfig
+	SessionSettings       bool
+	Replication           ReplicationHandler
+	Notifications         *NotificationHub
+	ErrorSanitizer        func(error) error
+	Version               string
+	ShutdownTimeout       time.Duration
+	UnixSocketPermissions os.FileMode
+	typeExtension         func(*pgtype.Map)
+	cancellation          *cancelRegistry
+	cancellationOnce      sync.Once
+	closer                chan struct{}
 }
 
 // ListenAndServe opens a new Postgres server on the preconfigured address and
@@ -159,6 +174,60 @@ func (srv *Server) ListenAndServe(address string) error {
 	return srv.Serve(listener)
 }
 
+// ListenAndServeUnix opens a new Postgres server listening on a Unix domain
+// socket inside the given directory and starts accepting and serving incoming
+// client connections. The socket is named following the libpq convention
+// .s.PGSQL.<port>, allowing clients to connect using the directory as host
+// (ex: psql -h /run/app -p 5432). A stale socket left behind by a previous
+// server is removed. The permissions of the socket are configured through
+// [UnixSocketPermissions].
+func (srv *Server) ListenAndServeUnix(dir string, port int) error {
+	path := filepath.Join(dir, fmt.Sprintf(".s.PGSQL.%d", port))
+	err := removeStaleSocket(path)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(path, srv.UnixSocketPermissions)
+	if err != nil {
+		listener.Close() //nolint:errcheck
+		return err
+	}
+
+	return srv.Serve(listener)
+}
+
+// removeStaleSocket removes the Unix domain socket at the given path if it is
+// no longer in use. An error is returned when the path is in use or is not a
+// socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unable to listen on %s: file exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close() //nolint:errcheck
+		return fmt.Errorf("unable to listen on %s: socket is already in use", path)
+	}
+
+	return os.Remove(path)
+}
+
 // Serve accepts and serves incoming Postgres client connections using the
 // preconfigured configurations. The given listener will be closed once the
 // server is gracefully closed.
@@ -233,9 +302,18 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 	ctx = setRemoteAddress(ctx, conn.RemoteAddr())
 	defer conn.Close() //nolint:errcheck
 
+	if unix, ok := conn.(*net.UnixConn); ok {
+		credentials, err := peerCredentials(unix)
+		if err != nil {
+			srv.logger.Debug("unable to read peer credentials", "err", err)
+		} else {
+			ctx = setPeerCredentials(ctx, credentials)
+		}
+	}
+
 	srv.logger.Debug("serving a new client connection")
 
-	conn, version, reader, err := srv.Handshake(conn)
+	ctx, conn, version, reader, err := srv.handshake(ctx, conn)
 	if err != nil {
 		return err
 	}
@@ -248,8 +326,36 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 
 	writer := buffer.NewWriter(srv.logger, conn)
 	writer.ErrorSanitizer = srv.ErrorSanitizer
-	ctx, err = srv.readClientParameters(ctx, reader)
+
+	requested := version
+	version, err = negotiateVersion(requested)
+	if err != nil {
+		werr := WriteUnterminatedError(writer, err)
+		if werr != nil {
+			return werr
+		}
+
+		return err
+	}
+
+	ctx = setProtocolVersion(ctx, version)
+	ctx, options, err := srv.readClientParameters(ctx, reader)
+	if err != nil {
+		return err
+	}
+
+	ctx, err = srv.negotiateProtocol(ctx, writer, requested, version, options)
+	if err != nil {
+		return err
+	}
+
+	replication, err := srv.replicationMode(ctx)
 	if err != nil {
+		werr := WriteUnterminatedError(writer, err)
+		if werr != nil {
+			return werr
+		}
+
 		return err
 	}
 
@@ -258,10 +364,48 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 		return err
 	}
 
-	// Send BackendKeyData if a BackendKeyDataFunc is configured
-	if srv.BackendKeyData != nil {
+	session := &Session{
+		Server:           srv,
+		Statements:       srv.Statements(),
+		Portals:          srv.Portals(),
+		Attributes:       make(map[string]interface{}),
+		ParallelPipeline: srv.ParallelPipeline,
+		ProtocolVersion:  version,
+		replication:      replication,
+		notifications:    newNotificationQueue(),
+	}
+
+	// Send BackendKeyData if the cancellation registry or a BackendKeyDataFunc
+	// is configured
+	switch {
+	case srv.QueryCancellation:
+		srv.logger.Debug("registering session for query cancellation")
+		length := minSecretKeyLength
+		if version >= types.Version32 {
+			length = registrySecretKeyLength
+		}
+
+		processID, secretKey, err := srv.cancelRegistry().register(session, length)
+		if err != nil {
+			return err
+		}
+
+		defer srv.cancelRegistry().unregister(processID)
+		session.processID = processID
+
+		err = writeBackendKeyData(writer, processID, secretKey)
+		if err != nil {
+			return err
+		}
+	case srv.BackendKeyData != nil:
 		srv.logger.Debug("sending backend key data")
 		processID, secretKey := srv.BackendKeyData(ctx)
+		if !validSecretKey(version, secretKey) {
+			return fmt.Errorf("invalid backend key data secret key length %d for protocol version %d.%d", len(secretKey), version.Major(), version.Minor())
+		}
+
+		session.processID = processID
+
 		err = writeBackendKeyData(writer, processID, secretKey)
 		if err != nil {
 			return err
@@ -275,6 +419,8 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 		return err
 	}
 
+	session.settings.seed(ServerParameters(ctx), ClientParameters(ctx))
+
 	ctx, err = srv.Session(ctx)
 	if err != nil {
 		return err
@@ -288,14 +434,6 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 		}()
 	}
 
-	session := &Session{
-		Server:           srv,
-		Statements:       srv.Statements(),
-		Portals:          srv.Portals(),
-		Attributes:       make(map[string]interface{}),
-		ParallelPipeline: srv.ParallelPipeline,
-	}
-
 	if srv.ParallelPipeline.Enabled {
 		session.ResponseQueue = NewResponseQueue()
 	}
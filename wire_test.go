@@ -114,6 +114,128 @@ func TestClientConnect(t *testing.T) {
 	})
 }
 
+func TestUnsupportedProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(nil, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	conn, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+
+	client := mock.NewClient(t, conn)
+	client.HandshakeVersion(t, types.Version(2<<16))
+	client.Error(t)
+}
+
+func TestNegotiateProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		version      types.Version
+		params       map[string]string
+		negotiated   types.Version
+		unrecognized []string
+		extensions   map[string]string
+	}
+
+	tests := map[string]test{
+		"supported": {
+			version:    types.Version32,
+			negotiated: types.Version32,
+		},
+		"newer minor version": {
+			version:      types.Version(3<<16 | 5),
+			negotiated:   types.Version32,
+			unrecognized: []string{},
+		},
+		"unsupported minor version": {
+			version:      types.Version(3<<16 | 1),
+			negotiated:   types.Version30,
+			unrecognized: []string{},
+		},
+		"unrecognized extension": {
+			version:      types.Version30,
+			params:       map[string]string{"_pq_.unknown": "on", "_pq_.compression": "lz4"},
+			negotiated:   types.Version30,
+			unrecognized: []string{"_pq_.unknown"},
+			extensions:   map[string]string{"compression": "lz4"},
+		},
+		"registered extension": {
+			version:    types.Version30,
+			params:     map[string]string{"_pq_.compression": "lz4"},
+			negotiated: types.Version30,
+			extensions: map[string]string{"compression": "lz4"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			extensions := make(chan map[string]string, 1)
+			session := func(ctx context.Context) (context.Context, error) {
+				negotiated := map[string]string{}
+				for _, name := range []string{"compression", "unknown"} {
+					if value, ok := ProtocolExtension(ctx, name); ok {
+						negotiated[name] = value
+					}
+				}
+
+				for key := range ClientParameters(ctx) {
+					require.NotContains(t, key, "_pq_.")
+				}
+
+				extensions <- negotiated
+				return ctx, nil
+			}
+
+			server, err := NewServer(nil, Logger(slogt.New(t)), SessionMiddleware(session), ProtocolExtensions("_pq_.compression"))
+			require.NoError(t, err)
+
+			address := TListenAndServe(t, server)
+
+			conn, err := net.Dial("tcp", address.String())
+			require.NoError(t, err)
+
+			client := mock.NewClient(t, conn)
+			client.HandshakeParameters(t, test.version, test.params)
+
+			if test.unrecognized != nil {
+				client.ExpectMsg(t, types.ServerNegotiateProtocol)
+
+				version, err := client.GetUint32()
+				require.NoError(t, err)
+				require.Equal(t, test.negotiated, types.Version(version))
+
+				length, err := client.GetUint32()
+				require.NoError(t, err)
+
+				unrecognized := make([]string, length)
+				for index := range unrecognized {
+					unrecognized[index], err = client.GetString()
+					require.NoError(t, err)
+				}
+
+				require.Equal(t, test.unrecognized, unrecognized)
+			}
+
+			client.Authenticate(t)
+			client.ReadyForQuery(t)
+
+			expected := test.extensions
+			if expected == nil {
+				expected = map[string]string{}
+			}
+
+			require.Equal(t, expected, <-extensions)
+			client.Close(t)
+		})
+	}
+}
+
 func TestClientParameters(t *testing.T) {
 	t.Parallel()
 
@@ -43,6 +43,34 @@ type DataWriter interface {
 	CopyIn(format FormatCode) (*CopyReader, error)
 }
 
+// CopyOutWriter could be implemented by a [DataWriter] to support CopyOut
+// operations. The data writers passed to statement handlers by the server
+// implement the interface, handlers could type-assert the given data writer.
+type CopyOutWriter interface {
+	// CopyOut sends a [CopyOutResponse] to the client, to initiate a CopyOut
+	// operation. The returned copy writer encodes the written rows using the
+	// defined columns and the given format and options. The format defined
+	// inside the given options is replaced by the given format. The copy
+	// operation is completed by calling Complete on the copy writer.
+	//
+	// [CopyOutResponse]: https://www.postgresql.org/docs/current/protocol-message-formats.html#PROTOCOL-MESSAGE-FORMATS-COPYOUTRESPONSE
+	CopyOut(format CopyFormat, options CopyOptions) (*CopyWriter, error)
+}
+
+// NoticeWriter could be implemented by a [DataWriter] to send notices in
+// between the rows of a statement. The data writers passed to statement
+// handlers by the server implement the interface, handlers could type-assert
+// the given data writer or use [Notice] instead.
+type NoticeWriter interface {
+	// Notice sends the given error as a [NoticeResponse] to the client. The
+	// severity of the error defaults to NOTICE. Notices could be send at any
+	// moment during the execution of the statement and are discarded when
+	// the severity is below the client_min_messages level of the session.
+	//
+	// [NoticeResponse]: https://www.postgresql.org/docs/current/protocol-message-formats.html#PROTOCOL-MESSAGE-FORMATS-NOTICERESPONSE
+	Notice(err error) error
+}
+
 // ErrDataWritten is returned when an empty result is attempted to be sent to the
 // client while data has already been written.
 var ErrDataWritten = errors.New("data has already been written")
@@ -78,7 +106,12 @@ func (writer *dataWriter) Row(values []any) error {
 		return ErrClosedWriter
 	}
 
-	err := writer.columns.Write(writer.ctx, writer.formats, writer.client, values)
+	err := writer.writeNotices()
+	if err != nil {
+		return err
+	}
+
+	err = writer.columns.Write(writer.ctx, writer.formats, writer.client, values)
 	if err != nil {
 		return err
 	}
@@ -98,13 +131,61 @@ func (writer *dataWriter) CopyIn(format FormatCode) (*CopyReader, error) {
 		return nil, ErrClosedWriter
 	}
 
-	err := writer.columns.CopyIn(writer.ctx, writer.client, format)
+	err := writer.writeNotices()
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.columns.CopyIn(writer.ctx, writer.client, format)
 	if err != nil {
 		return nil, err
 	}
 	return NewCopyReader(writer.session, writer.reader, writer.client, writer.columns), nil
 }
 
+func (writer *dataWriter) CopyOut(format CopyFormat, options CopyOptions) (*CopyWriter, error) {
+	if writer.closed {
+		return nil, ErrClosedWriter
+	}
+
+	copy, err := NewCopyWriter(writer.ctx, writer.client, writer.columns, format, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.writeNotices()
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.columns.CopyOut(writer.ctx, writer.client, format.FormatCode())
+	if err != nil {
+		return nil, err
+	}
+
+	copy.complete = writer.Complete
+	return copy, nil
+}
+
+func (writer *dataWriter) Notice(err error) error {
+	werr := writer.writeNotices()
+	if werr != nil {
+		return werr
+	}
+
+	return writeNotice(writer.ctx, writer.client, err)
+}
+
+// writeNotices writes the notices queued by the session before the next
+// message of the statement, preserving the order in which notices are send.
+func (writer *dataWriter) writeNotices() error {
+	if writer.session == nil {
+		return nil
+	}
+
+	return writer.session.writeNotices(writer.client)
+}
+
 func (writer *dataWriter) Empty() error {
 	if writer.closed {
 		return ErrClosedWriter
@@ -129,6 +210,12 @@ func (writer *dataWriter) Complete(description string) error {
 
 	defer writer.close()
 	*writer.tag = description
+
+	err := writer.writeNotices()
+	if err != nil {
+		return err
+	}
+
 	return commandComplete(writer.client, description)
 }
 